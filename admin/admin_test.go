@@ -0,0 +1,99 @@
+package admin
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/barracudanetworks/wbd/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := os.MkdirTemp("", "wbd")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	db, _ := database.Connect(":memory:")
+	defer db.Close()
+
+	db.CreateTables()
+
+	changes := 0
+	server := &Server{
+		Database: db,
+		Changed:  func() { changes++ },
+		Connections: func() []Connection {
+			return []Connection{{Id: "test", List: "Default"}}
+		},
+	}
+
+	path := filepath.Join(dir, "wbd.sock")
+	_, err = Dial(path)
+	assert.Equal(ErrNotRunning, err)
+
+	l, err := Listen(path)
+	assert.Nil(err)
+	defer l.Close()
+	go server.Serve(l)
+
+	// Only one daemon at a time
+	_, err = Listen(path)
+	assert.Equal(ErrInUse, err)
+
+	client, err := Dial(path)
+	assert.Nil(err)
+	defer client.Close()
+
+	assert.Nil(client.InsertUrl("http://barracudanetworks.com/"))
+	assert.Equal(database.ErrUrlExists, client.InsertUrl("http://barracudanetworks.com/"))
+	assert.Nil(client.AssignUrlToList("Default", "http://barracudanetworks.com/"))
+	assert.Nil(client.SetListUrlDuration("Default", "http://barracudanetworks.com/", 30))
+	assert.Equal(4, changes)
+
+	urls, err := db.FetchListUrlsById(database.DefaultList)
+	assert.Nil(err)
+	assert.Equal([]database.ListUrl{{Url: "http://barracudanetworks.com/", Duration: 30}}, urls)
+
+	list, err := client.GetList("Default")
+	assert.Nil(err)
+	assert.Equal(database.ModeSequential, list.Mode)
+
+	_, err = client.GetClient("missing")
+	assert.Equal(sql.ErrNoRows, err)
+
+	connections, err := client.Connections()
+	assert.Nil(err)
+	assert.Equal("test", connections[0].Id)
+
+	_, err = Local(db).Connections()
+	assert.Equal(ErrNotRunning, err)
+}
+
+func TestListen(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := os.MkdirTemp("", "wbd")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wbd.sock")
+	l, err := Listen(path)
+	assert.Nil(err)
+
+	// Only the daemon's user can connect, and nothing else is left around
+	info, err := os.Stat(path)
+	assert.Nil(err)
+	assert.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	entries, _ := os.ReadDir(dir)
+	assert.Len(entries, 1)
+
+	// Stopping removes the socket
+	assert.Nil(l.Close())
+	_, err = os.Stat(path)
+	assert.True(os.IsNotExist(err))
+}
@@ -0,0 +1,354 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/barracudanetworks/wbd/database"
+)
+
+const dialTimeout = 2 * time.Second
+
+var _ Store = &Client{}
+
+// Client sends CLI requests to the daemon over its admin socket, as the
+// user running the CLI
+type Client struct {
+	http  *http.Client
+	actor string
+}
+
+// Dial connects to the daemon listening on the admin socket at path,
+// returning ErrNotRunning if nothing answers there.
+func Dial(path string) (c *Client, err error) {
+	c = &Client{
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return net.DialTimeout("unix", path, dialTimeout)
+				},
+			},
+		},
+		actor: CurrentUser().Name,
+	}
+
+	resp, err := c.http.Get("http://wbd" + pingPath)
+	if err != nil {
+		return nil, ErrNotRunning
+	}
+	resp.Body.Close()
+
+	return
+}
+
+func (c *Client) Close() error {
+	c.http.CloseIdleConnections()
+	return nil
+}
+
+// call runs a Store method in the daemon, decoding its results into the
+// pointers in results
+func (c *Client) call(method string, results []interface{}, args ...interface{}) error {
+	if args == nil {
+		args = []interface{}{}
+	}
+
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", "http://wbd"+callPrefix+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(actorHeader, c.actor)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Admin socket call %s failed: %s", method, bytes.TrimSpace(message))
+	}
+
+	var response callResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return err
+	}
+
+	if response.Error != "" {
+		return decodeError(response.Error)
+	}
+
+	for i := range results {
+		if err := json.Unmarshal(response.Results[i], results[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) Connections() (connections []Connection, err error) {
+	resp, err := c.http.Get("http://wbd" + connectionsPath)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	err = json.NewDecoder(resp.Body).Decode(&connections)
+	return
+}
+
+func (c *Client) InsertUrl(url string) error {
+	return c.call("InsertUrl", nil, url)
+}
+
+func (c *Client) DeleteUrl(url string) error {
+	return c.call("DeleteUrl", nil, url)
+}
+
+func (c *Client) FetchUrls() (urls []string, err error) {
+	err = c.call("FetchUrls", []interface{}{&urls})
+	return
+}
+
+func (c *Client) InsertList(name string) error {
+	return c.call("InsertList", nil, name)
+}
+
+func (c *Client) DeleteList(name string) error {
+	return c.call("DeleteList", nil, name)
+}
+
+func (c *Client) FetchLists() (lists []string, err error) {
+	err = c.call("FetchLists", []interface{}{&lists})
+	return
+}
+
+func (c *Client) GetList(name string) (list database.List, err error) {
+	err = c.call("GetList", []interface{}{&list}, name)
+	return
+}
+
+func (c *Client) FindListId(name string) (id int, err error) {
+	err = c.call("FindListId", []interface{}{&id}, name)
+	return
+}
+
+func (c *Client) FindListName(id int) (name string, err error) {
+	err = c.call("FindListName", []interface{}{&name}, id)
+	return
+}
+
+func (c *Client) SetListMode(name string, mode string) error {
+	return c.call("SetListMode", nil, name, mode)
+}
+
+func (c *Client) FetchListUrlsById(id int) (urls []database.ListUrl, err error) {
+	err = c.call("FetchListUrlsById", []interface{}{&urls}, id)
+	return
+}
+
+func (c *Client) IsUrlInList(name string, url string) (found bool, err error) {
+	err = c.call("IsUrlInList", []interface{}{&found}, name, url)
+	return
+}
+
+func (c *Client) AssignUrlToList(name string, url string) error {
+	return c.call("AssignUrlToList", nil, name, url)
+}
+
+func (c *Client) AssignUrlToListAt(name string, url string, position int) error {
+	return c.call("AssignUrlToListAt", nil, name, url, position)
+}
+
+func (c *Client) MoveListUrl(name string, url string, position int) error {
+	return c.call("MoveListUrl", nil, name, url, position)
+}
+
+func (c *Client) ShiftListUrl(name string, url string, offset int) error {
+	return c.call("ShiftListUrl", nil, name, url, offset)
+}
+
+func (c *Client) SetListUrlDuration(name string, url string, duration int) error {
+	return c.call("SetListUrlDuration", nil, name, url, duration)
+}
+
+func (c *Client) PlaceUrlInList(name string, url string, p database.UrlPlacement) error {
+	return c.call("PlaceUrlInList", nil, name, url, p)
+}
+
+func (c *Client) RemoveUrlFromList(name string, url string) error {
+	return c.call("RemoveUrlFromList", nil, name, url)
+}
+
+func (c *Client) GetClient(identifier string) (client database.Client, err error) {
+	err = c.call("GetClient", []interface{}{&client}, identifier)
+	return
+}
+
+func (c *Client) FetchClients() (clients []database.Client, err error) {
+	err = c.call("FetchClients", []interface{}{&clients})
+	return
+}
+
+func (c *Client) SetClientAlias(identifier string, alias string) error {
+	return c.call("SetClientAlias", nil, identifier, alias)
+}
+
+func (c *Client) DeleteClient(identifier string) error {
+	return c.call("DeleteClient", nil, identifier)
+}
+
+func (c *Client) ApproveClient(identifier string) error {
+	return c.call("ApproveClient", nil, identifier)
+}
+
+func (c *Client) RejectClient(identifier string) error {
+	return c.call("RejectClient", nil, identifier)
+}
+
+func (c *Client) AssignClientToList(name string, client_id string) error {
+	return c.call("AssignClientToList", nil, name, client_id)
+}
+
+func (c *Client) RemoveClientFromList(client_id string) error {
+	return c.call("RemoveClientFromList", nil, client_id)
+}
+
+func (c *Client) GetClientList(identifier string) (list database.List, err error) {
+	err = c.call("GetClientList", []interface{}{&list}, identifier)
+	return
+}
+
+func (c *Client) GetActiveList(identifier string, now time.Time) (list database.List, err error) {
+	err = c.call("GetActiveList", []interface{}{&list}, identifier, now)
+	return
+}
+
+func (c *Client) GetClientStatus(identifier string) (status database.ClientStatus, err error) {
+	err = c.call("GetClientStatus", []interface{}{&status}, identifier)
+	return
+}
+
+func (c *Client) InsertGroup(name string) error {
+	return c.call("InsertGroup", nil, name)
+}
+
+func (c *Client) DeleteGroup(name string) error {
+	return c.call("DeleteGroup", nil, name)
+}
+
+func (c *Client) FetchGroups() (groups []database.Group, err error) {
+	err = c.call("FetchGroups", []interface{}{&groups})
+	return
+}
+
+func (c *Client) GetGroupById(id int) (group database.Group, err error) {
+	err = c.call("GetGroupById", []interface{}{&group}, id)
+	return
+}
+
+func (c *Client) FetchGroupMembers(name string) (members []database.Client, err error) {
+	err = c.call("FetchGroupMembers", []interface{}{&members}, name)
+	return
+}
+
+func (c *Client) AddClientToGroup(group string, client_id string) error {
+	return c.call("AddClientToGroup", nil, group, client_id)
+}
+
+func (c *Client) RemoveClientFromGroup(client_id string) error {
+	return c.call("RemoveClientFromGroup", nil, client_id)
+}
+
+func (c *Client) AssignGroupToList(list string, group string) error {
+	return c.call("AssignGroupToList", nil, list, group)
+}
+
+func (c *Client) RemoveGroupFromList(group string) error {
+	return c.call("RemoveGroupFromList", nil, group)
+}
+
+func (c *Client) InsertSchedule(s database.Schedule) error {
+	return c.call("InsertSchedule", nil, s)
+}
+
+func (c *Client) DeleteSchedule(id int) error {
+	return c.call("DeleteSchedule", nil, id)
+}
+
+func (c *Client) FetchSchedules() (schedules []database.Schedule, err error) {
+	err = c.call("FetchSchedules", []interface{}{&schedules})
+	return
+}
+
+func (c *Client) QueueCommand(cmd database.Command) error {
+	return c.call("QueueCommand", nil, cmd)
+}
+
+func (c *Client) Export() (s database.Snapshot, err error) {
+	err = c.call("Export", []interface{}{&s})
+	return
+}
+
+func (c *Client) Import(s database.Snapshot, replace bool) error {
+	return c.call("Import", nil, s, replace)
+}
+
+func (c *Client) Apply(m database.Snapshot, prune bool, dryRun bool) (changes []database.Change, err error) {
+	err = c.call("Apply", []interface{}{&changes}, m, prune, dryRun)
+	return
+}
+
+func (c *Client) FetchAudit(f database.AuditFilter) (entries []database.AuditEntry, err error) {
+	err = c.call("FetchAudit", []interface{}{&entries}, f)
+	return
+}
+
+func (c *Client) InsertUser(name string, role database.Role, password string) error {
+	return c.call("InsertUser", nil, name, role, password)
+}
+
+func (c *Client) DeleteUser(name string) error {
+	return c.call("DeleteUser", nil, name)
+}
+
+func (c *Client) FetchUsers() (users []database.User, err error) {
+	err = c.call("FetchUsers", []interface{}{&users})
+	return
+}
+
+func (c *Client) SetUserRole(name string, role database.Role) error {
+	return c.call("SetUserRole", nil, name, role)
+}
+
+func (c *Client) SetUserPassword(name string, password string) error {
+	return c.call("SetUserPassword", nil, name, password)
+}
+
+func (c *Client) CreateToken(user string, name string) (token string, err error) {
+	err = c.call("CreateToken", []interface{}{&token}, user, name)
+	return
+}
+
+func (c *Client) DeleteToken(user string, name string) error {
+	return c.call("DeleteToken", nil, user, name)
+}
+
+func (c *Client) FetchTokens() (tokens []database.Token, err error) {
+	err = c.call("FetchTokens", []interface{}{&tokens})
+	return
+}
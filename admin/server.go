@@ -0,0 +1,176 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/barracudanetworks/wbd/database"
+)
+
+const (
+	callPrefix      = "/call/"
+	connectionsPath = "/connections"
+	pingPath        = "/ping"
+
+	// actorHeader names the user making a call, for the audit log. Only the
+	// daemon's own user can reach the socket, so it's taken at its word.
+	actorHeader = "X-Wbd-Actor"
+)
+
+var ErrInUse = errors.New("Another wbd daemon is already listening there")
+
+// A call runs a Store method with JSON encoded arguments
+type callResponse struct {
+	Results []json.RawMessage `json:"results"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// Server answers CLI requests on the admin socket. Changed is called after
+// every call so the daemon can push changes out, and Connections reports
+// the daemon's live connections.
+type Server struct {
+	Database    *database.Database
+	Changed     func()
+	Connections func() []Connection
+}
+
+// Listen opens the admin socket, replacing it if it was left behind by a
+// daemon that's no longer running. Only the user running the daemon can
+// connect to it.
+func Listen(path string) (l net.Listener, err error) {
+	if _, err = os.Stat(path); err == nil {
+		if client, err := Dial(path); err == nil {
+			client.Close()
+			return nil, ErrInUse
+		}
+
+		if err = os.Remove(path); err != nil {
+			return
+		}
+	}
+
+	// The socket is made in a directory only we can get into, and moved into
+	// place once nobody else can connect to it
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".wbd-")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	private := filepath.Join(dir, "sock")
+	listener, err := net.Listen("unix", private)
+	if err != nil {
+		return
+	}
+
+	if err = os.Chmod(private, 0600); err == nil {
+		err = os.Rename(private, path)
+	}
+	if err != nil {
+		listener.Close()
+		return
+	}
+
+	return socketListener{listener, path}, nil
+}
+
+// socketListener removes the socket when it's closed, which the listener
+// itself can't since it was made under another name
+type socketListener struct {
+	net.Listener
+	path string
+}
+
+func (l socketListener) Close() error {
+	err := l.Listener.Close()
+	os.Remove(l.path)
+
+	return err
+}
+
+func (s *Server) Serve(l net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(pingPath, func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc(connectionsPath, s.connections)
+	mux.HandleFunc(callPrefix, s.call)
+
+	return http.Serve(l, mux)
+}
+
+func (s *Server) connections(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Connections())
+}
+
+// callable reports whether a method may be called over the socket; only
+// Store methods are
+func callable(name string) bool {
+	if name == "Close" || name == "Connections" {
+		return false
+	}
+
+	_, ok := reflect.TypeOf((*Store)(nil)).Elem().MethodByName(name)
+	return ok
+}
+
+func (s *Server) call(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, callPrefix)
+	if r.Method != "POST" || !callable(name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	db := s.Database.As(database.Actor{Name: r.Header.Get(actorHeader), Source: database.SourceCli})
+	method := reflect.ValueOf(db).MethodByName(name)
+
+	var raw []json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil || len(raw) != method.Type().NumIn() {
+		http.Error(w, "Invalid arguments", http.StatusBadRequest)
+		return
+	}
+
+	args := make([]reflect.Value, len(raw))
+	for i := range raw {
+		arg := reflect.New(method.Type().In(i))
+		if err := json.Unmarshal(raw[i], arg.Interface()); err != nil {
+			http.Error(w, "Invalid arguments", http.StatusBadRequest)
+			return
+		}
+
+		args[i] = arg.Elem()
+	}
+
+	log.Printf("Admin socket called %s", name)
+	results := method.Call(args)
+
+	var response callResponse
+
+	// The last result is always an error
+	if err, _ := results[len(results)-1].Interface().(error); err != nil {
+		response.Error = err.Error()
+	}
+
+	for _, result := range results[:len(results)-1] {
+		b, err := json.Marshal(result.Interface())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response.Results = append(response.Results, b)
+	}
+
+	if s.Changed != nil {
+		s.Changed()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
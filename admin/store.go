@@ -0,0 +1,162 @@
+// Package admin lets CLI commands work through a running wbd daemon over a
+// local Unix socket, so that changes reach displays straight away and live
+// state like who is connected can be shown.
+package admin
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/barracudanetworks/wbd/database"
+)
+
+var ErrNotRunning = errors.New("The wbd daemon isn't running")
+
+// Store is the part of the database the CLI uses. It's satisfied by a
+// Database wrapped with Local, or by a Client talking to the daemon.
+type Store interface {
+	Close() error
+
+	InsertUrl(url string) error
+	DeleteUrl(url string) error
+	FetchUrls() ([]string, error)
+
+	InsertList(name string) error
+	DeleteList(name string) error
+	FetchLists() ([]string, error)
+	GetList(name string) (database.List, error)
+	FindListId(name string) (int, error)
+	FindListName(id int) (string, error)
+	SetListMode(name string, mode string) error
+	FetchListUrlsById(id int) ([]database.ListUrl, error)
+	IsUrlInList(name string, url string) (bool, error)
+	AssignUrlToList(name string, url string) error
+	AssignUrlToListAt(name string, url string, position int) error
+	MoveListUrl(name string, url string, position int) error
+	ShiftListUrl(name string, url string, offset int) error
+	SetListUrlDuration(name string, url string, duration int) error
+	PlaceUrlInList(name string, url string, p database.UrlPlacement) error
+	RemoveUrlFromList(name string, url string) error
+
+	GetClient(identifier string) (database.Client, error)
+	FetchClients() ([]database.Client, error)
+	SetClientAlias(identifier string, alias string) error
+	DeleteClient(identifier string) error
+	ApproveClient(identifier string) error
+	RejectClient(identifier string) error
+	AssignClientToList(name string, client_id string) error
+	RemoveClientFromList(client_id string) error
+	GetClientList(identifier string) (database.List, error)
+	GetActiveList(identifier string, now time.Time) (database.List, error)
+	GetClientStatus(identifier string) (database.ClientStatus, error)
+
+	InsertGroup(name string) error
+	DeleteGroup(name string) error
+	FetchGroups() ([]database.Group, error)
+	GetGroupById(id int) (database.Group, error)
+	FetchGroupMembers(name string) ([]database.Client, error)
+	AddClientToGroup(group string, client_id string) error
+	RemoveClientFromGroup(client_id string) error
+	AssignGroupToList(list string, group string) error
+	RemoveGroupFromList(group string) error
+
+	InsertSchedule(s database.Schedule) error
+	DeleteSchedule(id int) error
+	FetchSchedules() ([]database.Schedule, error)
+
+	QueueCommand(cmd database.Command) error
+
+	Export() (database.Snapshot, error)
+	Import(s database.Snapshot, replace bool) error
+	Apply(m database.Snapshot, prune bool, dryRun bool) ([]database.Change, error)
+
+	FetchAudit(f database.AuditFilter) ([]database.AuditEntry, error)
+
+	InsertUser(name string, role database.Role, password string) error
+	DeleteUser(name string) error
+	FetchUsers() ([]database.User, error)
+	SetUserRole(name string, role database.Role) error
+	SetUserPassword(name string, password string) error
+	CreateToken(user string, name string) (string, error)
+	DeleteToken(user string, name string) error
+	FetchTokens() ([]database.Token, error)
+
+	// Connections returns the daemon's live websocket connections, or
+	// ErrNotRunning when working on the database directly
+	Connections() ([]Connection, error)
+}
+
+// A Connection is a websocket connected to the daemon
+type Connection struct {
+	Id         string `json:"id"`
+	IpAddress  string `json:"ip_address"`
+	Controller bool   `json:"controller"`
+	Generic    bool   `json:"generic"`
+	List       string `json:"list"`
+
+	Status *database.ClientStatus `json:"status,omitempty"`
+}
+
+// Local uses the database directly, for when the daemon isn't running
+func Local(db *database.Database) Store {
+	return &localStore{db}
+}
+
+type localStore struct {
+	*database.Database
+}
+
+func (l *localStore) Connections() ([]Connection, error) {
+	return nil, ErrNotRunning
+}
+
+// Errors the CLI compares against, so they survive the trip over the socket
+var knownErrors = []error{
+	sql.ErrNoRows,
+	database.ErrNegativeDuration,
+	database.ErrUrlExists,
+	database.ErrListExists,
+	database.ErrDefaultList,
+	database.ErrInvalidMode,
+	database.ErrGroupExists,
+	database.ErrScheduleTarget,
+	database.ErrInvalidTime,
+	database.ErrInvalidDays,
+	database.ErrInvalidTimezone,
+	database.ErrUserExists,
+	database.ErrInvalidRole,
+	database.ErrEmptyPassword,
+	database.ErrLastAdmin,
+	database.ErrTokenExists,
+}
+
+func decodeError(message string) error {
+	for _, err := range knownErrors {
+		if err.Error() == message {
+			return err
+		}
+	}
+
+	return errors.New(message)
+}
+
+// CurrentUser is who changes made from the command line are recorded as in
+// the audit log: the user running wbd, or whoever ran it with sudo
+func CurrentUser() database.Actor {
+	name := os.Getenv("SUDO_USER")
+	if name == "" {
+		if u, err := user.Current(); err == nil {
+			name = u.Username
+		}
+	}
+
+	return database.Actor{Name: name, Source: database.SourceCli}
+}
+
+// SocketPath is where the daemon listens by default: next to its database
+func SocketPath(database string) string {
+	return database + ".sock"
+}
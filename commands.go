@@ -1,59 +1,197 @@
 package main
 
 import (
+	"database/sql"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/barracudanetworks/wbd/admin"
 	"github.com/barracudanetworks/wbd/config"
 	"github.com/barracudanetworks/wbd/database"
 	"github.com/barracudanetworks/wbd/web"
 
 	"github.com/codegangsta/cli"
-	"github.com/howeyc/gopass"
 )
 
-func handleRun(c *cli.Context) error {
-	conf := &config.Configuration{
-		ListenAddress: c.String("listen"),
-		ListenPort:    c.Int("port"),
-		WebAddress:    c.String("url"),
-		Database:      c.String("database"),
+// connectDatabase opens an existing database, refusing to continue if its
+// schema needs migrating first.
+func connectDatabase(path string) *database.Database {
+	if _, err := os.Stat(path); err != nil {
+		log.Fatal("database does not exist")
 	}
+	log.Printf("Using database %s", path)
 
-	if _, err := os.Stat(conf.Database); err != nil {
-		log.Fatal("database does not exist")
+	db, err := database.Connect(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := db.CheckSchema(); err != nil {
+		log.Fatal(err)
+	}
+
+	return db.As(admin.CurrentUser())
+}
+
+// loadConfig works out the configuration a command runs with: flags and
+// environment variables, then the configuration file, then the defaults.
+func loadConfig(c *cli.Context) *config.Configuration {
+	path, err := config.Find(c.String("config"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	conf, err := config.Load(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if path != "" {
+		log.Printf("Using configuration file %s", path)
+	}
+
+	if c.IsSet("database") {
+		conf.Database = c.String("database")
+	}
+	if c.IsSet("server") {
+		conf.AdminSocket = c.String("server")
+	}
+	if c.IsSet("backup-dir") {
+		conf.BackupDir = c.String("backup-dir")
+	}
+
+	return conf
+}
+
+// connectStore works through the running daemon when there is one, so that
+// changes reach displays straight away, and opens the database otherwise.
+func connectStore(c *cli.Context) admin.Store {
+	conf := loadConfig(c)
+
+	socket := conf.AdminSocket
+	if socket == "" {
+		socket = admin.SocketPath(conf.Database)
+	}
+
+	client, err := admin.Dial(socket)
+	if err == nil {
+		log.Printf("Using wbd daemon at %s", socket)
+		return client
+	}
+
+	// A socket that was asked for has to be there
+	if conf.AdminSocket != "" {
+		log.Fatalf("Can't reach the wbd daemon at %s", socket)
+	}
+
+	return admin.Local(connectDatabase(conf.Database))
+}
+
+// runConfig is the configuration `run` uses, with everything that depends on
+// other settings filled in
+func runConfig(c *cli.Context) *config.Configuration {
+	conf := loadConfig(c)
+
+	var err error
+	if c.IsSet("listen") {
+		conf.ListenAddress = c.String("listen")
+	}
+	if c.IsSet("port") {
+		conf.ListenPort = c.Int("port")
+	}
+	if c.IsSet("url") {
+		conf.WebAddress = c.String("url")
+	}
+	if c.IsSet("trusted-proxies") {
+		if conf.TrustedProxies, err = config.ParseNetworks(c.String("trusted-proxies")); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if c.IsSet("tls-cert") {
+		conf.TLSCert = c.String("tls-cert")
+	}
+	if c.IsSet("tls-key") {
+		conf.TLSKey = c.String("tls-key")
+	}
+	if c.IsSet("redirect-port") {
+		conf.RedirectPort = c.Int("redirect-port")
+	}
+	if c.IsSet("rotation-duration") {
+		conf.RotationDuration = c.Duration("rotation-duration")
+	}
+	if c.IsSet("poll-interval") {
+		conf.PollInterval = c.Duration("poll-interval")
+	}
+	if c.IsSet("require-approval") {
+		conf.RequireApproval = c.Bool("require-approval")
+	}
+	if c.IsSet("enrollment-token") {
+		conf.EnrollmentToken = c.String("enrollment-token")
+	}
+	if c.IsSet("session-lifetime") {
+		conf.SessionLifetime = c.Duration("session-lifetime")
+	}
+	if c.IsSet("backup-interval") {
+		conf.BackupInterval = c.Duration("backup-interval")
+	}
+	if c.IsSet("backup-keep") {
+		conf.BackupKeep = c.Int("backup-keep")
+	}
+
+	if err = conf.Validate(); err != nil {
+		log.Fatal(err)
 	}
-	log.Printf("Using database %s", conf.Database)
+
+	conf.WebAddress = config.BasePath(conf.WebAddress)
 
 	if conf.ListenPort == 0 {
 		conf.ListenPort = 80
+		if conf.TLSCert != "" {
+			conf.ListenPort = 443
+		}
 	}
-	if conf.ListenAddress == "" {
-		conf.ListenAddress = "0.0.0.0"
+	if conf.AdminSocket == "" {
+		conf.AdminSocket = admin.SocketPath(conf.Database)
+	}
+
+	return conf
+}
+
+func handleRun(c *cli.Context) error {
+	conf := runConfig(c)
+
+	if _, err := os.Stat(conf.Database); err != nil {
+		log.Fatal("database does not exist")
 	}
+	log.Printf("Using database %s", conf.Database)
 
 	web.Start(conf)
 
 	return nil
 }
 
-func handleUrl(c *cli.Context) error {
-	if _, err := os.Stat(c.String("database")); err != nil {
-		log.Fatal("database does not exist")
+func handleConfigShow(c *cli.Context) error {
+	out, err := runConfig(c).Marshal()
+	if err != nil {
+		log.Fatal(err)
 	}
-	log.Printf("Using database %s", c.String("database"))
 
+	fmt.Print(string(out))
+
+	return nil
+}
+
+func handleUrl(c *cli.Context) error {
 	addUrl, deleteUrl := c.String("add"), c.String("delete")
 	if addUrl != "" && deleteUrl != "" {
 		log.Fatal("Can't both remove and add a URL")
 	}
 
-	db, err := database.Connect(c.String("database"))
+	db := connectStore(c)
 	defer db.Close()
-	if err != nil {
-		log.Fatal(err)
-	}
 
 	if addUrl != "" {
 		log.Printf("Adding url %s to rotation", addUrl)
@@ -85,23 +223,19 @@ func handleUrl(c *cli.Context) error {
 }
 
 func handleClient(c *cli.Context) error {
-	if _, err := os.Stat(c.String("database")); err != nil {
-		log.Fatal("database does not exist")
-	}
-	log.Printf("Using database %s", c.String("database"))
-
 	aliasClient, toAlias := c.String("alias"), c.String("to")
 	deleteClient := c.String("delete")
+	approveClient, rejectClient := c.String("approve"), c.String("reject")
 
 	if aliasClient != "" && deleteClient != "" {
 		log.Fatal("Can't both remove and alias a client")
 	}
+	if approveClient != "" && approveClient == rejectClient {
+		log.Fatal("Can't both approve and reject a client")
+	}
 
-	db, err := database.Connect(c.String("database"))
+	db := connectStore(c)
 	defer db.Close()
-	if err != nil {
-		log.Fatal(err)
-	}
 
 	if aliasClient != "" {
 		if toAlias == "" {
@@ -114,6 +248,20 @@ func handleClient(c *cli.Context) error {
 		}
 	}
 
+	if approveClient != "" {
+		log.Printf("Approving client '%s'", approveClient)
+		if err := db.ApproveClient(approveClient); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if rejectClient != "" {
+		log.Printf("Rejecting client '%s'", rejectClient)
+		if err := db.RejectClient(rejectClient); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	if deleteClient != "" {
 		log.Printf("Removing client '%s' from the database", deleteClient)
 		if err := db.DeleteClient(deleteClient); err != nil {
@@ -128,11 +276,51 @@ func handleClient(c *cli.Context) error {
 			log.Fatal(err)
 		}
 
+		// Only the daemon knows who's connected right now
+		connected := make(map[string]admin.Connection)
+		connections, err := db.Connections()
+		if err != nil && err != admin.ErrNotRunning {
+			log.Fatal(err)
+		}
+		for _, conn := range connections {
+			connected[conn.Id] = conn
+		}
+
+		now := time.Now()
 		for _, client := range clients {
+			showing, err := clientShowing(db, client, now)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			active := "Last active " + client.LastPing
+			conn, isConnected := connected[client.Identifier]
+			if isConnected {
+				active = "Connected"
+			}
+
+			// Unapproved clients only see the welcome page when approval is
+			// required
+			switch client.Approval {
+			case database.ApprovalPending:
+				showing = "Awaiting approval - " + showing
+			case database.ApprovalRejected:
+				showing = "Rejected - " + showing
+			}
+
 			if client.Alias == "" {
-				log.Printf("  %s (%s) - Last active %s", client.Identifier, client.IpAddress, client.LastPing)
+				log.Printf("  %s (%s) - %s - %s", client.Identifier, client.IpAddress, active, showing)
 			} else {
-				log.Printf("  %s [%s] (%s) - Last active %s", client.Alias, client.Identifier, client.IpAddress, client.LastPing)
+				log.Printf("  %s [%s] (%s) - %s - %s", client.Alias, client.Identifier, client.IpAddress, active, showing)
+			}
+
+			// Prefer the live status, falling back to the last one saved
+			if isConnected && conn.Status != nil {
+				log.Printf("    Now showing %s", describeStatus(*conn.Status))
+			} else if status, err := db.GetClientStatus(client.Identifier); err == nil {
+				log.Printf("    Last showed %s at %s", describeStatus(status), status.Updated)
+			} else if err != sql.ErrNoRows {
+				log.Fatal(err)
 			}
 		}
 	}
@@ -140,26 +328,90 @@ func handleClient(c *cli.Context) error {
 	return nil
 }
 
-func handleAssign(c *cli.Context) error {
-	if _, err := os.Stat(c.String("database")); err != nil {
-		log.Fatal("database does not exist")
+// describeStatus sums up what a display reported it was doing
+func describeStatus(status database.ClientStatus) string {
+	var notes []string
+	if status.Count > 0 {
+		notes = append(notes, fmt.Sprintf("%d/%d", status.Position, status.Count))
+	}
+	if status.Flashing {
+		notes = append(notes, "flashing")
+	}
+	if status.Paused {
+		notes = append(notes, "paused")
+	}
+	if status.Error != "" {
+		notes = append(notes, status.Error)
+	} else if !status.Loaded {
+		notes = append(notes, "loading")
+	}
+
+	if len(notes) == 0 {
+		return status.Url
+	}
+
+	return fmt.Sprintf("%s (%s)", status.Url, strings.Join(notes, ", "))
+}
+
+// clientShowing describes which list a client is showing, and whether its
+// group or a schedule put it there
+func clientShowing(db admin.Store, client database.Client, now time.Time) (showing string, err error) {
+	assigned, err := db.GetClientList(client.Identifier)
+	if err != nil {
+		return
+	}
+
+	active, err := db.GetActiveList(client.Identifier, now)
+	if err != nil {
+		return
+	}
+
+	showing = fmt.Sprintf("Showing %s", active.Name)
+	if active.Id != assigned.Id {
+		showing += fmt.Sprintf(" (scheduled, normally %s)", assigned.Name)
 	}
-	log.Printf("Using database %s", c.String("database"))
 
+	if client.GroupId != database.NoGroup {
+		group, err := db.GetGroupById(client.GroupId)
+		if err != nil {
+			return "", err
+		}
+
+		showing += fmt.Sprintf(" - Group %s", group.Name)
+	}
+
+	return
+}
+
+func handleAssign(c *cli.Context) error {
 	deleteFlag := c.Bool("delete")
 	assignList := c.String("list")
 	assignUrl, assignClient := c.String("url"), c.String("client")
+	assignGroup := c.String("group")
 
-	if (assignList == "" && (!deleteFlag || assignClient == "")) || (assignClient == "" && assignUrl == "") {
-		log.Fatal("Must specify a list, and a client or URL to assign to it")
+	if (assignList == "" && (!deleteFlag || (assignClient == "" && assignGroup == ""))) || (assignClient == "" && assignUrl == "" && assignGroup == "") {
+		log.Fatal("Must specify a list, and a client, group or URL to assign to it")
 	}
 
-	db, err := database.Connect(c.String("database"))
-	defer db.Close()
-	if err != nil {
-		log.Fatal(err)
+	moveUrl, moveOffset := c.String("move"), 0
+	switch moveUrl {
+	case "":
+	case "up":
+		moveOffset = -1
+	case "down":
+		moveOffset = 1
+	default:
+		log.Fatal("--move must be either up or down")
+	}
+
+	// Refuse a bad duration before the URL is assigned
+	if c.IsSet("duration") && c.Int("duration") < 0 {
+		log.Fatal(database.ErrNegativeDuration)
 	}
 
+	db := connectStore(c)
+	defer db.Close()
+
 	if assignUrl != "" {
 		// delete association if delete flag is true
 		if deleteFlag {
@@ -168,10 +420,43 @@ func handleAssign(c *cli.Context) error {
 			}
 			log.Printf("Removed URL %s from list %s", assignUrl, assignList)
 		} else {
-			if err := db.AssignUrlToList(assignList, assignUrl); err != nil {
+			found, err := db.IsUrlInList(assignList, assignUrl)
+			if err != nil {
 				log.Fatal(err)
 			}
-			log.Printf("Assigned URL %s to list %s", assignUrl, assignList)
+
+			placement := database.UrlPlacement{Offset: moveOffset}
+			if c.IsSet("position") {
+				position := c.Int("position")
+				placement.Position = &position
+			}
+			if c.IsSet("duration") {
+				duration := c.Int("duration")
+				placement.Duration = &duration
+			}
+
+			// Every step happens together, or not at all
+			if err := db.PlaceUrlInList(assignList, assignUrl, placement); err != nil {
+				log.Fatal(err)
+			}
+
+			updateOnly := found && (placement.Position != nil || moveUrl != "" || placement.Duration != nil)
+
+			switch {
+			case updateOnly && placement.Position != nil:
+				log.Printf("Moved URL %s to position %d in list %s", assignUrl, *placement.Position, assignList)
+			case updateOnly:
+			case placement.Position != nil:
+				log.Printf("Assigned URL %s to list %s at position %d", assignUrl, assignList, *placement.Position)
+			default:
+				log.Printf("Assigned URL %s to list %s", assignUrl, assignList)
+			}
+			if moveUrl != "" {
+				log.Printf("Moved URL %s %s in list %s", assignUrl, moveUrl, assignList)
+			}
+			if placement.Duration != nil {
+				log.Printf("Set duration of URL %s in list %s to %d seconds", assignUrl, assignList, *placement.Duration)
+			}
 		}
 	}
 	if assignClient != "" {
@@ -180,7 +465,7 @@ func handleAssign(c *cli.Context) error {
 			if err := db.RemoveClientFromList(assignClient); err != nil {
 				log.Fatal(err)
 			}
-			log.Printf("Assigned client %s back to the Default list", assignClient)
+			log.Printf("Removed client %s from its list", assignClient)
 		} else {
 			if err := db.AssignClientToList(assignList, assignClient); err != nil {
 				log.Fatal(err)
@@ -188,27 +473,41 @@ func handleAssign(c *cli.Context) error {
 			log.Printf("Assigned client %s to list %s", assignClient, assignList)
 		}
 	}
+	if assignGroup != "" {
+		if deleteFlag {
+			if err := db.RemoveGroupFromList(assignGroup); err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("Assigned group %s back to the Default list", assignGroup)
+		} else {
+			if err := db.AssignGroupToList(assignList, assignGroup); err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("Assigned group %s to list %s", assignGroup, assignList)
+		}
+	}
 
 	return nil
 }
 
 func handleList(c *cli.Context) error {
-	if _, err := os.Stat(c.String("database")); err != nil {
-		log.Fatal("database does not exist")
-	}
-	log.Printf("Using database %s", c.String("database"))
-
 	addList, deleteList := c.String("add"), c.String("delete")
 	if addList != "" && deleteList != "" {
 		log.Fatal("Can't both remove and add a list")
 	}
 
-	db, err := database.Connect(c.String("database"))
-	defer db.Close()
-	if err != nil {
-		log.Fatal(err)
+	// --mode applies to the list being created unless one is named
+	mode, modeList := c.String("mode"), c.String("name")
+	if modeList == "" {
+		modeList = addList
+	}
+	if mode != "" && modeList == "" {
+		log.Fatal("No list specified to set the mode of (use --name)")
 	}
 
+	db := connectStore(c)
+	defer db.Close()
+
 	if addList != "" {
 		log.Printf("Creating list %s", addList)
 		if err := db.InsertList(addList); err != nil {
@@ -216,6 +515,13 @@ func handleList(c *cli.Context) error {
 		}
 	}
 
+	if mode != "" {
+		log.Printf("Setting rotation mode of list %s to %s", modeList, mode)
+		if err := db.SetListMode(modeList, mode); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	if deleteList != "" {
 		log.Printf("Deleting list %s", deleteList)
 		if err := db.DeleteList(deleteList); err != nil {
@@ -230,16 +536,25 @@ func handleList(c *cli.Context) error {
 			log.Fatal(err)
 		}
 
-		for _, list := range lists {
-			urls, err := db.FetchListUrlsByName(list)
+		for _, name := range lists {
+			list, err := db.GetList(name)
 			if err != nil {
 				log.Fatal(err)
 			}
 
-			log.Print("  ", list)
+			urls, err := db.FetchListUrlsById(list.Id)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			log.Printf("  %s (%s)", list.Name, list.Mode)
 
-			for _, url := range urls {
-				log.Print("    ", url)
+			for i, url := range urls {
+				if url.Duration == 0 {
+					log.Printf("    %d. %s", i+1, url.Url)
+				} else {
+					log.Printf("    %d. %s (%ds)", i+1, url.Url, url.Duration)
+				}
 			}
 		}
 	}
@@ -252,24 +567,21 @@ func handleInstall(c *cli.Context) error {
 
 	var (
 		path     string
-		password []byte
+		name     string
+		password string
 	)
 
-	path = c.String("database")
+	path = loadConfig(c).Database
 
 	// Don't overwrite db if one already exists
 	if _, err := os.Stat(path); err == nil {
 		log.Fatal("database already exists")
 	}
 
-	if resp := confirmDefault("Would you like to set a password?", true); resp == true {
-		fmt.Printf("Password: ")
-
-		var err error
-		password, err = gopass.GetPasswd()
-		if err != nil {
-			log.Fatal(err)
-		}
+	// The first user is an admin; until there is one the console is open
+	if confirmDefault("Would you like to add an admin user?", true) {
+		name = promptDefault("User name", "admin")
+		password = promptPassword()
 	}
 
 	log.Printf("Creating database at %s", path)
@@ -281,45 +593,732 @@ func handleInstall(c *cli.Context) error {
 		log.Fatal(err)
 	}
 
-	// Start a transaction
-	tx, err := db.Conn.Begin()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Create table schema
+	// Create table schema (each migration runs in its own transaction)
 	if err = db.CreateTables(); err != nil {
-		tx.Rollback()
 		log.Fatal(err)
 	}
 
-	// Insert password if one was given
-	if len(password) == 0 {
-		if err = db.InsertConfig("password", string(password)); err != nil {
-			tx.Rollback()
+	if name != "" {
+		if err = db.As(admin.CurrentUser()).InsertUser(name, database.RoleAdmin, password); err != nil {
 			log.Fatal(err)
 		}
+		log.Printf("Added admin %s", name)
 	}
 
-	tx.Commit()
 	log.Print("Database created")
 
 	return nil
 }
 
-func handleClean(c *cli.Context) error {
-	database := c.String("database")
-	log.Printf("Removing database at %s", database)
+func handleGroup(c *cli.Context) error {
+	addGroup, deleteGroup := c.String("add"), c.String("delete")
+	if addGroup != "" && deleteGroup != "" {
+		log.Fatal("Can't both remove and add a group")
+	}
 
-	if _, err := os.Stat(database); err != nil {
-		log.Fatal("database does not exist")
+	// --client joins the group being created unless one is named
+	joinClient, joinGroup := c.String("client"), c.String("name")
+	if joinGroup == "" {
+		joinGroup = addGroup
+	}
+	if joinClient != "" && joinGroup == "" {
+		log.Fatal("No group specified for the client to join (use --name)")
 	}
 
-	if err := os.Remove(database); err != nil {
-		log.Fatal(err)
+	db := connectStore(c)
+	defer db.Close()
+
+	if addGroup != "" {
+		log.Printf("Creating group %s", addGroup)
+		if err := db.InsertGroup(addGroup); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	log.Print("Database removed")
+	if joinClient != "" {
+		log.Printf("Adding client %s to group %s", joinClient, joinGroup)
+		if err := db.AddClientToGroup(joinGroup, joinClient); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if removeClient := c.String("remove"); removeClient != "" {
+		log.Printf("Removing client %s from its group", removeClient)
+		if err := db.RemoveClientFromGroup(removeClient); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if deleteGroup != "" {
+		log.Printf("Deleting group %s", deleteGroup)
+		if err := db.DeleteGroup(deleteGroup); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if c.Bool("list") {
+		log.Print("Client groups defined:")
+		groups, err := db.FetchGroups()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, group := range groups {
+			list, err := db.FindListName(group.UrlListId)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			log.Printf("  %s (list %s)", group.Name, list)
+
+			members, err := db.FetchGroupMembers(group.Name)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			for _, member := range members {
+				if member.Alias == "" {
+					log.Printf("    %s", member.Identifier)
+				} else {
+					log.Printf("    %s [%s]", member.Alias, member.Identifier)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func handleSchedule(c *cli.Context) error {
+	showList := c.String("add")
+	deleteId := c.Int("delete")
+
+	db := connectStore(c)
+	defer db.Close()
+
+	if showList != "" {
+		schedule := database.Schedule{
+			Client:   c.String("client"),
+			List:     c.String("list"),
+			Show:     showList,
+			Days:     c.String("days"),
+			Start:    c.String("from"),
+			End:      c.String("to"),
+			Timezone: c.String("timezone"),
+		}
+
+		log.Printf("Scheduling list '%s' from %s to %s", showList, schedule.Start, schedule.End)
+		if err := db.InsertSchedule(schedule); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if deleteId != 0 {
+		log.Printf("Removing schedule %d", deleteId)
+		if err := db.DeleteSchedule(deleteId); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if c.Bool("show") || (showList == "" && deleteId == 0) {
+		schedules, err := db.FetchSchedules()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Print("Schedules:")
+		now := time.Now()
+		for _, s := range schedules {
+			target := "list " + s.List
+			if s.Client != "" {
+				target = "client " + s.Client
+			}
+
+			days := s.Days
+			if days == "" {
+				days = "every day"
+			}
+
+			timezone := s.Timezone
+			if timezone == "" {
+				timezone = "local time"
+			}
+
+			active := ""
+			if s.Active(now) {
+				active = " (active)"
+			}
+
+			log.Printf("  %d. %s shows %s %s-%s %s, %s%s", s.Id, target, s.Show, s.Start, s.End, days, timezone, active)
+		}
+	}
+
+	return nil
+}
+
+func handleFlash(c *cli.Context) error {
+	flashUrl, duration := c.String("url"), c.Int("duration")
+	flashClient, flashList := c.String("client"), c.String("list")
+
+	if flashUrl == "" {
+		log.Fatal("No URL specified (use --url)")
+	}
+	if duration <= 0 {
+		log.Fatal("Duration must be positive")
+	}
+
+	db := connectStore(c)
+	defer db.Close()
+
+	if flashClient != "" {
+		if _, err := db.GetClient(flashClient); err != nil {
+			log.Fatalf("Unknown client '%s'", flashClient)
+		}
+	}
+	if flashList != "" {
+		if _, err := db.FindListId(flashList); err != nil {
+			log.Fatalf("Unknown list '%s'", flashList)
+		}
+	}
+
+	cmd, err := web.FlashCommand(flashUrl, duration, flashClient, flashList)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The running daemon picks this up and passes it on to the displays
+	log.Printf("Flashing %s for %d seconds", flashUrl, duration)
+	if err := db.QueueCommand(cmd); err != nil {
+		log.Fatal(err)
+	}
+
+	return nil
+}
+
+func handleControl(c *cli.Context) error {
+	action := c.Args().First()
+	controlClient, controlList := c.String("client"), c.String("list")
+
+	if action == "" {
+		log.Fatal("No action specified (next, previous, pause, resume, reload or disconnect)")
+	}
+
+	cmd, err := web.ControlCommand(action, controlClient, controlList)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db := connectStore(c)
+	defer db.Close()
+
+	if controlClient != "" {
+		if _, err := db.GetClient(controlClient); err != nil {
+			log.Fatalf("Unknown client '%s'", controlClient)
+		}
+	}
+	if controlList != "" {
+		if _, err := db.FindListId(controlList); err != nil {
+			log.Fatalf("Unknown list '%s'", controlList)
+		}
+	}
+
+	// The running daemon picks this up and passes it on to the displays
+	log.Printf("Sending '%s'", action)
+	if err := db.QueueCommand(cmd); err != nil {
+		log.Fatal(err)
+	}
+
+	return nil
+}
+
+func handleUser(c *cli.Context) error {
+	addUser, deleteUser := c.String("add"), c.String("delete")
+	if addUser != "" && deleteUser != "" {
+		log.Fatal("Can't both remove and add a user")
+	}
+
+	// --role, --token and --revoke apply to the user being added unless one
+	// is named
+	name := c.String("name")
+	if name == "" {
+		name = addUser
+	}
+
+	token, revoke := c.String("token"), c.String("revoke")
+	if (token != "" || revoke != "" || c.IsSet("role")) && name == "" {
+		log.Fatal("No user specified (use --name)")
+	}
+
+	role, err := database.ParseRole(c.String("role"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var password string
+	if addUser != "" {
+		password = promptPassword()
+	}
+
+	resetUser, resetPassword := c.String("password"), ""
+	if resetUser != "" {
+		resetPassword = promptPassword()
+	}
+
+	db := connectStore(c)
+	defer db.Close()
+
+	if addUser != "" {
+		log.Printf("Adding %s %s", role, addUser)
+		if err := db.InsertUser(addUser, role, password); err != nil {
+			log.Fatal(err)
+		}
+	} else if c.IsSet("role") {
+		log.Printf("Making %s %s", name, role)
+		if err := db.SetUserRole(name, role); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if resetUser != "" {
+		log.Printf("Setting the password of %s", resetUser)
+		if err := db.SetUserPassword(resetUser, resetPassword); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if token != "" {
+		log.Printf("Creating API token %s for %s", token, name)
+		secret, err := db.CreateToken(name, token)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Only a hash is kept, so this is the one chance to copy it
+		log.Print("Send it as Authorization: Bearer <token>; it won't be shown again")
+		fmt.Println(secret)
+	}
+
+	if revoke != "" {
+		log.Printf("Revoking API token %s of %s", revoke, name)
+		if err := db.DeleteToken(name, revoke); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if deleteUser != "" {
+		log.Printf("Deleting user %s", deleteUser)
+		if err := db.DeleteUser(deleteUser); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if c.Bool("list") {
+		users, err := db.FetchUsers()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		tokens, err := db.FetchTokens()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if len(users) == 0 {
+			log.Print("No users; the console and API are open to everyone")
+		} else {
+			log.Print("Users:")
+		}
+
+		for _, user := range users {
+			log.Printf("  %s (%s)", user.Name, user.Role)
+
+			for _, t := range tokens {
+				if t.User != user.Name {
+					continue
+				}
+
+				used := "never used"
+				if t.LastUsed != "" {
+					used = "last used " + t.LastUsed
+				}
+				log.Printf("    token %s, created %s, %s", t.Name, t.Created, used)
+			}
+		}
+	}
+
+	return nil
+}
+
+func handleMigrate(c *cli.Context) error {
+	path := loadConfig(c).Database
+	if _, err := os.Stat(path); err != nil {
+		log.Fatal("database does not exist")
+	}
+	log.Printf("Using database %s", path)
+
+	db, err := database.Connect(path)
+	defer db.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if c.Bool("status") {
+		log.Printf("Schema version %d (latest is %d)", version, database.LatestSchemaVersion())
+		for _, m := range pending {
+			log.Printf("  Pending migration %d: %s", m.Version, m.Description)
+		}
+
+		return nil
+	}
+
+	if len(pending) == 0 {
+		log.Printf("Database is up to date (version %d)", version)
+		return nil
+	}
+
+	if c.Bool("dry-run") {
+		for _, m := range pending {
+			log.Printf("Would apply migration %d: %s", m.Version, m.Description)
+		}
+
+		return nil
+	}
+
+	applied, err := db.Migrate()
+	for _, m := range applied {
+		log.Printf("Applied migration %d: %s", m.Version, m.Description)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Database migrated to version %d", database.LatestSchemaVersion())
+
+	return nil
+}
+
+func handleExport(c *cli.Context) error {
+	output := c.String("output")
+
+	format, err := snapshotFormat(c.String("format"), output)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db := connectStore(c)
+	defer db.Close()
+
+	snapshot, err := db.Export()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	out, err := encodeSnapshot(snapshot, format)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if output == "" || output == "-" {
+		os.Stdout.Write(out)
+		return nil
+	}
+
+	if err := ioutil.WriteFile(output, out, 0600); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Exported %d URLs, %d lists and %d clients to %s", len(snapshot.Urls), len(snapshot.Lists), len(snapshot.Clients), output)
+
+	return nil
+}
+
+func handleImport(c *cli.Context) error {
+	path := c.Args().First()
+	if path == "" {
+		log.Fatal("Give a file to import, or - to read standard input")
+	}
+
+	data, err := readInput(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	snapshot, err := decodeSnapshot(data)
+	if err != nil {
+		log.Fatalf("%s: %s", path, err)
+	}
+
+	db := connectStore(c)
+	defer db.Close()
+
+	replace := c.Bool("replace")
+	if err := db.Import(snapshot, replace); err != nil {
+		log.Fatalf("Nothing was imported: %s", err)
+	}
+
+	if replace {
+		log.Printf("Replaced the setup with %d URLs, %d lists and %d clients from %s", len(snapshot.Urls), len(snapshot.Lists), len(snapshot.Clients), path)
+	} else {
+		log.Printf("Merged %d URLs, %d lists and %d clients from %s", len(snapshot.Urls), len(snapshot.Lists), len(snapshot.Clients), path)
+	}
+
+	return nil
+}
+
+func handleApply(c *cli.Context) error {
+	path := c.String("file")
+	if path == "" {
+		log.Fatal("Give a manifest with --file, or --file - to read standard input")
+	}
+
+	data, err := readInput(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	manifest, err := decodeSnapshot(data)
+	if err != nil {
+		log.Fatalf("%s: %s", path, err)
+	}
+
+	db := connectStore(c)
+	defer db.Close()
+
+	dryRun := c.Bool("diff")
+	changes, err := db.Apply(manifest, c.Bool("prune"), dryRun)
+	if err != nil {
+		log.Fatalf("Nothing was changed: %s", err)
+	}
+
+	for _, change := range changes {
+		fmt.Println(change.Description)
+	}
+
+	switch {
+	case len(changes) == 0:
+		log.Print("Already up to date")
+	case dryRun:
+		log.Printf("%d changes would be made", len(changes))
+	default:
+		log.Printf("Made %d changes", len(changes))
+	}
+
+	return nil
+}
+
+// backupDatabase takes a backup of the database at path, returning where it
+// went
+func backupDatabase(conf *config.Configuration, path string, output string) string {
+	if output == "" {
+		output = database.BackupPath(conf.BackupDir, path, time.Now())
+	}
+
+	db, err := database.Connect(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Backup(output); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Backed up %s to %s", path, output)
+
+	return output
+}
+
+func handleBackup(c *cli.Context) error {
+	conf := loadConfig(c)
+
+	if c.Bool("list") {
+		backups, err := database.Backups(conf.BackupDir, conf.Database)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("Backups of %s:", conf.Database)
+		for _, backup := range backups {
+			log.Printf("  %s", backup)
+		}
+
+		return nil
+	}
+
+	if _, err := os.Stat(conf.Database); err != nil {
+		log.Fatal("database does not exist")
+	}
+
+	backupDatabase(conf, conf.Database, c.String("output"))
+
+	return nil
+}
+
+func handleRestore(c *cli.Context) error {
+	backup := c.Args().First()
+	if backup == "" {
+		log.Fatal("Give a backup to restore (see `wbd backup --list`)")
+	}
+
+	conf := loadConfig(c)
+	path := conf.Database
+
+	socket := conf.AdminSocket
+	if socket == "" {
+		socket = admin.SocketPath(path)
+	}
+	if client, err := admin.Dial(socket); err == nil {
+		client.Close()
+		log.Fatal("Stop the wbd daemon before restoring its database")
+	}
+
+	if _, err := os.Stat(backup); err != nil {
+		log.Fatal("backup does not exist")
+	}
+
+	// Keep what's being replaced, in case the wrong backup was picked
+	if _, err := os.Stat(path); err == nil {
+		if !c.Bool("yes") && !confirmDefault(fmt.Sprintf("Replace %s with %s?", path, backup), false) {
+			return nil
+		}
+
+		backupDatabase(conf, path, "")
+	}
+
+	if err := database.Restore(backup, path); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Restored %s from %s", path, backup)
+
+	db, err := database.Connect(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.CheckSchema(); err != nil {
+		log.Print(err)
+	} else if err := db.As(admin.CurrentUser()).RecordRestore(backup); err != nil {
+		log.Print(err)
+	}
+
+	return nil
+}
+
+func handleClean(c *cli.Context) error {
+	conf := loadConfig(c)
+	path := conf.Database
+
+	if _, err := os.Stat(path); err != nil {
+		log.Fatal("database does not exist")
+	}
+
+	if !c.Bool("no-backup") && confirmDefault("Back up the database before removing it?", true) {
+		backupDatabase(conf, path, "")
+	}
+
+	log.Printf("Removing database at %s", path)
+	if err := os.Remove(path); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Print("Database removed")
+
+	return nil
+}
+
+// auditTimeFormats are the ways --since and --until take a time, besides a
+// duration back from now
+var auditTimeFormats = []string{
+	"2006-01-02",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	time.RFC3339,
+}
+
+func parseAuditTime(value string, now time.Time) (t time.Time, err error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+
+	for _, format := range auditTimeFormats {
+		if t, err = time.ParseInLocation(format, value, time.Local); err == nil {
+			return
+		}
+	}
+
+	return t, fmt.Errorf("Can't read %q as a time (e.g. 2026-01-02, \"2026-01-02 15:04\" or 24h for a day ago)", value)
+}
+
+func describeAuditValue(value string) string {
+	if value == "" {
+		return "(none)"
+	}
+
+	return value
+}
+
+func handleAudit(c *cli.Context) error {
+	filter := database.AuditFilter{
+		Actor: c.String("actor"),
+		Limit: c.Int("limit"),
+	}
+
+	// --object is a type, e.g. list, or a type and name, e.g. list:Lobby
+	if object := c.String("object"); object != "" {
+		parts := strings.SplitN(object, ":", 2)
+		filter.Type = parts[0]
+		if len(parts) == 2 {
+			filter.Object = parts[1]
+		}
+	}
+
+	now := time.Now()
+	var err error
+	if since := c.String("since"); since != "" {
+		if filter.Since, err = parseAuditTime(since, now); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if until := c.String("until"); until != "" {
+		if filter.Until, err = parseAuditTime(until, now); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	db := connectStore(c)
+	defer db.Close()
+
+	entries, err := db.FetchAudit(filter)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Print("Audit log:")
+	for _, entry := range entries {
+		when := entry.Time
+		if t, err := time.ParseInLocation("2006-01-02 15:04:05", entry.Time, time.UTC); err == nil {
+			when = t.Local().Format("2006-01-02 15:04:05")
+		}
+
+		object := entry.Type
+		if entry.Object != "" {
+			object += " " + entry.Object
+		}
+
+		log.Printf("  %s %s: %s %s", when, entry.Actor, entry.Action, object)
+		if entry.Before != "" || entry.After != "" {
+			log.Printf("    %s -> %s", describeAuditValue(entry.Before), describeAuditValue(entry.After))
+		}
+	}
 
 	return nil
 }
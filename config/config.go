@@ -1,8 +1,171 @@
 package config
 
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration is everything wbd can be told. The YAML keys match the
+// names of the flags that set them.
 type Configuration struct {
-	ListenAddress string
-	ListenPort    int
-	WebAddress    string
-	Database      string
+	Database    string `yaml:"database"`
+	AdminSocket string `yaml:"server,omitempty"`
+
+	ListenAddress string `yaml:"listen"`
+	ListenPort    int    `yaml:"port"`
+	WebAddress    string `yaml:"url"`
+
+	// Serve HTTPS with this certificate and key, optionally redirecting
+	// plain HTTP on RedirectPort
+	TLSCert      string `yaml:"tls-cert,omitempty"`
+	TLSKey       string `yaml:"tls-key,omitempty"`
+	RedirectPort int    `yaml:"redirect-port,omitempty"`
+
+	// Forwarded headers are only believed from these proxies
+	TrustedProxies Networks `yaml:"trusted-proxies"`
+
+	// How long displays show URLs that don't have a duration of their own
+	RotationDuration time.Duration `yaml:"rotation-duration"`
+
+	// How often the daemon checks the database for changes
+	PollInterval time.Duration `yaml:"poll-interval"`
+
+	// Only show lists to clients that have been approved, and approve those
+	// that bring EnrollmentToken straight away
+	RequireApproval bool   `yaml:"require-approval"`
+	EnrollmentToken string `yaml:"enrollment-token,omitempty"`
+
+	// How long a console login lasts
+	SessionLifetime time.Duration `yaml:"session-lifetime"`
+
+	// How often the daemon backs up the database, if at all, where to (next
+	// to the database if empty), and how many backups to keep (0 keeps them
+	// all)
+	BackupInterval time.Duration `yaml:"backup-interval,omitempty"`
+	BackupDir      string        `yaml:"backup-dir,omitempty"`
+	BackupKeep     int           `yaml:"backup-keep"`
+}
+
+// Defaults returns the configuration used for anything that isn't set
+// elsewhere. A zero ListenPort means 80, or 443 when serving HTTPS.
+func Defaults() *Configuration {
+	return &Configuration{
+		Database:         "wbd.db",
+		ListenAddress:    "0.0.0.0",
+		RotationDuration: 60 * time.Second,
+		PollInterval:     time.Second,
+		SessionLifetime:  24 * time.Hour,
+		BackupKeep:       7,
+	}
+}
+
+// Validate checks that the settings make sense together
+func (c *Configuration) Validate() error {
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("tls-cert and tls-key must be given together")
+	}
+	if c.TLSCert == "" && c.RedirectPort != 0 {
+		return errors.New("redirect-port only makes sense when serving HTTPS")
+	}
+	if c.RotationDuration < time.Second {
+		return errors.New("rotation-duration must be at least a second")
+	}
+	if c.PollInterval <= 0 {
+		return errors.New("poll-interval must be positive")
+	}
+	if c.EnrollmentToken != "" && !c.RequireApproval {
+		return errors.New("enrollment-token only makes sense with require-approval")
+	}
+	if c.SessionLifetime <= 0 {
+		return errors.New("session-lifetime must be positive")
+	}
+	if c.BackupInterval < 0 {
+		return errors.New("backup-interval can't be negative")
+	}
+	if c.BackupKeep < 0 {
+		return errors.New("backup-keep can't be negative")
+	}
+
+	return nil
+}
+
+// Networks is a list of CIDR networks. In a configuration file it may be
+// written as a list or as a comma separated string.
+type Networks []*net.IPNet
+
+func (n *Networks) UnmarshalYAML(value *yaml.Node) (err error) {
+	var list []string
+	if value.Kind == yaml.SequenceNode {
+		err = value.Decode(&list)
+	} else {
+		var entry string
+		err = value.Decode(&entry)
+		list = []string{entry}
+	}
+	if err != nil {
+		return
+	}
+
+	*n, err = ParseNetworks(strings.Join(list, ","))
+	return
+}
+
+func (n Networks) MarshalYAML() (interface{}, error) {
+	list := []string{}
+	for _, network := range n {
+		list = append(list, network.String())
+	}
+
+	return list, nil
+}
+
+// ParseNetworks parses a comma separated list of CIDR networks. Bare IP
+// addresses are taken to be networks of their own.
+func ParseNetworks(list string) (networks []*net.IPNet, err error) {
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid IP address %q", entry)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid network %q", entry)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return
+}
+
+// BasePath tidies up the location wbd is served from, e.g. "wbd/" becomes
+// "/wbd". Serving from the root is an empty string.
+func BasePath(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return ""
+	}
+
+	return "/" + path
 }
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNetworks(t *testing.T) {
+	assert := assert.New(t)
+
+	networks, err := ParseNetworks("10.0.0.0/8, 192.168.1.5,::1")
+	assert.Nil(err)
+	assert.Len(networks, 3)
+	assert.Equal("10.0.0.0/8", networks[0].String())
+	assert.Equal("192.168.1.5/32", networks[1].String())
+	assert.Equal("::1/128", networks[2].String())
+
+	networks, err = ParseNetworks("")
+	assert.Nil(err)
+	assert.Len(networks, 0)
+
+	_, err = ParseNetworks("10.0.0.0/33")
+	assert.NotNil(err)
+
+	_, err = ParseNetworks("proxy.example.com")
+	assert.NotNil(err)
+}
+
+func TestBasePath(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("", BasePath(""))
+	assert.Equal("", BasePath("/"))
+	assert.Equal("/wbd", BasePath("/wbd"))
+	assert.Equal("/wbd", BasePath("wbd/"))
+	assert.Equal("/signage/wbd", BasePath("/signage/wbd/"))
+}
+
+func TestParse(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := Defaults()
+	err := Parse([]byte(`
+database: /var/lib/wbd/wbd.db
+port: 8080
+trusted-proxies: 10.0.0.0/8, 192.168.1.5
+rotation-duration: 2m
+`), conf)
+	assert.Nil(err)
+	assert.Equal("/var/lib/wbd/wbd.db", conf.Database)
+	assert.Equal(8080, conf.ListenPort)
+	assert.Len(conf.TrustedProxies, 2)
+	assert.Equal(2*time.Minute, conf.RotationDuration)
+
+	// Anything left out keeps its default
+	assert.Equal("0.0.0.0", conf.ListenAddress)
+	assert.Equal(time.Second, conf.PollInterval)
+	assert.Equal(24*time.Hour, conf.SessionLifetime)
+
+	conf = Defaults()
+	assert.Nil(Parse([]byte("trusted-proxies: [10.0.0.0/8, \"::1\"]\n"), conf))
+	assert.Equal("::1/128", conf.TrustedProxies[1].String())
+
+	assert.Nil(Parse([]byte(""), Defaults()))
+	assert.NotNil(Parse([]byte("databse: wbd.db\n"), Defaults()))
+	assert.NotNil(Parse([]byte("poll-interval: 5\n"), Defaults()))
+	assert.NotNil(Parse([]byte("trusted-proxies: nowhere\n"), Defaults()))
+}
+
+func TestMarshal(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := Defaults()
+	conf.TrustedProxies, _ = ParseNetworks("10.0.0.0/8")
+
+	out, err := conf.Marshal()
+	assert.Nil(err)
+
+	// What's written out reads back the same
+	read := &Configuration{}
+	assert.Nil(Parse(out, read))
+	assert.Equal(conf, read)
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(Defaults().Validate())
+
+	conf := Defaults()
+	conf.TLSCert = "wbd.crt"
+	assert.NotNil(conf.Validate())
+	conf.TLSKey = "wbd.key"
+	assert.Nil(conf.Validate())
+
+	conf = Defaults()
+	conf.RedirectPort = 80
+	assert.NotNil(conf.Validate())
+
+	conf = Defaults()
+	conf.RotationDuration = 0
+	assert.NotNil(conf.Validate())
+
+	conf = Defaults()
+	conf.EnrollmentToken = "secret"
+	assert.NotNil(conf.Validate())
+	conf.RequireApproval = true
+	assert.Nil(conf.Validate())
+}
+
+func TestLoad(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := Find("does-not-exist.yaml")
+	assert.NotNil(err)
+
+	conf, err := Load("")
+	assert.Nil(err)
+	assert.Equal(Defaults(), conf)
+
+	file, err := ioutil.TempFile("", "wbd-*.yaml")
+	assert.Nil(err)
+	defer os.Remove(file.Name())
+
+	file.WriteString("listen: 127.0.0.1\n")
+	file.Close()
+
+	path, err := Find(file.Name())
+	assert.Nil(err)
+	assert.Equal(file.Name(), path)
+
+	conf, err = Load(path)
+	assert.Nil(err)
+	assert.Equal("127.0.0.1", conf.ListenAddress)
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Paths returns where a configuration file is looked for, in order, when one
+// isn't given
+func Paths() []string {
+	paths := []string{"wbd.yaml"}
+
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "wbd", "wbd.yaml"))
+	}
+
+	return append(paths, "/etc/wbd/wbd.yaml")
+}
+
+// Find returns the configuration file to use: path if it's given, otherwise
+// the first of Paths that exists. An empty string means there isn't one.
+func Find(path string) (string, error) {
+	if path != "" {
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("Can't read configuration file %s", path)
+		}
+
+		return path, nil
+	}
+
+	for _, path := range Paths() {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+
+	return "", nil
+}
+
+// Load reads the configuration file at path over the defaults. Settings the
+// file leaves out keep their default values, and an empty path gives the
+// defaults alone.
+func Load(path string) (conf *Configuration, err error) {
+	conf = Defaults()
+	if path == "" {
+		return
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = Parse(data, conf); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return
+}
+
+// Parse reads YAML settings into conf. Unknown settings are an error, so
+// that typos don't go unnoticed.
+func Parse(data []byte, conf *Configuration) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	// An empty file is fine
+	if err := decoder.Decode(conf); err != nil && err != io.EOF {
+		return err
+	}
+
+	return nil
+}
+
+// Marshal writes the configuration out in the same form it's read
+func (c *Configuration) Marshal() ([]byte, error) {
+	var out bytes.Buffer
+
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), encoder.Close()
+}
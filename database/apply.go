@@ -0,0 +1,573 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const sqlDeleteUrlFromLists string = "DELETE FROM url_list_url WHERE url_id = ?;"
+
+var ErrDryRun = errors.New("Dry run")
+
+// A Change is one step Apply takes to bring the database in line with a
+// manifest. Descriptions start with + for additions, - for removals and ~
+// for updates.
+type Change struct {
+	Description string `json:"description"`
+
+	apply func(db *Database) error
+}
+
+// Apply brings the database in line with a manifest, making only the changes
+// needed, in a single transaction. A manifest is a Snapshot, and sections it
+// leaves out aren't touched. With prune, anything in a section that the
+// manifest doesn't mention is removed: lists and URLs are deleted, clients
+// go back to the Default list and no group, and schedules are deleted.
+// Settings are never pruned. With dryRun, the changes are worked out but
+// nothing is changed.
+func (db *Database) Apply(m Snapshot, prune bool, dryRun bool) (changes []Change, err error) {
+	err = db.Transaction(func(tx *Database) (err error) {
+		if changes, err = tx.plan(m, prune); err != nil {
+			return
+		}
+
+		for _, change := range changes {
+			if err = change.apply(tx); err != nil {
+				return fmt.Errorf("%s: %s", change.Description, err)
+			}
+		}
+
+		// Make the changes anyway so that they're checked, then throw them
+		// away
+		if dryRun {
+			return ErrDryRun
+		}
+
+		return
+	})
+
+	if err == ErrDryRun {
+		err = nil
+	}
+
+	return
+}
+
+// plan works out the changes needed, in the order they can be made
+func (db *Database) plan(m Snapshot, prune bool) (changes []Change, err error) {
+	current, err := db.export()
+	if err != nil {
+		return
+	}
+
+	add := func(description string, apply func(db *Database) error) {
+		changes = append(changes, Change{description, apply})
+	}
+
+	// Lists the manifest may refer to
+	lists := map[string]bool{"Default": true}
+	for _, list := range m.Lists {
+		lists[list.Name] = true
+	}
+	if !prune || m.Lists == nil {
+		for _, list := range current.Lists {
+			lists[list.Name] = true
+		}
+	}
+	checkList := func(name string) error {
+		if name != "" && !lists[name] {
+			return fmt.Errorf("No list named %q", name)
+		}
+
+		return nil
+	}
+
+	// URLs
+	urls := make(map[string]bool)
+	for _, url := range current.Urls {
+		urls[url] = true
+	}
+
+	wanted := make(map[string]bool)
+	for _, url := range m.Urls {
+		wanted[url] = true
+	}
+	for _, list := range m.Lists {
+		for _, url := range list.Urls {
+			wanted[url.Url] = true
+		}
+	}
+
+	for _, url := range sortedKeys(wanted) {
+		if !urls[url] {
+			url := url
+			add("+ url "+url, func(db *Database) error {
+				return db.InsertUrl(url)
+			})
+		}
+	}
+
+	// Lists
+	currentLists := make(map[string]SnapshotList)
+	for _, list := range current.Lists {
+		currentLists[list.Name] = list
+	}
+
+	for _, list := range m.Lists {
+		list := list
+		if list.Mode == "" {
+			list.Mode = ModeSequential
+		}
+
+		existing, found := currentLists[list.Name]
+		if !found {
+			add("+ list "+list.Name, func(db *Database) error {
+				return db.InsertList(list.Name)
+			})
+		}
+
+		if found && existing.Mode != list.Mode || !found && list.Mode != ModeSequential {
+			add(fmt.Sprintf("~ list %s mode %s -> %s", list.Name, describeMode(existing.Mode), list.Mode), func(db *Database) error {
+				return db.SetListMode(list.Name, list.Mode)
+			})
+		}
+
+		if !sameListUrls(existing.Urls, list.Urls) {
+			add(fmt.Sprintf("~ list %s urls %s -> %s", list.Name, describeListUrls(existing.Urls), describeListUrls(list.Urls)), func(db *Database) error {
+				return db.setListUrls(list.Name, list.Urls)
+			})
+		}
+	}
+
+	// Groups
+	currentGroups := make(map[string]SnapshotGroup)
+	for _, group := range current.Groups {
+		currentGroups[group.Name] = group
+	}
+
+	for _, group := range m.Groups {
+		group := group
+		if err = checkList(group.List); err != nil {
+			return nil, fmt.Errorf("Group %s: %s", group.Name, err)
+		}
+
+		existing, found := currentGroups[group.Name]
+		if !found {
+			add("+ group "+group.Name, func(db *Database) error {
+				return db.InsertGroup(group.Name)
+			})
+		}
+
+		if existing.List != group.List {
+			add(fmt.Sprintf("~ group %s list %s -> %s", group.Name, describeList(existing.List), describeList(group.List)), func(db *Database) error {
+				if group.List == "" {
+					return db.RemoveGroupFromList(group.Name)
+				}
+
+				return db.AssignGroupToList(group.List, group.Name)
+			})
+		}
+	}
+
+	groups := make(map[string]bool)
+	for _, group := range m.Groups {
+		groups[group.Name] = true
+	}
+	if !prune || m.Groups == nil {
+		for _, group := range current.Groups {
+			groups[group.Name] = true
+		}
+	}
+
+	// Clients
+	currentClients := make(map[string]SnapshotClient)
+	for _, client := range current.Clients {
+		currentClients[client.Identifier] = client
+	}
+
+	managedClients := make(map[string]bool)
+	for _, client := range m.Clients {
+		client := client
+		if client.Identifier == "" {
+			return nil, errors.New("Clients need an identifier")
+		}
+		if err = checkList(client.List); err != nil {
+			return nil, fmt.Errorf("Client %s: %s", client.Identifier, err)
+		}
+		if client.Group != "" && !groups[client.Group] {
+			return nil, fmt.Errorf("Client %s: No group named %q", client.Identifier, client.Group)
+		}
+
+		approval, err := client.approval()
+		if err != nil {
+			return nil, err
+		}
+
+		managedClients[client.Identifier] = true
+
+		existing, found := currentClients[client.Identifier]
+		if !found {
+			add("+ client "+client.Identifier, func(db *Database) error {
+				return db.addClient(client.Identifier, "", approval)
+			})
+			existing.Approval = approval
+		}
+
+		if describeApproval(existing.Approval) != approval {
+			add(fmt.Sprintf("~ client %s approval %s -> %s", client.Identifier, describeApproval(existing.Approval), approval), func(db *Database) error {
+				return db.SetClientApproval(client.Identifier, approval)
+			})
+		}
+
+		if existing.Alias != client.Alias {
+			add(fmt.Sprintf("~ client %s alias %q -> %q", client.Identifier, existing.Alias, client.Alias), func(db *Database) error {
+				return db.SetClientAlias(client.Identifier, client.Alias)
+			})
+		}
+
+		if existing.List != client.List {
+			add(fmt.Sprintf("~ client %s list %s -> %s", client.Identifier, describeClientList(existing.List), describeClientList(client.List)), func(db *Database) error {
+				return db.assignClient(client.Identifier, client.List)
+			})
+		}
+
+		if existing.Group != client.Group {
+			add(fmt.Sprintf("~ client %s group %s -> %s", client.Identifier, describeGroup(existing.Group), describeGroup(client.Group)), func(db *Database) error {
+				return db.groupClient(client.Identifier, client.Group)
+			})
+		}
+	}
+
+	// Schedules
+	for _, schedule := range m.Schedules {
+		schedule := schedule
+		if err = checkList(schedule.Show); err != nil {
+			return nil, fmt.Errorf("Schedule showing %s: %s", schedule.Show, err)
+		}
+		if err = checkList(schedule.List); err != nil {
+			return nil, fmt.Errorf("Schedule showing %s: %s", schedule.Show, err)
+		}
+		if _, found := currentClients[schedule.Client]; schedule.Client != "" && !found && !managedClients[schedule.Client] {
+			return nil, fmt.Errorf("Schedule showing %s: No client %q", schedule.Show, schedule.Client)
+		}
+
+		days, err := ParseDays(schedule.Days)
+		if err != nil {
+			return nil, fmt.Errorf("Schedule showing %s: %s", schedule.Show, err)
+		}
+		schedule.Days = days
+
+		if findSchedule(current.Schedules, schedule) < 0 {
+			add("+ schedule "+describeSchedule(schedule), func(db *Database) error {
+				return db.importSchedule(schedule, nil)
+			})
+		}
+	}
+
+	// Settings
+	var identifiers []string
+	for identifier := range m.Config {
+		identifiers = append(identifiers, identifier)
+	}
+	sort.Strings(identifiers)
+
+	for _, identifier := range identifiers {
+		value := m.Config[identifier]
+		existing, found := current.Config[identifier]
+		if identifier == "schema_version" || isSecret(identifier) || found && existing == value {
+			continue
+		}
+
+		identifier := identifier
+		add("~ config "+identifier, func(db *Database) error {
+			return db.SetConfig(identifier, value)
+		})
+	}
+
+	if !prune {
+		return
+	}
+
+	// Removals come last, so that nothing the manifest wants goes missing on
+	// the way
+	if m.Schedules != nil {
+		manifest := make([]SnapshotSchedule, len(m.Schedules))
+		for i, schedule := range m.Schedules {
+			schedule.Days, _ = ParseDays(schedule.Days)
+			manifest[i] = schedule
+		}
+
+		schedules, err := db.FetchSchedules()
+		if err != nil {
+			return nil, err
+		}
+
+		for i, schedule := range current.Schedules {
+			if findSchedule(manifest, schedule) >= 0 {
+				continue
+			}
+
+			id := schedules[i].Id
+			add("- schedule "+describeSchedule(schedule), func(db *Database) error {
+				return db.DeleteSchedule(id)
+			})
+		}
+	}
+
+	if m.Clients != nil {
+		for _, client := range current.Clients {
+			if managedClients[client.Identifier] {
+				continue
+			}
+
+			client := client
+			if client.List != "" {
+				add(fmt.Sprintf("~ client %s list %s -> %s", client.Identifier, describeClientList(client.List), describeClientList("")), func(db *Database) error {
+					return db.RemoveClientFromList(client.Identifier)
+				})
+			}
+			if client.Group != "" {
+				add(fmt.Sprintf("~ client %s group %s -> %s", client.Identifier, describeGroup(client.Group), describeGroup("")), func(db *Database) error {
+					return db.RemoveClientFromGroup(client.Identifier)
+				})
+			}
+		}
+	}
+
+	if m.Groups != nil {
+		for _, group := range current.Groups {
+			if groups[group.Name] {
+				continue
+			}
+
+			name := group.Name
+			add("- group "+name, func(db *Database) error {
+				return db.DeleteGroup(name)
+			})
+		}
+	}
+
+	for _, list := range current.Lists {
+		if lists[list.Name] {
+			continue
+		}
+
+		// Deleting a list moves its URLs to the Default list, so take
+		// them out first
+		name := list.Name
+		add("- list "+name, func(db *Database) error {
+			if err := db.setListUrls(name, nil); err != nil {
+				return err
+			}
+
+			return db.DeleteList(name)
+		})
+	}
+
+	if m.Urls == nil && m.Lists == nil {
+		return
+	}
+
+	// URLs still in a list that's staying aren't removed
+	kept := make(map[string]bool)
+	for _, list := range current.Lists {
+		if lists[list.Name] && findManifestList(m.Lists, list.Name) < 0 {
+			for _, url := range list.Urls {
+				kept[url.Url] = true
+			}
+		}
+	}
+
+	for _, url := range current.Urls {
+		if wanted[url] || kept[url] {
+			continue
+		}
+
+		url := url
+		add("- url "+url, func(db *Database) error {
+			id, err := db.FindUrlId(url)
+			if err != nil {
+				return err
+			}
+
+			if _, err = db.Conn.Exec(sqlDeleteUrlFromLists, id); err != nil {
+				return err
+			}
+
+			return db.DeleteUrl(url)
+		})
+	}
+
+	return
+}
+
+// setListUrls makes a list hold exactly urls, in order
+func (db *Database) setListUrls(name string, urls []ListUrl) error {
+	return db.audited("set urls", "list", name, (*Database).listState, func(tx *Database) error {
+		return tx.replaceListUrls(name, urls)
+	})
+}
+
+func (db *Database) replaceListUrls(name string, urls []ListUrl) (err error) {
+	id, err := db.findList(name)
+	if err != nil {
+		return
+	}
+
+	if _, err = db.Conn.Exec(sqlClearList, id); err != nil {
+		return
+	}
+
+	for _, url := range urls {
+		if err = db.assignUrlToList(name, url.Url); err != nil {
+			return
+		}
+
+		if err = db.setListUrlDuration(name, url.Url, url.Duration); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// assignClient puts a client on a list, or back on its group's list if list
+// is empty
+func (db *Database) assignClient(identifier string, list string) error {
+	if list == "" {
+		return db.RemoveClientFromList(identifier)
+	}
+
+	return db.AssignClientToList(list, identifier)
+}
+
+// groupClient puts a client in a group, or in none if group is empty
+func (db *Database) groupClient(identifier string, group string) error {
+	if group == "" {
+		return db.RemoveClientFromGroup(identifier)
+	}
+
+	if _, err := db.FindGroupId(group); err == sql.ErrNoRows {
+		return fmt.Errorf("No group named %q", group)
+	}
+
+	return db.AddClientToGroup(group, identifier)
+}
+
+func sameListUrls(a []ListUrl, b []ListUrl) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func findSchedule(schedules []SnapshotSchedule, schedule SnapshotSchedule) int {
+	for i, s := range schedules {
+		if s == schedule {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func findManifestList(lists []SnapshotList, name string) int {
+	for i, list := range lists {
+		if list.Name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func sortedKeys(m map[string]bool) (keys []string) {
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return
+}
+
+func describeListUrls(urls []ListUrl) string {
+	var parts []string
+	for _, url := range urls {
+		if url.Duration > 0 {
+			parts = append(parts, fmt.Sprintf("%s (%ds)", url.Url, url.Duration))
+		} else {
+			parts = append(parts, url.Url)
+		}
+	}
+
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+func describeMode(mode string) string {
+	if mode == "" {
+		return ModeSequential
+	}
+
+	return mode
+}
+
+func describeList(name string) string {
+	if name == "" {
+		return "Default"
+	}
+
+	return name
+}
+
+func describeClientList(name string) string {
+	if name == "" {
+		return "(group)"
+	}
+
+	return name
+}
+
+func describeApproval(approval string) string {
+	if approval == "" {
+		return ApprovalApproved
+	}
+
+	return approval
+}
+
+func describeGroup(name string) string {
+	if name == "" {
+		return "(none)"
+	}
+
+	return name
+}
+
+func describeSchedule(s SnapshotSchedule) string {
+	target := "list " + s.List
+	if s.Client != "" {
+		target = "client " + s.Client
+	}
+
+	days := s.Days
+	if days == "" {
+		days = "every day"
+	}
+
+	description := fmt.Sprintf("%s on %s, %s %s-%s", s.Show, target, days, s.Start, s.End)
+	if s.Timezone != "" {
+		description += " " + s.Timezone
+	}
+
+	return description
+}
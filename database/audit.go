@@ -0,0 +1,334 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// audit table
+	sqlInsertAudit string = `
+	INSERT INTO audit (actor, source, address, action, object_type, object, before, after)
+	VALUES(?, ?, ?, ?, ?, ?, ?, ?);
+	`
+	sqlFetchAudit string = `
+	SELECT id, created_at, actor, source, address, action, object_type, object, before, after
+	FROM audit
+	`
+
+	auditTimeFormat string = "2006-01-02 15:04:05"
+
+	// hiddenValue stands in for secrets in the audit log
+	hiddenValue string = "(hidden)"
+)
+
+// Sources of changes
+const (
+	SourceCli       string = "cli"
+	SourceApi       string = "api"
+	SourceConsole   string = "console"
+	SourceWebsocket string = "websocket"
+)
+
+// An Actor is who made a change: the user running a CLI command, or the
+// console session or API credentials used, and where they came from
+type Actor struct {
+	Name    string `json:"name"`
+	Source  string `json:"source"`
+	Address string `json:"address,omitempty"`
+}
+
+func (a Actor) String() string {
+	name := a.Name
+	if name == "" {
+		name = "anonymous"
+	}
+
+	if a.Address != "" {
+		name += "@" + a.Address
+	}
+
+	if a.Source == "" {
+		return name
+	}
+
+	return name + " (" + a.Source + ")"
+}
+
+// An AuditEntry records one change to the setup. Before and After describe
+// the object on either side of it; an empty value means it didn't exist.
+type AuditEntry struct {
+	Id     int    `json:"id"`
+	Time   string `json:"time"`
+	Actor  Actor  `json:"actor"`
+	Action string `json:"action"`
+	Type   string `json:"type"`
+	Object string `json:"object"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// An AuditFilter narrows down FetchAudit. Zero fields match everything, and
+// Limit keeps only the newest entries.
+type AuditFilter struct {
+	Type   string    `json:"type"`
+	Object string    `json:"object"`
+	Actor  string    `json:"actor"`
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until"`
+	Limit  int       `json:"limit"`
+}
+
+// As returns a handle on the same database whose changes are recorded in the
+// audit log as made by actor
+func (db *Database) As(actor Actor) *Database {
+	return &Database{Conn: db.Conn, Actor: actor}
+}
+
+// audit records a change made by the database's actor
+func (db *Database) audit(action string, kind string, object string, before string, after string) (err error) {
+	if kind == "config" && isSecret(object) {
+		before, after = hide(before), hide(after)
+	}
+
+	_, err = db.Conn.Exec(sqlInsertAudit,
+		db.Actor.Name,
+		db.Actor.Source,
+		db.Actor.Address,
+		action,
+		kind,
+		object,
+		before,
+		after)
+
+	return
+}
+
+func isSecret(identifier string) bool {
+	return identifier == "password"
+}
+
+func hide(value string) string {
+	if value == "" {
+		return ""
+	}
+
+	return hiddenValue
+}
+
+// audited makes change in a transaction and records it in the audit log, with
+// the object's state on either side of it as given by state. Changes that
+// leave the object as it was aren't recorded.
+func (db *Database) audited(action string, kind string, object string, state func(db *Database, object string) (string, error), change func(tx *Database) error) error {
+	return db.Transaction(func(tx *Database) (err error) {
+		before, err := state(tx, object)
+		if err != nil {
+			return
+		}
+
+		if err = change(tx); err != nil {
+			return
+		}
+
+		after, err := state(tx, object)
+		if err != nil || before == after {
+			return
+		}
+
+		return tx.audit(action, kind, object, before, after)
+	})
+}
+
+// auditedClient is audited for a client, which may be given by alias but is
+// recorded by identifier
+func (db *Database) auditedClient(action string, identifier string, change func(tx *Database) error) error {
+	return db.Transaction(func(tx *Database) error {
+		if info, err := tx.GetClient(identifier); err == nil {
+			identifier = info.Identifier
+		}
+
+		return tx.audited(action, "client", identifier, (*Database).clientState, change)
+	})
+}
+
+func auditState(v interface{}) (string, error) {
+	state, err := json.Marshal(v)
+	return string(state), err
+}
+
+func (db *Database) urlState(url string) (state string, err error) {
+	_, err = db.FindUrlId(url)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return url, err
+}
+
+func (db *Database) listState(name string) (state string, err error) {
+	list, err := db.GetList(name)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return
+	}
+
+	urls, err := db.FetchListUrlsById(list.Id)
+	if err != nil {
+		return
+	}
+	if urls == nil {
+		urls = []ListUrl{}
+	}
+
+	return auditState(SnapshotList{list.Name, list.Mode, urls})
+}
+
+func (db *Database) clientState(identifier string) (state string, err error) {
+	info, err := db.GetClient(identifier)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return
+	}
+
+	list, err := db.listName(info.UrlListId)
+	if err != nil {
+		return
+	}
+
+	var group string
+	if info.GroupId != NoGroup {
+		g, err := db.GetGroupById(info.GroupId)
+		if err != nil && err != sql.ErrNoRows {
+			return "", err
+		}
+
+		group = g.Name
+	}
+
+	return auditState(SnapshotClient{info.Identifier, info.Alias, list, group, snapshotApproval(info.Approval)})
+}
+
+func (db *Database) groupState(name string) (state string, err error) {
+	group, err := db.GetGroup(name)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return
+	}
+
+	list, err := db.listName(group.UrlListId)
+	if err != nil {
+		return
+	}
+
+	return auditState(SnapshotGroup{group.Name, list})
+}
+
+func (db *Database) scheduleState(id string) (state string, err error) {
+	schedules, err := db.FetchSchedules()
+	if err != nil {
+		return
+	}
+
+	for _, s := range schedules {
+		if strconv.Itoa(s.Id) == id {
+			return auditState(SnapshotSchedule{
+				Client:   s.Client,
+				List:     s.List,
+				Show:     s.Show,
+				Days:     s.Days,
+				Start:    s.Start,
+				End:      s.End,
+				Timezone: s.Timezone,
+			})
+		}
+	}
+
+	return "", nil
+}
+
+func (db *Database) configState(identifier string) (state string, err error) {
+	state, err = db.GetConfig(identifier)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return
+}
+
+// FetchAudit returns the audit log entries matching f, oldest first
+func (db *Database) FetchAudit(f AuditFilter) (entries []AuditEntry, err error) {
+	var where []string
+	var args []interface{}
+
+	if f.Type != "" {
+		where = append(where, "object_type = ?")
+		args = append(args, f.Type)
+	}
+	if f.Object != "" {
+		where = append(where, "object = ?")
+		args = append(args, f.Object)
+	}
+	if f.Actor != "" {
+		where = append(where, "actor = ?")
+		args = append(args, f.Actor)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, f.Since.UTC().Format(auditTimeFormat))
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, f.Until.UTC().Format(auditTimeFormat))
+	}
+
+	query := sqlFetchAudit
+	if len(where) > 0 {
+		query += "WHERE " + strings.Join(where, " AND ") + "\n"
+	}
+	query += "ORDER BY id DESC"
+	if f.Limit > 0 {
+		query += " LIMIT " + strconv.Itoa(f.Limit)
+	}
+
+	rows, err := db.Conn.Query(query+";", args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry AuditEntry
+
+		err = rows.Scan(
+			&entry.Id,
+			&entry.Time,
+			&entry.Actor.Name,
+			&entry.Actor.Source,
+			&entry.Actor.Address,
+			&entry.Action,
+			&entry.Type,
+			&entry.Object,
+			&entry.Before,
+			&entry.After)
+
+		if err != nil {
+			return
+		}
+
+		// Newest first so the limit keeps the latest, then back in order
+		entries = append([]AuditEntry{entry}, entries...)
+	}
+
+	err = rows.Err()
+
+	return
+}
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sqlBackup string = "VACUUM INTO ?;"
+
+	backupTimeFormat string = "20060102-150405"
+)
+
+var (
+	ErrBackupExists = errors.New("A file already exists where the backup would go")
+	ErrNotDatabase  = errors.New("That isn't a wbd database")
+)
+
+// Backup writes a consistent copy of the database to path, which mustn't
+// exist yet. It's safe to take while the daemon is writing.
+func (db *Database) Backup(path string) (err error) {
+	if _, err = os.Stat(path); err == nil {
+		return ErrBackupExists
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+
+	_, err = db.Conn.Exec(sqlBackup, path)
+	return
+}
+
+// BackupPath is where a backup of the database at path taken at t goes in
+// dir, e.g. backups/wbd-20260102-150405.db. An empty dir means next to the
+// database.
+func BackupPath(dir string, path string, t time.Time) string {
+	if dir == "" {
+		dir = filepath.Dir(path)
+	}
+
+	base, ext := backupName(path)
+	return filepath.Join(dir, base+"-"+t.UTC().Format(backupTimeFormat)+ext)
+}
+
+func backupName(path string) (base string, ext string) {
+	ext = filepath.Ext(path)
+	base = strings.TrimSuffix(filepath.Base(path), ext)
+	return
+}
+
+// Backups returns the backups of the database at path in dir, oldest first
+func Backups(dir string, path string) (backups []string, err error) {
+	if dir == "" {
+		dir = filepath.Dir(path)
+	}
+
+	base, ext := backupName(path)
+	matches, err := filepath.Glob(filepath.Join(dir, base+"-*"+ext))
+	if err != nil {
+		return
+	}
+
+	for _, match := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(match), base+"-"), ext)
+		if _, err := time.Parse(backupTimeFormat, stamp); err == nil {
+			backups = append(backups, match)
+		}
+	}
+
+	// The timestamps sort in the order they were taken
+	sort.Strings(backups)
+
+	return
+}
+
+// PruneBackups deletes all but the newest keep backups of the database at
+// path in dir, returning the ones it deleted
+func PruneBackups(dir string, path string, keep int) (removed []string, err error) {
+	backups, err := Backups(dir, path)
+	if err != nil || len(backups) <= keep {
+		return
+	}
+
+	for _, backup := range backups[:len(backups)-keep] {
+		if err = os.Remove(backup); err != nil {
+			return
+		}
+
+		removed = append(removed, backup)
+	}
+
+	return
+}
+
+// RecordRestore notes in the audit log that the database was restored from
+// backup. Changes made since the backup was taken are gone from the log with
+// everything else.
+func (db *Database) RecordRestore(backup string) error {
+	return db.audit("restore", "database", backup, "", "")
+}
+
+// Restore replaces the database at path with the backup at backup. Nothing
+// may have the database open. The backup is checked and copied next to the
+// database first, then moved into place, so a failed restore leaves the
+// database as it was.
+func Restore(backup string, path string) (err error) {
+	if _, err = os.Stat(backup); err != nil {
+		return
+	}
+
+	source, err := Connect(backup)
+	if err != nil {
+		return
+	}
+	defer source.Close()
+
+	version, err := source.SchemaVersion()
+	if err != nil || version == 0 {
+		return ErrNotDatabase
+	}
+
+	temp := path + ".restore"
+	os.Remove(temp)
+
+	if err = source.Backup(temp); err != nil {
+		return
+	}
+
+	if err = os.Rename(temp, path); err != nil {
+		os.Remove(temp)
+	}
+
+	return
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Conn is the database handle, timing the queries run through it so they can
+// be reported as metrics. Queries run directly on a Tx aren't timed.
+type Conn struct {
+	*sql.DB
+
+	// Observe, if set, is called after every query with its operation
+	// (SELECT, INSERT, ...) and how long it took
+	Observe func(operation string, duration time.Duration)
+
+	// tx is set on the Conn that Database.Transaction hands out, and every
+	// query goes through it
+	tx *sql.Tx
+}
+
+// Tx is a transaction begun on a Conn. Inside Database.Transaction, Begin
+// returns the transaction that's already running, and committing or rolling
+// it back is left to Transaction.
+type Tx struct {
+	*sql.Tx
+	nested bool
+}
+
+func (tx *Tx) Commit() error {
+	if tx.nested {
+		return nil
+	}
+
+	return tx.Tx.Commit()
+}
+
+func (tx *Tx) Rollback() error {
+	if tx.nested {
+		return nil
+	}
+
+	return tx.Tx.Rollback()
+}
+
+func (c *Conn) Begin() (*Tx, error) {
+	if c.tx != nil {
+		return &Tx{c.tx, true}, nil
+	}
+
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tx{tx, false}, nil
+}
+
+func (c *Conn) observe(query string, start time.Time) {
+	if c.Observe == nil {
+		return
+	}
+
+	c.Observe(queryOperation(query), time.Since(start))
+}
+
+// queryOperation returns the statement a query starts with, e.g. SELECT
+func queryOperation(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return strings.ToUpper(fields[0])
+}
+
+func (c *Conn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	defer c.observe(query, time.Now())
+	if c.tx != nil {
+		return c.tx.Exec(query, args...)
+	}
+
+	return c.DB.Exec(query, args...)
+}
+
+func (c *Conn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	defer c.observe(query, time.Now())
+	if c.tx != nil {
+		return c.tx.Query(query, args...)
+	}
+
+	return c.DB.Query(query, args...)
+}
+
+func (c *Conn) QueryRow(query string, args ...interface{}) *sql.Row {
+	defer c.observe(query, time.Now())
+	if c.tx != nil {
+		return c.tx.QueryRow(query, args...)
+	}
+
+	return c.DB.QueryRow(query, args...)
+}
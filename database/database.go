@@ -3,6 +3,7 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -44,58 +45,182 @@ CREATE TABLE url_list_url (
 
 	// clients table
 	sqlInsertClient string = `
-	INSERT INTO clients (identifier, ip_address)
-	VALUES(?, ?);
+	INSERT INTO clients (identifier, ip_address, approval)
+	VALUES(?, ?, ?);
 	`
 	sqlGetClient string = `
-	SELECT identifier, alias, ip_address, last_ping, url_list_id
+	SELECT identifier, alias, ip_address, last_ping, url_list_id, group_id, approval
 	FROM clients WHERE identifier = ? OR alias = ?;
 	`
 	sqlSetClientList      string = "UPDATE clients SET url_list_id = ? WHERE identifier = ? OR alias = ?;"
 	sqlSetClientIpAddress string = "UPDATE clients SET ip_address = ? WHERE identifier = ?;"
 	sqlSetClientAlias     string = "UPDATE clients SET alias = ? WHERE identifier = ? OR alias = ?;"
 	sqlDeleteClient       string = "DELETE FROM clients WHERE identifier = ? OR alias = ?;"
-	sqlFetchClients       string = "SELECT identifier, alias, ip_address, last_ping, url_list_id FROM clients ORDER BY last_ping ASC;"
+	sqlSetClientGroup     string = "UPDATE clients SET group_id = ? WHERE identifier = ? OR alias = ?;"
+	sqlFetchClients       string = "SELECT identifier, alias, ip_address, last_ping, url_list_id, group_id, approval FROM clients ORDER BY last_ping ASC;"
+	sqlSetClientApproval  string = "UPDATE clients SET approval = ? WHERE identifier = ?;"
 	sqlTouchClient        string = "UPDATE clients SET last_ping = CURRENT_TIMESTAMP WHERE identifier = ?;"
 	sqlCleanOrphanClients string = "UPDATE clients SET url_list_id = 0 WHERE url_list_id = ?;"
 
+	// client_status table
+	sqlSetClientStatus string = `
+	INSERT OR REPLACE INTO client_status
+		(identifier, url, position, count, paused, flashing, loaded, error, uptime, updated_at)
+	VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP);
+	`
+	sqlGetClientStatus string = `
+	SELECT url, position, count, paused, flashing, loaded, error, uptime, updated_at
+	FROM client_status WHERE identifier = ?;
+	`
+	sqlDeleteClientStatus string = "DELETE FROM client_status WHERE identifier = ?;"
+
 	// urls table
 	sqlFindUrlId       string = "SELECT id FROM urls WHERE url = ?;"
 	sqlInsertUrl       string = "INSERT INTO urls(url) VALUES(?);"
 	sqlFetchUrls       string = "SELECT url FROM urls;"
 	sqlDeleteUrl       string = "DELETE FROM urls WHERE url = ?;"
 	sqlCleanOrphanUrls string = "UPDATE url_list_url SET url_list_id = 0 WHERE url_list_id = ?;"
+	sqlShiftOrphanUrls string = `
+	UPDATE url_list_url
+	SET position = position + (SELECT COALESCE(MAX(position), 0) FROM url_list_url WHERE url_list_id = 0)
+	WHERE url_list_id = ?;
+	`
 
 	// url_lists table
-	sqlFindListId string = "SELECT id FROM url_lists WHERE name = ?;"
-	sqlInsertList string = "INSERT INTO url_lists(name) VALUES(?);"
-	sqlFetchLists string = "SELECT name FROM url_lists;"
-	sqlDeleteList string = "DELETE FROM url_lists WHERE id = ?;"
+	sqlFindListId   string = "SELECT id FROM url_lists WHERE name = ?;"
+	sqlFindListName string = "SELECT name FROM url_lists WHERE id = ?;"
+	sqlGetList      string = "SELECT id, name, mode FROM url_lists WHERE name = ?;"
+	sqlGetListById  string = "SELECT id, name, mode FROM url_lists WHERE id = ?;"
+	sqlSetListMode  string = "UPDATE url_lists SET mode = ? WHERE id = ?;"
+	sqlInsertList   string = "INSERT INTO url_lists(name) VALUES(?);"
+	sqlFetchLists   string = "SELECT name FROM url_lists;"
+	sqlDeleteList   string = "DELETE FROM url_lists WHERE id = ?;"
 
 	// url_lists_url table
-	sqlInsertListUrl string = "INSERT INTO url_list_url(url_list_id, url_id) VALUES(?, ?);"
-	sqlDeleteListUrl string = "DELETE FROM url_list_url WHERE url_list_id = ? AND url_id = ?;"
-	sqlFetchListUrls string = `
-	SELECT url FROM urls
+	sqlInsertListUrl string = `
+	INSERT INTO url_list_url(url_list_id, url_id, position)
+	VALUES(?, ?, (SELECT COALESCE(MAX(position), 0) + 1 FROM url_list_url WHERE url_list_id = ?));
+	`
+	sqlFetchListRowIds    string = "SELECT id, url_id FROM url_list_url WHERE url_list_id = ? ORDER BY position, id;"
+	sqlSetListUrlPosition string = "UPDATE url_list_url SET position = ? WHERE id = ?;"
+	sqlDeleteListUrl      string = "DELETE FROM url_list_url WHERE url_list_id = ? AND url_id = ?;"
+	sqlCountListUrl       string = "SELECT count(*) FROM url_list_url WHERE url_list_id = ? AND url_id = ?;"
+	sqlSetListUrlDuration string = "UPDATE url_list_url SET duration = ? WHERE url_list_id = ? AND url_id = ?;"
+	sqlFetchListUrls      string = `
+	SELECT url, duration FROM urls
 	INNER JOIN url_list_url ON url_list_url.url_id = urls.id
-	WHERE url_list_id = ?;
+	WHERE url_list_id = ?
+	ORDER BY url_list_url.position, url_list_url.id;
+	`
+
+	// commands table
+	sqlQueueCommand  string = "INSERT INTO commands(action, data, client, list) VALUES(?, ?, ?, ?);"
+	sqlFetchCommands string = `
+	SELECT id, action, data, client, list,
+		CAST(strftime('%s', 'now') - strftime('%s', created_at) AS INTEGER)
+	FROM commands ORDER BY id;
 	`
+	sqlDeleteCommand string = "DELETE FROM commands WHERE id = ?;"
 
+	// revision table
+	sqlGetRevision string = "SELECT value FROM revision;"
+
+	// config table
 	sqlInsertConfig string = "INSERT INTO config(identifier, value) VALUES(?, ?);"
+	sqlGetConfig    string = "SELECT value FROM config WHERE identifier = ?;"
+	sqlDeleteConfig string = "DELETE FROM config WHERE identifier = ?;"
 
 	DefaultList int = 0
+
+	// NoGroup is the group id of clients that aren't in a group
+	NoGroup int = 0
+
+	// rotation modes
+	ModeSequential string = "sequential"
+	ModeShuffle    string = "shuffle"
+	ModeStagger    string = "stagger"
+
+	// client approval states; only approved clients are shown their lists
+	// when approval is required
+	ApprovalApproved string = "approved"
+	ApprovalPending  string = "pending"
+	ApprovalRejected string = "rejected"
+)
+
+var (
+	ErrNegativeDuration = errors.New("Duration can't be negative")
+	ErrUrlExists        = errors.New("That URL already exists")
+	ErrListExists       = errors.New("A URL list already exists with that name")
+	ErrDefaultList      = errors.New("Cannot delete the Default URL list")
+	ErrInvalidMode      = errors.New("Rotation mode must be one of sequential, shuffle or stagger")
+	ErrGroupExists      = errors.New("A client group already exists with that name")
+	ErrInvalidApproval  = errors.New("Approval must be one of approved, pending or rejected")
 )
 
+// A ListUrl is a URL as it appears in a list. A Duration of 0 leaves the time
+// on screen up to the display.
+type ListUrl struct {
+	Url      string `json:"url" yaml:"url"`
+	Duration int    `json:"duration" yaml:"duration,omitempty"`
+}
+
+type List struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+	Mode string `json:"mode"`
+}
+
+// A Command is an action queued for the running daemon to send to displays.
+// Client and List narrow down who receives it; leaving both empty sends it
+// to every display.
+type Command struct {
+	Id     int
+	Action string
+	Data   string
+	Client string
+	List   string
+	Age    int
+}
+
 type Database struct {
-	Conn *sql.DB
+	Conn *Conn
+
+	// Actor is who changes made through this handle are recorded as in the
+	// audit log
+	Actor Actor
 }
 
+// A Client assigned to a list of its own shows that instead of its group's
+// list. Clients left on the Default list follow their group.
 type Client struct {
-	Identifier string
-	Alias      string
-	IpAddress  string
-	LastPing   string
-	UrlListId  int
+	Identifier string `json:"identifier"`
+	Alias      string `json:"alias"`
+	IpAddress  string `json:"ip_address"`
+	LastPing   string `json:"last_ping"`
+	UrlListId  int    `json:"url_list_id"`
+	GroupId    int    `json:"group_id"`
+	Approval   string `json:"approval"`
+}
+
+// A ClientStatus is what a display last reported it was doing. Position
+// counts from 1, and Uptime is how many seconds the page has been open.
+type ClientStatus struct {
+	Url      string `json:"url"`
+	Position int    `json:"position"`
+	Count    int    `json:"count"`
+	Paused   bool   `json:"paused"`
+	Flashing bool   `json:"flashing"`
+	Loaded   bool   `json:"loaded"`
+	Error    string `json:"error"`
+	Uptime   int    `json:"uptime"`
+	Updated  string `json:"updated"`
+}
+
+// A Group's list is shown by every member without a list of its own
+type Group struct {
+	Id        int    `json:"id"`
+	Name      string `json:"name"`
+	UrlListId int    `json:"url_list_id"`
 }
 
 func (db *Database) Close() (err error) {
@@ -103,12 +228,97 @@ func (db *Database) Close() (err error) {
 	return
 }
 
-func (db *Database) InsertClient(identifier string, ip_address string) (err error) {
-	_, err = db.Conn.Exec(sqlInsertClient, identifier, ip_address)
+func (db *Database) InsertClient(identifier string, ip_address string) error {
+	return db.addClient(identifier, ip_address, ApprovalApproved)
+}
+
+// InsertPendingClient records a client that may only be shown the welcome
+// page until it's approved
+func (db *Database) InsertPendingClient(identifier string, ip_address string) error {
+	return db.addClient(identifier, ip_address, ApprovalPending)
+}
+
+func (db *Database) addClient(identifier string, ip_address string, approval string) error {
+	return db.audited("insert", "client", identifier, (*Database).clientState, func(tx *Database) error {
+		return tx.insertClient(identifier, ip_address, approval)
+	})
+}
+
+func (db *Database) insertClient(identifier string, ip_address string, approval string) (err error) {
+	_, err = db.Conn.Exec(sqlInsertClient, identifier, ip_address, approval)
 	return
 }
 
-func (db *Database) DeleteClient(identifier string) (err error) {
+func (db *Database) ApproveClient(identifier string) error {
+	return db.auditedClient("approve", identifier, func(tx *Database) error {
+		return tx.setClientApproval(identifier, ApprovalApproved)
+	})
+}
+
+// RejectClient keeps a client on the welcome page. It stays rejected, rather
+// than pending again, if it reconnects.
+func (db *Database) RejectClient(identifier string) error {
+	return db.auditedClient("reject", identifier, func(tx *Database) error {
+		return tx.setClientApproval(identifier, ApprovalRejected)
+	})
+}
+
+// SetClientApproval puts a client back into any approval state, as imports
+// and manifests do
+func (db *Database) SetClientApproval(identifier string, approval string) error {
+	if !validApproval(approval) {
+		return ErrInvalidApproval
+	}
+
+	return db.auditedClient("set approval", identifier, func(tx *Database) error {
+		return tx.setClientApproval(identifier, approval)
+	})
+}
+
+func validApproval(approval string) bool {
+	switch approval {
+	case ApprovalApproved, ApprovalPending, ApprovalRejected:
+		return true
+	}
+
+	return false
+}
+
+func (db *Database) setClientApproval(identifier string, approval string) (err error) {
+	info, err := db.GetClient(identifier)
+	if err != nil {
+		return
+	}
+
+	_, err = db.Conn.Exec(sqlSetClientApproval, approval, info.Identifier)
+	return
+}
+
+func (db *Database) DeleteClient(identifier string) error {
+	return db.auditedClient("delete", identifier, func(tx *Database) error {
+		return tx.deleteClient(identifier)
+	})
+}
+
+func (db *Database) deleteClient(identifier string) (err error) {
+	info, err := db.GetClient(identifier)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+
+	_, err = db.Conn.Exec(sqlDeleteClientSchedules, info.Identifier)
+	if err != nil {
+		return
+	}
+
+	_, err = db.Conn.Exec(sqlDeleteClientStatus, info.Identifier)
+	if err != nil {
+		return
+	}
+
 	_, err = db.Conn.Exec(sqlDeleteClient, identifier, identifier)
 	return
 }
@@ -118,7 +328,13 @@ func (db *Database) SetClientIpAddress(identifier string, ip_address string) (er
 	return
 }
 
-func (db *Database) SetClientAlias(identifier string, alias string) (err error) {
+func (db *Database) SetClientAlias(identifier string, alias string) error {
+	return db.auditedClient("set alias", identifier, func(tx *Database) error {
+		return tx.setClientAlias(identifier, alias)
+	})
+}
+
+func (db *Database) setClientAlias(identifier string, alias string) (err error) {
 	_, err = db.Conn.Exec(sqlSetClientAlias, alias, identifier, identifier)
 	return
 }
@@ -128,7 +344,13 @@ func (db *Database) TouchClient(identifier string) (err error) {
 	return
 }
 
-func (db *Database) AssignClientToList(name string, client_id string) (err error) {
+func (db *Database) AssignClientToList(name string, client_id string) error {
+	return db.auditedClient("assign", client_id, func(tx *Database) error {
+		return tx.assignClientToList(name, client_id)
+	})
+}
+
+func (db *Database) assignClientToList(name string, client_id string) (err error) {
 	list_id, err := db.FindListId(name)
 	if err != nil {
 		return
@@ -138,7 +360,15 @@ func (db *Database) AssignClientToList(name string, client_id string) (err error
 	return
 }
 
-func (db *Database) RemoveClientFromList(client_id string) (err error) {
+// RemoveClientFromList leaves a client showing its group's list, or the
+// Default list if it isn't in a group
+func (db *Database) RemoveClientFromList(client_id string) error {
+	return db.auditedClient("unassign", client_id, func(tx *Database) error {
+		return tx.removeClientFromList(client_id)
+	})
+}
+
+func (db *Database) removeClientFromList(client_id string) (err error) {
 	_, err = db.Conn.Exec(sqlSetClientList, DefaultList, client_id, client_id)
 	return
 }
@@ -158,7 +388,9 @@ func (db *Database) FetchClients() (clients []Client, err error) {
 			&client.Alias,
 			&client.IpAddress,
 			&client.LastPing,
-			&client.UrlListId)
+			&client.UrlListId,
+			&client.GroupId,
+			&client.Approval)
 
 		if err != nil {
 			return
@@ -178,43 +410,111 @@ func (db *Database) GetClient(identifier string) (client Client, err error) {
 		&client.Alias,
 		&client.IpAddress,
 		&client.LastPing,
-		&client.UrlListId)
+		&client.UrlListId,
+		&client.GroupId,
+		&client.Approval)
+
+	return
+}
+
+// SetClientStatus saves the latest status a client reported
+func (db *Database) SetClientStatus(identifier string, status ClientStatus) (err error) {
+	_, err = db.Conn.Exec(sqlSetClientStatus,
+		identifier,
+		status.Url,
+		status.Position,
+		status.Count,
+		status.Paused,
+		status.Flashing,
+		status.Loaded,
+		status.Error,
+		status.Uptime)
 
 	return
 }
 
-func (db *Database) InsertUrl(url string) (err error) {
+// GetClientStatus returns the last status a client reported, which may be
+// stale if it has since disconnected
+func (db *Database) GetClientStatus(identifier string) (status ClientStatus, err error) {
+	err = db.Conn.QueryRow(sqlGetClientStatus, identifier).Scan(
+		&status.Url,
+		&status.Position,
+		&status.Count,
+		&status.Paused,
+		&status.Flashing,
+		&status.Loaded,
+		&status.Error,
+		&status.Uptime,
+		&status.Updated)
+
+	return
+}
+
+func (db *Database) InsertUrl(url string) error {
+	return db.audited("insert", "url", url, (*Database).urlState, func(tx *Database) error {
+		return tx.insertUrl(url)
+	})
+}
+
+func (db *Database) insertUrl(url string) (err error) {
+	_, err = db.FindUrlId(url)
+	if err != nil && err != sql.ErrNoRows {
+		return
+	}
+
+	if err == nil {
+		return ErrUrlExists
+	}
+
 	_, err = db.Conn.Exec(sqlInsertUrl, url)
 	return
 }
 
-func (db *Database) DeleteUrl(url string) (err error) {
+func (db *Database) DeleteUrl(url string) error {
+	return db.audited("delete", "url", url, (*Database).urlState, func(tx *Database) error {
+		return tx.deleteUrl(url)
+	})
+}
+
+func (db *Database) deleteUrl(url string) (err error) {
 	_, err = db.Conn.Exec(sqlDeleteUrl, url)
 	return
 }
 
-func (db *Database) InsertList(name string) (err error) {
+func (db *Database) InsertList(name string) error {
+	return db.audited("insert", "list", name, (*Database).listState, func(tx *Database) error {
+		return tx.insertList(name)
+	})
+}
+
+func (db *Database) insertList(name string) (err error) {
 	_, err = db.FindListId(name)
 	if err != nil && err != sql.ErrNoRows {
 		return
 	}
 
 	if err == nil {
-		return errors.New("A URL list already exists with that name")
+		return ErrListExists
 	}
 
 	_, err = db.Conn.Exec(sqlInsertList, name)
 	return
 }
 
-func (db *Database) DeleteList(name string) (err error) {
+func (db *Database) DeleteList(name string) error {
+	return db.audited("delete", "list", name, (*Database).listState, func(tx *Database) error {
+		return tx.deleteList(name)
+	})
+}
+
+func (db *Database) deleteList(name string) (err error) {
 	id, err := db.FindListId(name)
 	if err != nil {
 		return
 	}
 
 	if id == DefaultList {
-		return errors.New("Cannot delete the Default URL list")
+		return ErrDefaultList
 	}
 
 	_, err = db.Conn.Exec(sqlDeleteList, id)
@@ -227,19 +527,90 @@ func (db *Database) DeleteList(name string) (err error) {
 		return
 	}
 
+	// The list's URLs go to the end of the Default list, in their order
+	_, err = db.Conn.Exec(sqlShiftOrphanUrls, id)
+	if err != nil {
+		return
+	}
+
 	_, err = db.Conn.Exec(sqlCleanOrphanUrls, id)
 	if err != nil {
 		return
 	}
 
+	err = db.reorderList(DefaultList, func(tx *Tx, rows []listRow) ([]listRow, error) {
+		return rows, nil
+	})
+	if err != nil {
+		return
+	}
+
+	_, err = db.Conn.Exec(sqlCleanOrphanGroups, id)
+	if err != nil {
+		return
+	}
+
+	_, err = db.Conn.Exec(sqlDeleteListSchedules, id, id)
+	if err != nil {
+		return
+	}
+
 	return
 }
 
-func (db *Database) InsertConfig(identifier string, value string) (err error) {
+func (db *Database) InsertConfig(identifier string, value string) error {
+	return db.audited("insert", "config", identifier, (*Database).configState, func(tx *Database) error {
+		return tx.insertConfig(identifier, value)
+	})
+}
+
+func (db *Database) insertConfig(identifier string, value string) (err error) {
 	_, err = db.Conn.Exec(sqlInsertConfig, identifier, value)
 	return
 }
 
+func (db *Database) GetConfig(identifier string) (value string, err error) {
+	err = db.Conn.QueryRow(sqlGetConfig, identifier).Scan(&value)
+	return
+}
+
+func (db *Database) SetConfig(identifier string, value string) error {
+	return db.audited("set", "config", identifier, (*Database).configState, func(tx *Database) error {
+		return tx.setConfig(identifier, value)
+	})
+}
+
+func (db *Database) setConfig(identifier string, value string) (err error) {
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return
+	}
+
+	if _, err = tx.Exec(sqlDeleteConfig, identifier); err != nil {
+		tx.Rollback()
+		return
+	}
+
+	if _, err = tx.Exec(sqlInsertConfig, identifier, value); err != nil {
+		tx.Rollback()
+		return
+	}
+
+	err = tx.Commit()
+	return
+}
+
+func (db *Database) DeleteConfig(identifier string) error {
+	return db.audited("delete", "config", identifier, (*Database).configState, func(tx *Database) error {
+		return tx.deleteConfig(identifier)
+	})
+}
+
+func (db *Database) deleteConfig(identifier string) (err error) {
+	_, err = db.Conn.Exec(sqlDeleteConfig, identifier)
+	return
+}
+
 func (db *Database) FindListId(name string) (id int, err error) {
 	row := db.Conn.QueryRow(sqlFindListId, name)
 
@@ -248,6 +619,14 @@ func (db *Database) FindListId(name string) (id int, err error) {
 	return
 }
 
+func (db *Database) FindListName(id int) (name string, err error) {
+	row := db.Conn.QueryRow(sqlFindListName, id)
+
+	err = row.Scan(&name)
+
+	return
+}
+
 func (db *Database) FindUrlId(url string) (id int, err error) {
 	row := db.Conn.QueryRow(sqlFindUrlId, url)
 
@@ -279,19 +658,73 @@ func (db *Database) FetchUrls() (urls []string, err error) {
 	return
 }
 
-func (db *Database) FetchUrlsByClientId(identifier string) (urls []string, err error) {
-	info, err := db.GetClient(identifier)
+func (db *Database) FetchUrlsByClientId(identifier string) (urls []ListUrl, err error) {
+	list, err := db.GetActiveList(identifier, time.Now())
 	if err != nil {
 		return
 	}
 
-	urls, err = db.FetchListUrlsById(info.UrlListId)
-	if err == nil {
+	urls, err = db.FetchListUrlsById(list.Id)
+
+	return
+}
+
+// GetClientList returns the list a client is assigned to: its own, else its
+// group's, else the Default list. Use GetActiveList to take schedules into
+// account.
+func (db *Database) GetClientList(identifier string) (list List, err error) {
+	info, err := db.GetClient(identifier)
+	if err != nil {
 		return
 	}
 
-	urls, err = db.FetchListUrlsById(DefaultList)
+	list_id := info.UrlListId
+	if list_id == DefaultList && info.GroupId != NoGroup {
+		group, err := db.GetGroupById(info.GroupId)
+		if err != nil && err != sql.ErrNoRows {
+			return list, err
+		}
+
+		list_id = group.UrlListId
+	}
+
+	list, err = db.GetListById(list_id)
+	if err == sql.ErrNoRows {
+		list, err = db.GetListById(DefaultList)
+	}
+
+	return
+}
+
+func (db *Database) GetList(name string) (list List, err error) {
+	err = db.Conn.QueryRow(sqlGetList, name).Scan(&list.Id, &list.Name, &list.Mode)
+	return
+}
 
+func (db *Database) GetListById(id int) (list List, err error) {
+	err = db.Conn.QueryRow(sqlGetListById, id).Scan(&list.Id, &list.Name, &list.Mode)
+	return
+}
+
+func (db *Database) SetListMode(name string, mode string) error {
+	return db.audited("set mode", "list", name, (*Database).listState, func(tx *Database) error {
+		return tx.setListMode(name, mode)
+	})
+}
+
+func (db *Database) setListMode(name string, mode string) (err error) {
+	switch mode {
+	case ModeSequential, ModeShuffle, ModeStagger:
+	default:
+		return ErrInvalidMode
+	}
+
+	id, err := db.FindListId(name)
+	if err != nil {
+		return
+	}
+
+	_, err = db.Conn.Exec(sqlSetListMode, mode, id)
 	return
 }
 
@@ -318,7 +751,7 @@ func (db *Database) FetchLists() (lists []string, err error) {
 	return
 }
 
-func (db *Database) FetchListUrlsByName(name string) (urls []string, err error) {
+func (db *Database) FetchListUrlsByName(name string) (urls []ListUrl, err error) {
 	list_id, err := db.FindListId(name)
 	if err != nil {
 		return
@@ -329,7 +762,7 @@ func (db *Database) FetchListUrlsByName(name string) (urls []string, err error)
 	return
 }
 
-func (db *Database) FetchListUrlsById(id int) (urls []string, err error) {
+func (db *Database) FetchListUrlsById(id int) (urls []ListUrl, err error) {
 	rows, err := db.Conn.Query(sqlFetchListUrls, id)
 	if err != nil {
 		return
@@ -337,9 +770,9 @@ func (db *Database) FetchListUrlsById(id int) (urls []string, err error) {
 	defer rows.Close()
 
 	for rows.Next() {
-		var url string
+		var url ListUrl
 
-		err = rows.Scan(&url)
+		err = rows.Scan(&url.Url, &url.Duration)
 		if err != nil {
 			return
 		}
@@ -352,7 +785,13 @@ func (db *Database) FetchListUrlsById(id int) (urls []string, err error) {
 	return
 }
 
-func (db *Database) AssignUrlToList(name string, url string) (err error) {
+func (db *Database) AssignUrlToList(name string, url string) error {
+	return db.audited("add url", "list", name, (*Database).listState, func(tx *Database) error {
+		return tx.assignUrlToList(name, url)
+	})
+}
+
+func (db *Database) assignUrlToList(name string, url string) (err error) {
 	list_id, err := db.FindListId(name)
 	if err != nil {
 		return
@@ -363,12 +802,300 @@ func (db *Database) AssignUrlToList(name string, url string) (err error) {
 		return
 	}
 
-	_, err = db.Conn.Exec(sqlInsertListUrl, list_id, url_id)
+	_, err = db.Conn.Exec(sqlInsertListUrl, list_id, url_id, list_id)
 	return
 
 }
 
-func (db *Database) RemoveUrlFromList(name string, url string) (err error) {
+// AssignUrlToListAt inserts a URL at a 1-based position in a list, moving the
+// URLs after it down
+func (db *Database) AssignUrlToListAt(name string, url string, position int) error {
+	return db.audited("add url", "list", name, (*Database).listState, func(tx *Database) error {
+		return tx.assignUrlToListAt(name, url, position)
+	})
+}
+
+func (db *Database) assignUrlToListAt(name string, url string, position int) (err error) {
+	list_id, err := db.FindListId(name)
+	if err != nil {
+		return
+	}
+
+	url_id, err := db.FindUrlId(url)
+	if err != nil {
+		return
+	}
+
+	err = db.reorderList(list_id, func(tx *Tx, rows []listRow) (ordered []listRow, err error) {
+		res, err := tx.Exec(sqlInsertListUrl, list_id, url_id, list_id)
+		if err != nil {
+			return
+		}
+
+		id, err := res.LastInsertId()
+		if err != nil {
+			return
+		}
+
+		ordered = insertListRow(rows, listRow{int(id), url_id}, position)
+		return
+	})
+
+	return
+}
+
+// MoveListUrl moves a URL to a 1-based position in a list
+func (db *Database) MoveListUrl(name string, url string, position int) error {
+	return db.audited("move url", "list", name, (*Database).listState, func(tx *Database) error {
+		return tx.moveListUrl(name, url, position)
+	})
+}
+
+func (db *Database) moveListUrl(name string, url string, position int) (err error) {
+	list_id, err := db.FindListId(name)
+	if err != nil {
+		return
+	}
+
+	url_id, err := db.FindUrlId(url)
+	if err != nil {
+		return
+	}
+
+	err = db.reorderList(list_id, func(tx *Tx, rows []listRow) (ordered []listRow, err error) {
+		i := findListRow(rows, url_id)
+		if i < 0 {
+			return nil, sql.ErrNoRows
+		}
+
+		row := rows[i]
+		rows = append(rows[:i:i], rows[i+1:]...)
+		ordered = insertListRow(rows, row, position)
+		return
+	})
+
+	return
+}
+
+// ShiftListUrl moves a URL up (negative offset) or down (positive offset)
+// in a list
+func (db *Database) ShiftListUrl(name string, url string, offset int) error {
+	return db.audited("move url", "list", name, (*Database).listState, func(tx *Database) error {
+		return tx.shiftListUrl(name, url, offset)
+	})
+}
+
+func (db *Database) shiftListUrl(name string, url string, offset int) (err error) {
+	list_id, err := db.FindListId(name)
+	if err != nil {
+		return
+	}
+
+	url_id, err := db.FindUrlId(url)
+	if err != nil {
+		return
+	}
+
+	err = db.reorderList(list_id, func(tx *Tx, rows []listRow) (ordered []listRow, err error) {
+		i := findListRow(rows, url_id)
+		if i < 0 {
+			return nil, sql.ErrNoRows
+		}
+
+		row := rows[i]
+		rows = append(rows[:i:i], rows[i+1:]...)
+		ordered = insertListRow(rows, row, i+1+offset)
+		return
+	})
+
+	return
+}
+
+type listRow struct {
+	id     int
+	url_id int
+}
+
+func findListRow(rows []listRow, url_id int) int {
+	for i, row := range rows {
+		if row.url_id == url_id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// insertListRow inserts row at a 1-based position, clamped to the list
+func insertListRow(rows []listRow, row listRow, position int) []listRow {
+	i := position - 1
+	if i < 0 {
+		i = 0
+	}
+	if i > len(rows) {
+		i = len(rows)
+	}
+
+	ordered := make([]listRow, 0, len(rows)+1)
+	ordered = append(ordered, rows[:i]...)
+	ordered = append(ordered, row)
+	ordered = append(ordered, rows[i:]...)
+
+	return ordered
+}
+
+// reorderList lets reorder rearrange a list's rows inside a transaction, then
+// renumbers their positions from 1
+func (db *Database) reorderList(list_id int, reorder func(tx *Tx, rows []listRow) ([]listRow, error)) (err error) {
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		err = tx.Commit()
+	}()
+
+	var rows []listRow
+	result, err := tx.Query(sqlFetchListRowIds, list_id)
+	if err != nil {
+		return
+	}
+
+	for result.Next() {
+		var row listRow
+		if err = result.Scan(&row.id, &row.url_id); err != nil {
+			result.Close()
+			return
+		}
+
+		rows = append(rows, row)
+	}
+
+	result.Close()
+	if err = result.Err(); err != nil {
+		return
+	}
+
+	rows, err = reorder(tx, rows)
+	if err != nil {
+		return
+	}
+
+	for i, row := range rows {
+		if _, err = tx.Exec(sqlSetListUrlPosition, i+1, row.id); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (db *Database) IsUrlInList(name string, url string) (found bool, err error) {
+	list_id, err := db.FindListId(name)
+	if err != nil {
+		return
+	}
+
+	url_id, err := db.FindUrlId(url)
+	if err != nil {
+		return
+	}
+
+	var count int
+	err = db.Conn.QueryRow(sqlCountListUrl, list_id, url_id).Scan(&count)
+	found = count > 0
+
+	return
+}
+
+// SetListUrlDuration sets how many seconds a URL stays on screen in a list
+func (db *Database) SetListUrlDuration(name string, url string, duration int) error {
+	return db.audited("set duration", "list", name, (*Database).listState, func(tx *Database) error {
+		return tx.setListUrlDuration(name, url, duration)
+	})
+}
+
+func (db *Database) setListUrlDuration(name string, url string, duration int) (err error) {
+	if duration < 0 {
+		return ErrNegativeDuration
+	}
+
+	list_id, err := db.FindListId(name)
+	if err != nil {
+		return
+	}
+
+	url_id, err := db.FindUrlId(url)
+	if err != nil {
+		return
+	}
+
+	_, err = db.Conn.Exec(sqlSetListUrlDuration, duration, list_id, url_id)
+	return
+}
+
+// A UrlPlacement says where a URL goes in a list and for how long. Position
+// is 1-based and Offset moves it up or down from there; nil fields leave the
+// URL where it is, or add it at the end.
+type UrlPlacement struct {
+	Position *int `json:"position,omitempty"`
+	Offset   int  `json:"offset,omitempty"`
+	Duration *int `json:"duration,omitempty"`
+}
+
+// PlaceUrlInList assigns a URL to a list, or updates its assignment if it's
+// already there, in one transaction so that nothing changes if any step fails
+func (db *Database) PlaceUrlInList(name string, url string, p UrlPlacement) error {
+	return db.Transaction(func(tx *Database) (err error) {
+		found, err := tx.IsUrlInList(name, url)
+		if err != nil {
+			return
+		}
+
+		// Re-assigning with a duration or position only updates the
+		// existing assignment
+		updateOnly := found && (p.Position != nil || p.Offset != 0 || p.Duration != nil)
+
+		switch {
+		case updateOnly && p.Position != nil:
+			err = tx.MoveListUrl(name, url, *p.Position)
+		case updateOnly:
+		case p.Position != nil:
+			err = tx.AssignUrlToListAt(name, url, *p.Position)
+		default:
+			err = tx.AssignUrlToList(name, url)
+		}
+		if err != nil {
+			return
+		}
+
+		if p.Offset != 0 {
+			if err = tx.ShiftListUrl(name, url, p.Offset); err != nil {
+				return
+			}
+		}
+
+		if p.Duration != nil {
+			err = tx.SetListUrlDuration(name, url, *p.Duration)
+		}
+
+		return
+	})
+}
+
+func (db *Database) RemoveUrlFromList(name string, url string) error {
+	return db.audited("remove url", "list", name, (*Database).listState, func(tx *Database) error {
+		return tx.removeUrlFromList(name, url)
+	})
+}
+
+func (db *Database) removeUrlFromList(name string, url string) (err error) {
 	list_id, err := db.FindListId(name)
 	if err != nil {
 		return
@@ -384,16 +1111,82 @@ func (db *Database) RemoveUrlFromList(name string, url string) (err error) {
 
 }
 
+func (db *Database) QueueCommand(cmd Command) (err error) {
+	_, err = db.Conn.Exec(sqlQueueCommand, cmd.Action, cmd.Data, cmd.Client, cmd.List)
+	return
+}
+
+func (db *Database) FetchCommands() (cmds []Command, err error) {
+	rows, err := db.Conn.Query(sqlFetchCommands)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cmd Command
+
+		err = rows.Scan(&cmd.Id, &cmd.Action, &cmd.Data, &cmd.Client, &cmd.List, &cmd.Age)
+		if err != nil {
+			return
+		}
+
+		cmds = append(cmds, cmd)
+	}
+
+	err = rows.Err()
+
+	return
+}
+
+func (db *Database) DeleteCommand(id int) (err error) {
+	_, err = db.Conn.Exec(sqlDeleteCommand, id)
+	return
+}
+
+// Revision returns a counter that triggers bump whenever anything affecting
+// what the displays show changes, so the daemon can notice changes made by
+// other processes.
+func (db *Database) Revision() (revision int, err error) {
+	err = db.Conn.QueryRow(sqlGetRevision).Scan(&revision)
+	return
+}
+
+// Transaction runs fn with a Database whose queries all happen in a single
+// transaction, which is committed if fn succeeds and rolled back otherwise.
+// Transactions don't nest: inside one, fn is simply run in it.
+func (db *Database) Transaction(fn func(tx *Database) error) (err error) {
+	if db.Conn.tx != nil {
+		return fn(db)
+	}
+
+	tx, err := db.Conn.DB.Begin()
+	if err != nil {
+		return
+	}
+
+	err = fn(&Database{Conn: &Conn{DB: db.Conn.DB, Observe: db.Conn.Observe, tx: tx}, Actor: db.Actor})
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+
+	err = tx.Commit()
+	return
+}
+
 func (db *Database) CreateTables() (err error) {
-	// Run SQL to create necessary schema
-	_, err = db.Conn.Exec(sqlCreateTables)
+	// Run every migration to create the necessary schema
+	_, err = db.Migrate()
 	return
 }
 
 func Connect(database string) (db *Database, err error) {
-	c, err := sql.Open("sqlite3", database)
+	// Transactions take the write lock up front: one that read first and
+	// then tried to write could deadlock with the daemon's own writes
+	c, err := sql.Open("sqlite3", database+"?_txlock=immediate")
 	if err == nil {
-		db = &Database{c}
+		db = &Database{Conn: &Conn{DB: c}}
 	}
 
 	return
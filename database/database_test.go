@@ -1,6 +1,12 @@
 package database
 
 import (
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -63,6 +69,48 @@ func TestClient(t *testing.T) {
 	assert.Equal(client.UrlListId, updated_client.UrlListId)
 }
 
+func TestClientApproval(t *testing.T) {
+	assert := assert.New(t)
+
+	db, _ := Connect(":memory:")
+	defer db.Close()
+
+	db.CreateTables()
+
+	assert.Nil(db.InsertClient("pi-1", "10.0.0.1"))
+	assert.Nil(db.InsertPendingClient("typo", "10.0.0.2"))
+
+	client, err := db.GetClient("pi-1")
+	assert.Nil(err)
+	assert.Equal(ApprovalApproved, client.Approval)
+
+	client, err = db.GetClient("typo")
+	assert.Nil(err)
+	assert.Equal(ApprovalPending, client.Approval)
+
+	// Approving or rejecting changes what displays are shown
+	revision, _ := db.Revision()
+	assert.Nil(db.RejectClient("typo"))
+	client, _ = db.GetClient("typo")
+	assert.Equal(ApprovalRejected, client.Approval)
+	updated, _ := db.Revision()
+	assert.True(updated > revision)
+
+	assert.Nil(db.SetClientAlias("typo", "lobby"))
+	assert.Nil(db.ApproveClient("lobby"))
+	client, _ = db.GetClient("typo")
+	assert.Equal(ApprovalApproved, client.Approval)
+
+	assert.Equal(sql.ErrNoRows, db.ApproveClient("missing"))
+
+	entries, err := db.FetchAudit(AuditFilter{Type: "client", Object: "typo"})
+	assert.Nil(err)
+	assert.Equal(4, len(entries))
+	assert.Equal(`{"identifier":"typo","approval":"pending"}`, entries[0].After)
+	assert.Equal("approve", entries[3].Action)
+	assert.Equal(`{"identifier":"typo","alias":"lobby"}`, entries[3].After)
+}
+
 func TestLists(t *testing.T) {
 	assert := assert.New(t)
 
@@ -85,7 +133,7 @@ func TestLists(t *testing.T) {
 	assert.Nil(err)
 
 	err = db.InsertList("test")
-	assert.NotNil(err, "You should not be able to create a list with the same name as another list")
+	assert.Equal(ErrListExists, err, "You should not be able to create a list with the same name as another list")
 
 	list_id, err = db.FindListId("test")
 	assert.Nil(err)
@@ -94,6 +142,9 @@ func TestLists(t *testing.T) {
 	err = db.InsertUrl("http://barracudanetworks.com/")
 	assert.Nil(err)
 
+	err = db.InsertUrl("http://barracudanetworks.com/")
+	assert.Equal(ErrUrlExists, err, "You should not be able to add the same URL twice")
+
 	err = db.AssignUrlToList("test", "http://barracudanetworks.com/")
 	assert.Nil(err)
 
@@ -144,3 +195,1071 @@ func TestLists(t *testing.T) {
 	assert.Nil(err)
 	assert.Equal(DefaultList, client.UrlListId, "Client should have been removed from the test list")
 }
+
+func TestMigrations(t *testing.T) {
+	assert := assert.New(t)
+
+	db, _ := Connect(":memory:")
+	defer db.Close()
+
+	version, err := db.SchemaVersion()
+	assert.Nil(err)
+	assert.Equal(0, version, "An empty database should be at version 0")
+
+	err = db.CheckSchema()
+	assert.NotNil(err, "An empty database should need migrating")
+
+	pending, err := db.PendingMigrations()
+	assert.Nil(err)
+	assert.Equal(LatestSchemaVersion(), len(pending))
+
+	applied, err := db.Migrate()
+	assert.Nil(err)
+	assert.Equal(len(pending), len(applied))
+
+	version, err = db.SchemaVersion()
+	assert.Nil(err)
+	assert.Equal(LatestSchemaVersion(), version)
+	assert.Nil(db.CheckSchema())
+
+	pending, err = db.PendingMigrations()
+	assert.Nil(err)
+	assert.Equal(0, len(pending), "There should be nothing left to migrate")
+
+	applied, err = db.Migrate()
+	assert.Nil(err)
+	assert.Equal(0, len(applied), "Migrating twice should be a no-op")
+}
+
+func TestLegacySchemaVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	db, _ := Connect(":memory:")
+	defer db.Close()
+
+	// databases installed before migrations existed have no schema_version
+	_, err := db.Conn.Exec(sqlCreateTables)
+	assert.Nil(err)
+
+	version, err := db.SchemaVersion()
+	assert.Nil(err)
+	assert.Equal(1, version, "A pre-migration database should be at version 1")
+
+	_, err = db.Migrate()
+	assert.Nil(err)
+	assert.Nil(db.CheckSchema())
+
+	list_id, err := db.FindListId("Default")
+	assert.Nil(err)
+	assert.Equal(DefaultList, list_id, "Migrating should preserve existing data")
+}
+
+func TestUsers(t *testing.T) {
+	assert := assert.New(t)
+
+	db, _ := Connect(":memory:")
+	defer db.Close()
+
+	db.CreateTables()
+
+	found, err := db.HasUsers()
+	assert.Nil(err)
+	assert.False(found, "No users should exist on a new database")
+
+	assert.Equal(ErrInvalidRole, db.InsertUser("alice", Role("root"), "hunter2"))
+	assert.Equal(ErrEmptyPassword, db.InsertUser("alice", RoleAdmin, ""))
+
+	// The first user has to be able to manage the rest
+	assert.Equal(ErrLastAdmin, db.InsertUser("bob", RoleViewer, "swordfish"))
+	found, _ = db.HasUsers()
+	assert.False(found)
+
+	assert.Nil(db.InsertUser("alice", RoleAdmin, "hunter2"))
+	assert.Nil(db.InsertUser("bob", RoleViewer, "swordfish"))
+	assert.Equal(ErrUserExists, db.InsertUser("bob", RoleOperator, "swordfish"))
+
+	found, err = db.HasUsers()
+	assert.Nil(err)
+	assert.True(found)
+
+	user, ok, err := db.CheckUser("alice", "hunter2")
+	assert.Nil(err)
+	assert.True(ok)
+	assert.Equal(RoleAdmin, user.Role)
+
+	_, ok, err = db.CheckUser("alice", "hunter3")
+	assert.Nil(err)
+	assert.False(ok)
+
+	_, ok, err = db.CheckUser("carol", "hunter2")
+	assert.Nil(err)
+	assert.False(ok)
+
+	// Passwords are stored hashed
+	var hash string
+	db.Conn.QueryRow(sqlGetUserPassword, "alice").Scan(&hash)
+	assert.NotEqual("hunter2", hash, "The password should not be stored in plaintext")
+
+	assert.Nil(db.SetUserPassword("bob", "correct horse"))
+	_, ok, _ = db.CheckUser("bob", "correct horse")
+	assert.True(ok)
+
+	assert.Nil(db.SetUserRole("bob", RoleOperator))
+	user, _ = db.GetUser("bob")
+	assert.Equal(RoleOperator, user.Role)
+
+	// Someone has to be left to manage users
+	assert.Equal(ErrLastAdmin, db.SetUserRole("alice", RoleViewer))
+	assert.Equal(ErrLastAdmin, db.DeleteUser("alice"))
+	user, _ = db.GetUser("alice")
+	assert.Equal(RoleAdmin, user.Role)
+
+	assert.True(RoleAdmin.Allows(RoleOperator))
+	assert.True(RoleOperator.Allows(RoleOperator))
+	assert.False(RoleViewer.Allows(RoleOperator))
+	assert.False(Role("").Allows(RoleViewer))
+
+	users, err := db.FetchUsers()
+	assert.Nil(err)
+	assert.Len(users, 2)
+
+	// API tokens
+	token, err := db.CreateToken("bob", "deploy")
+	assert.Nil(err)
+	assert.True(strings.HasPrefix(token, "wbd_"))
+
+	_, err = db.CreateToken("bob", "deploy")
+	assert.Equal(ErrTokenExists, err)
+
+	user, name, ok, err := db.CheckToken(token)
+	assert.Nil(err)
+	assert.True(ok)
+	assert.Equal("bob", user.Name)
+	assert.Equal("deploy", name)
+
+	_, _, ok, err = db.CheckToken("wbd_nope")
+	assert.Nil(err)
+	assert.False(ok)
+
+	tokens, err := db.FetchTokens()
+	assert.Nil(err)
+	assert.Len(tokens, 1)
+	assert.NotEqual("", tokens[0].LastUsed)
+
+	// It's only noted again once it's been a while
+	db.Conn.Exec("UPDATE api_tokens SET last_used = datetime('now', '-30 seconds');")
+	tokens, _ = db.FetchTokens()
+	recent := tokens[0].LastUsed
+	db.CheckToken(token)
+	tokens, _ = db.FetchTokens()
+	assert.Equal(recent, tokens[0].LastUsed)
+
+	db.Conn.Exec("UPDATE api_tokens SET last_used = datetime('now', '-2 minutes');")
+	tokens, _ = db.FetchTokens()
+	old := tokens[0].LastUsed
+	db.CheckToken(token)
+	tokens, _ = db.FetchTokens()
+	assert.NotEqual(old, tokens[0].LastUsed)
+
+	assert.Nil(db.DeleteToken("bob", "deploy"))
+	_, _, ok, _ = db.CheckToken(token)
+	assert.False(ok, "A revoked token should stop working")
+
+	// Deleting a user revokes their tokens
+	token, _ = db.CreateToken("bob", "deploy")
+	assert.Nil(db.DeleteUser("bob"))
+	_, _, ok, _ = db.CheckToken(token)
+	assert.False(ok)
+
+	assert.Nil(db.DeleteUser("alice"))
+	found, _ = db.HasUsers()
+	assert.False(found)
+}
+
+func TestPasswordMigration(t *testing.T) {
+	assert := assert.New(t)
+
+	db, _ := Connect(":memory:")
+	defer db.Close()
+
+	db.Conn.Exec(sqlCreateTables)
+	db.Conn.Exec(sqlInsertConfig, "password", "hunter2")
+
+	_, err := db.Migrate()
+	assert.Nil(err)
+
+	// It ends up hashed, as the password of an admin
+	user, ok, err := db.CheckUser("admin", "hunter2")
+	assert.Nil(err)
+	assert.True(ok, "A plaintext password should be hashed when migrating")
+	assert.Equal(RoleAdmin, user.Role)
+
+	_, err = db.GetConfig("password")
+	assert.Equal(sql.ErrNoRows, err)
+}
+
+func TestListUrlDuration(t *testing.T) {
+	assert := assert.New(t)
+
+	db, _ := Connect(":memory:")
+	defer db.Close()
+
+	db.CreateTables()
+
+	db.InsertList("test")
+	db.InsertUrl("http://barracudanetworks.com/")
+
+	found, err := db.IsUrlInList("test", "http://barracudanetworks.com/")
+	assert.Nil(err)
+	assert.False(found)
+
+	err = db.AssignUrlToList("test", "http://barracudanetworks.com/")
+	assert.Nil(err)
+
+	found, err = db.IsUrlInList("test", "http://barracudanetworks.com/")
+	assert.Nil(err)
+	assert.True(found)
+
+	urls, err := db.FetchListUrlsByName("test")
+	assert.Nil(err)
+	assert.Equal([]ListUrl{{"http://barracudanetworks.com/", 0}}, urls, "New assignments should use the default duration")
+
+	err = db.SetListUrlDuration("test", "http://barracudanetworks.com/", 120)
+	assert.Nil(err)
+
+	urls, err = db.FetchListUrlsByName("test")
+	assert.Nil(err)
+	assert.Equal(120, urls[0].Duration)
+
+	err = db.SetListUrlDuration("test", "http://barracudanetworks.com/", -1)
+	assert.Equal(ErrNegativeDuration, err)
+}
+
+func TestListUrlOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	db, _ := Connect(":memory:")
+	defer db.Close()
+
+	db.CreateTables()
+	db.InsertList("test")
+
+	listOrder := func(name string) (urls []string) {
+		list, err := db.FetchListUrlsByName(name)
+		assert.Nil(err)
+
+		for _, url := range list {
+			urls = append(urls, url.Url)
+		}
+
+		return
+	}
+	order := func() []string {
+		return listOrder("test")
+	}
+
+	for _, url := range []string{"a", "b", "c"} {
+		db.InsertUrl(url)
+		assert.Nil(db.AssignUrlToList("test", url))
+	}
+	assert.Equal([]string{"a", "b", "c"}, order(), "URLs should be appended in order")
+
+	// removing and re-adding a URL should put it at the end
+	assert.Nil(db.RemoveUrlFromList("test", "a"))
+	assert.Nil(db.AssignUrlToList("test", "a"))
+	assert.Equal([]string{"b", "c", "a"}, order())
+
+	db.InsertUrl("d")
+	assert.Nil(db.AssignUrlToListAt("test", "d", 1))
+	assert.Equal([]string{"d", "b", "c", "a"}, order())
+
+	assert.Nil(db.MoveListUrl("test", "a", 2))
+	assert.Equal([]string{"d", "a", "b", "c"}, order())
+
+	assert.Nil(db.MoveListUrl("test", "d", 100))
+	assert.Equal([]string{"a", "b", "c", "d"}, order(), "Positions past the end should be clamped")
+
+	assert.Nil(db.ShiftListUrl("test", "c", -1))
+	assert.Equal([]string{"a", "c", "b", "d"}, order())
+
+	assert.Nil(db.ShiftListUrl("test", "a", -1))
+	assert.Equal([]string{"a", "c", "b", "d"}, order(), "Moving the first URL up should do nothing")
+
+	assert.Nil(db.ShiftListUrl("test", "d", 1))
+	assert.Equal([]string{"a", "c", "b", "d"}, order(), "Moving the last URL down should do nothing")
+
+	db.InsertUrl("e")
+	assert.Equal(sql.ErrNoRows, db.MoveListUrl("test", "e", 1), "Only URLs in the list can be moved")
+
+	// Deleting the list moves its URLs to the end of the Default list
+	assert.Nil(db.AssignUrlToList("Default", "e"))
+	assert.Nil(db.DeleteList("test"))
+	assert.Equal([]string{"e", "a", "c", "b", "d"}, listOrder("Default"))
+
+	assert.Nil(db.MoveListUrl("Default", "d", 2))
+	assert.Equal([]string{"e", "d", "a", "c", "b"}, listOrder("Default"))
+}
+
+func TestPlaceUrlInList(t *testing.T) {
+	assert := assert.New(t)
+
+	db, _ := Connect(":memory:")
+	defer db.Close()
+
+	db.CreateTables()
+	db.InsertList("test")
+	for _, url := range []string{"a", "b", "c"} {
+		db.InsertUrl(url)
+	}
+	assert.Nil(db.AssignUrlToList("test", "a"))
+	assert.Nil(db.AssignUrlToList("test", "b"))
+
+	first, duration := 1, 30
+	assert.Nil(db.PlaceUrlInList("test", "c", UrlPlacement{Position: &first, Duration: &duration}))
+	urls, _ := db.FetchListUrlsByName("test")
+	assert.Equal([]ListUrl{{"c", 30}, {"a", 0}, {"b", 0}}, urls)
+
+	// Already assigned, so it's moved rather than added again
+	assert.Nil(db.PlaceUrlInList("test", "c", UrlPlacement{Offset: 1}))
+	urls, _ = db.FetchListUrlsByName("test")
+	assert.Equal([]ListUrl{{"a", 0}, {"c", 30}, {"b", 0}}, urls)
+
+	// A step that fails undoes the ones before it
+	entries, _ := db.FetchAudit(AuditFilter{})
+	db.RemoveUrlFromList("test", "c")
+	negative := -1
+	assert.Equal(ErrNegativeDuration, db.PlaceUrlInList("test", "c", UrlPlacement{Position: &first, Duration: &negative}))
+	urls, _ = db.FetchListUrlsByName("test")
+	assert.Equal([]ListUrl{{"a", 0}, {"b", 0}}, urls)
+	after, _ := db.FetchAudit(AuditFilter{})
+	assert.Equal(len(entries)+1, len(after), "Only the removal should be recorded")
+}
+
+func TestListMode(t *testing.T) {
+	assert := assert.New(t)
+
+	db, _ := Connect(":memory:")
+	defer db.Close()
+
+	db.CreateTables()
+	db.InsertList("test")
+
+	list, err := db.GetList("test")
+	assert.Nil(err)
+	assert.Equal(ModeSequential, list.Mode, "Lists should rotate sequentially by default")
+
+	err = db.SetListMode("test", ModeStagger)
+	assert.Nil(err)
+
+	list, err = db.GetListById(list.Id)
+	assert.Nil(err)
+	assert.Equal(ModeStagger, list.Mode)
+
+	err = db.SetListMode("test", "sideways")
+	assert.Equal(ErrInvalidMode, err)
+
+	err = db.SetListMode("NONEXISTENT", ModeShuffle)
+	assert.Equal(sql.ErrNoRows, err)
+
+	db.InsertClient("client", "0.0.0.0")
+
+	list, err = db.GetClientList("client")
+	assert.Nil(err)
+	assert.Equal(DefaultList, list.Id)
+
+	db.AssignClientToList("test", "client")
+
+	list, err = db.GetClientList("client")
+	assert.Nil(err)
+	assert.Equal("test", list.Name)
+	assert.Equal(ModeStagger, list.Mode)
+}
+
+func TestCommands(t *testing.T) {
+	assert := assert.New(t)
+
+	db, _ := Connect(":memory:")
+	defer db.Close()
+
+	db.CreateTables()
+
+	err := db.QueueCommand(Command{Action: "flashUrl", Data: `{"url":"http://barracudanetworks.com/"}`, List: "test"})
+	assert.Nil(err)
+
+	err = db.QueueCommand(Command{Action: "flashUrl", Client: "client"})
+	assert.Nil(err)
+
+	cmds, err := db.FetchCommands()
+	assert.Nil(err)
+	assert.Equal(2, len(cmds))
+	assert.Equal("flashUrl", cmds[0].Action)
+	assert.Equal("test", cmds[0].List)
+	assert.Equal("client", cmds[1].Client)
+	assert.True(cmds[0].Age < 5, "Commands should know how long ago they were queued")
+
+	err = db.DeleteCommand(cmds[0].Id)
+	assert.Nil(err)
+
+	cmds, err = db.FetchCommands()
+	assert.Nil(err)
+	assert.Equal(1, len(cmds), "Deleted commands should not be fetched again")
+}
+
+func TestRevision(t *testing.T) {
+	assert := assert.New(t)
+
+	db, _ := Connect(":memory:")
+	defer db.Close()
+
+	db.CreateTables()
+
+	last, err := db.Revision()
+	assert.Nil(err)
+
+	// changed reports whether the revision moved since it was last called
+	changed := func() bool {
+		revision, err := db.Revision()
+		assert.Nil(err)
+
+		moved := revision != last
+		last = revision
+		return moved
+	}
+
+	db.InsertClient("test", "127.0.0.1")
+	db.TouchClient("test")
+	db.SetClientAlias("test", "alias")
+	assert.False(changed(), "Client activity shouldn't bump the revision")
+
+	db.InsertList("test")
+	assert.True(changed())
+
+	db.InsertUrl("http://barracudanetworks.com/")
+	db.AssignUrlToList("test", "http://barracudanetworks.com/")
+	assert.True(changed())
+
+	db.SetListUrlDuration("test", "http://barracudanetworks.com/", 30)
+	assert.True(changed())
+
+	db.SetListMode("test", ModeShuffle)
+	assert.True(changed())
+
+	db.AssignClientToList("test", "test")
+	assert.True(changed())
+
+	db.DeleteUrl("http://barracudanetworks.com/")
+	assert.True(changed())
+
+	db.DeleteClient("test")
+	assert.True(changed())
+}
+
+func TestParseDays(t *testing.T) {
+	assert := assert.New(t)
+
+	for spec, expected := range map[string]string{
+		"":             "",
+		"daily":        "",
+		"mon-sun":      "",
+		"weekdays":     "mon,tue,wed,thu,fri",
+		"sun,Saturday": "sat,sun",
+		"fri-mon":      "mon,fri,sat,sun",
+		"tue, thu":     "tue,thu",
+	} {
+		days, err := ParseDays(spec)
+		assert.Nil(err, spec)
+		assert.Equal(expected, days, spec)
+	}
+
+	for _, spec := range []string{"mo", "funday", "mon-", "mon,,tue"} {
+		_, err := ParseDays(spec)
+		assert.Equal(ErrInvalidDays, err, spec)
+	}
+}
+
+func TestScheduleActive(t *testing.T) {
+	assert := assert.New(t)
+
+	// A Wednesday
+	at := func(clock string) time.Time {
+		t, _ := time.ParseInLocation("2006-01-02 15:04", "2025-06-04 "+clock, time.UTC)
+		return t
+	}
+
+	office := Schedule{Days: "mon,tue,wed,thu,fri", Start: "09:00", End: "17:00", Timezone: "UTC"}
+	assert.False(office.Active(at("08:59")))
+	assert.True(office.Active(at("09:00")))
+	assert.True(office.Active(at("16:59")))
+	assert.False(office.Active(at("17:00")))
+	assert.False(office.Active(at("12:00").AddDate(0, 0, 3)), "Saturday")
+
+	// Runs overnight, counted against the day it starts
+	night := Schedule{Days: "tue", Start: "22:00", End: "06:00", Timezone: "UTC"}
+	assert.True(night.Active(at("05:59")))
+	assert.False(night.Active(at("06:00")))
+	assert.False(night.Active(at("22:00")))
+	assert.True(night.Active(at("22:00").AddDate(0, 0, -1)))
+
+	allDay := Schedule{Start: "00:00", End: "00:00", Timezone: "UTC"}
+	assert.True(allDay.Active(at("13:37")))
+
+	// 09:00 in New York is 13:00 UTC in June
+	newYork := Schedule{Start: "09:00", End: "10:00", Timezone: "America/New_York"}
+	assert.False(newYork.Active(at("09:30")))
+	assert.True(newYork.Active(at("13:30")))
+}
+
+func TestSchedules(t *testing.T) {
+	assert := assert.New(t)
+
+	db, _ := Connect(":memory:")
+	defer db.Close()
+
+	db.CreateTables()
+
+	db.InsertClient("test", "127.0.0.1")
+	db.InsertClient("other", "127.0.0.1")
+	db.InsertList("lobby")
+	db.InsertList("night")
+	db.InsertList("party")
+	db.AssignClientToList("lobby", "test")
+	db.AssignClientToList("lobby", "other")
+
+	err := db.InsertSchedule(Schedule{Show: "night", Start: "00:00", End: "00:00"})
+	assert.Equal(ErrScheduleTarget, err)
+
+	err = db.InsertSchedule(Schedule{List: "lobby", Show: "night", Start: "9am", End: "00:00"})
+	assert.Equal(ErrInvalidTime, err)
+
+	err = db.InsertSchedule(Schedule{List: "lobby", Show: "night", Start: "00:00", End: "00:00", Timezone: "Nowhere/Special"})
+	assert.Equal(ErrInvalidTimezone, err)
+
+	err = db.InsertSchedule(Schedule{List: "lobby", Show: "missing", Start: "00:00", End: "00:00"})
+	assert.Equal(sql.ErrNoRows, err)
+
+	now := time.Now()
+
+	// Nothing scheduled yet
+	list, err := db.GetActiveList("test", now)
+	assert.Nil(err)
+	assert.Equal("lobby", list.Name)
+
+	assert.Nil(db.InsertSchedule(Schedule{List: "lobby", Show: "night", Start: "00:00", End: "00:00"}))
+	assert.Nil(db.InsertSchedule(Schedule{Client: "test", Show: "party", Start: "00:00", End: "00:00"}))
+
+	schedules, err := db.FetchSchedules()
+	assert.Nil(err)
+	assert.Equal(2, len(schedules))
+	assert.Equal("lobby", schedules[0].List)
+	assert.Equal("night", schedules[0].Show)
+	assert.Equal("test", schedules[1].Client)
+
+	// Client schedules win over list schedules
+	list, _ = db.GetActiveList("test", now)
+	assert.Equal("party", list.Name)
+	list, _ = db.GetActiveList("other", now)
+	assert.Equal("night", list.Name)
+
+	// The active schedules can be worked out once for several clients
+	active, err := db.ActiveSchedules(now)
+	assert.Nil(err)
+	list, _ = db.GetScheduledList("test", active)
+	assert.Equal("party", list.Name)
+	list, _ = db.GetScheduledList("other", nil)
+	assert.Equal("lobby", list.Name)
+
+	// The assigned list is unchanged
+	list, _ = db.GetClientList("other")
+	assert.Equal("lobby", list.Name)
+
+	assert.Nil(db.DeleteSchedule(schedules[1].Id))
+	assert.Equal(sql.ErrNoRows, db.DeleteSchedule(schedules[1].Id))
+	list, _ = db.GetActiveList("test", now)
+	assert.Equal("night", list.Name)
+
+	// Deleting a list removes its schedules
+	db.DeleteList("night")
+	schedules, _ = db.FetchSchedules()
+	assert.Equal(0, len(schedules))
+}
+
+func TestGroups(t *testing.T) {
+	assert := assert.New(t)
+
+	db, _ := Connect(":memory:")
+	defer db.Close()
+
+	db.CreateTables()
+
+	db.InsertClient("test", "127.0.0.1")
+	db.InsertClient("other", "127.0.0.1")
+	db.InsertList("lobby")
+	db.InsertList("kitchen")
+
+	assert.Nil(db.InsertGroup("pis"))
+	assert.Equal(ErrGroupExists, db.InsertGroup("pis"))
+
+	assert.Nil(db.AddClientToGroup("pis", "test"))
+	assert.Nil(db.AddClientToGroup("pis", "other"))
+	assert.Equal(sql.ErrNoRows, db.AddClientToGroup("pis", "missing"))
+	assert.Equal(sql.ErrNoRows, db.AddClientToGroup("missing", "test"))
+
+	members, err := db.FetchGroupMembers("pis")
+	assert.Nil(err)
+	assert.Equal(2, len(members))
+
+	// A group without a list leaves its members on the Default list
+	list, err := db.GetClientList("test")
+	assert.Nil(err)
+	assert.Equal(DefaultList, list.Id)
+
+	assert.Nil(db.AssignGroupToList("lobby", "pis"))
+	list, _ = db.GetClientList("test")
+	assert.Equal("lobby", list.Name)
+	list, _ = db.GetClientList("other")
+	assert.Equal("lobby", list.Name)
+
+	// A client's own list overrides its group's
+	db.AssignClientToList("kitchen", "other")
+	list, _ = db.GetClientList("other")
+	assert.Equal("kitchen", list.Name)
+
+	db.RemoveClientFromList("other")
+	list, _ = db.GetClientList("other")
+	assert.Equal("lobby", list.Name)
+
+	assert.Nil(db.RemoveClientFromGroup("other"))
+	list, _ = db.GetClientList("other")
+	assert.Equal(DefaultList, list.Id)
+
+	// Deleting the group's list sends its members back to Default
+	db.DeleteList("lobby")
+	group, err := db.GetGroup("pis")
+	assert.Nil(err)
+	assert.Equal(DefaultList, group.UrlListId)
+
+	assert.Nil(db.DeleteGroup("pis"))
+	client, _ := db.GetClient("test")
+	assert.Equal(NoGroup, client.GroupId)
+
+	groups, err := db.FetchGroups()
+	assert.Nil(err)
+	assert.Equal(0, len(groups))
+}
+
+func TestClientStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	db, _ := Connect(":memory:")
+	defer db.Close()
+
+	db.CreateTables()
+	db.InsertClient("test", "127.0.0.1")
+
+	_, err := db.GetClientStatus("test")
+	assert.Equal(sql.ErrNoRows, err)
+
+	status := ClientStatus{
+		Url:      "http://barracudanetworks.com/",
+		Position: 2,
+		Count:    3,
+		Paused:   true,
+		Loaded:   true,
+		Uptime:   60,
+	}
+	assert.Nil(db.SetClientStatus("test", status))
+
+	saved, err := db.GetClientStatus("test")
+	assert.Nil(err)
+	assert.NotEqual("", saved.Updated)
+
+	saved.Updated = ""
+	assert.Equal(status, saved)
+
+	// Only the latest status is kept
+	status.Error = "Timed out loading http://barracudanetworks.com/"
+	assert.Nil(db.SetClientStatus("test", status))
+	saved, _ = db.GetClientStatus("test")
+	assert.Equal(status.Error, saved.Error)
+
+	db.DeleteClient("test")
+	_, err = db.GetClientStatus("test")
+	assert.Equal(sql.ErrNoRows, err)
+}
+
+func TestObserveQueries(t *testing.T) {
+	assert := assert.New(t)
+
+	db, _ := Connect(":memory:")
+	defer db.Close()
+
+	db.CreateTables()
+
+	var operations []string
+	db.Conn.Observe = func(operation string, duration time.Duration) {
+		operations = append(operations, operation)
+	}
+
+	// Checks the URL isn't already there, then adds it, looking it up on
+	// either side of each change to record it in the audit log
+	db.InsertUrl("http://barracudanetworks.com/")
+	db.DeleteUrl("http://barracudanetworks.com/")
+
+	assert.Equal([]string{
+		"SELECT", "SELECT", "INSERT", "SELECT", "INSERT",
+		"SELECT", "DELETE", "SELECT", "INSERT",
+	}, operations)
+}
+
+func TestTransaction(t *testing.T) {
+	assert := assert.New(t)
+
+	db, _ := Connect(":memory:")
+	defer db.Close()
+
+	db.CreateTables()
+
+	// Methods with transactions of their own join the outer one
+	err := db.Transaction(func(tx *Database) error {
+		assert.Nil(tx.InsertList("Lobby"))
+		assert.Nil(tx.SetConfig("motd", "hello"))
+
+		return ErrListExists
+	})
+	assert.Equal(ErrListExists, err)
+
+	_, err = db.FindListId("Lobby")
+	assert.Equal(sql.ErrNoRows, err)
+	_, err = db.GetConfig("motd")
+	assert.Equal(sql.ErrNoRows, err)
+
+	err = db.Transaction(func(tx *Database) error {
+		return tx.SetConfig("motd", "hello")
+	})
+	assert.Nil(err)
+
+	value, err := db.GetConfig("motd")
+	assert.Nil(err)
+	assert.Equal("hello", value)
+}
+
+func TestExportImport(t *testing.T) {
+	assert := assert.New(t)
+
+	db, _ := Connect(":memory:")
+	defer db.Close()
+
+	db.CreateTables()
+
+	db.InsertUrl("http://a.example.com/")
+	db.InsertUrl("http://b.example.com/")
+	db.InsertUrl("http://unused.example.com/")
+	db.InsertList("Lobby")
+	db.SetListMode("Lobby", ModeShuffle)
+	db.AssignUrlToList("Lobby", "http://b.example.com/")
+	db.AssignUrlToList("Lobby", "http://a.example.com/")
+	db.SetListUrlDuration("Lobby", "http://a.example.com/", 30)
+	db.InsertGroup("Downstairs")
+	db.AssignGroupToList("Lobby", "Downstairs")
+	db.InsertClient("pi-1", "10.0.0.1")
+	db.SetClientAlias("pi-1", "lobby-tv")
+	db.AddClientToGroup("Downstairs", "pi-1")
+	db.InsertClient("pi-2", "10.0.0.2")
+	db.AssignClientToList("Lobby", "pi-2")
+	db.InsertPendingClient("pi-3", "10.0.0.3")
+	db.InsertClient("pi-4", "10.0.0.4")
+	db.RejectClient("pi-4")
+	db.InsertSchedule(Schedule{List: "Lobby", Show: "Default", Days: "sat,sun", Start: "00:00", End: "00:00"})
+	db.SetConfig("motd", "hello")
+	db.SetConfig("password", "hash")
+
+	snapshot, err := db.Export()
+	assert.Nil(err)
+	assert.Len(snapshot.Urls, 3)
+	assert.Equal([]ListUrl{{"http://b.example.com/", 0}, {"http://a.example.com/", 30}}, snapshot.Lists[1].Urls)
+	assert.Equal(SnapshotClient{"pi-1", "lobby-tv", "", "Downstairs", ""}, snapshot.Clients[0])
+	assert.Equal(ApprovalPending, snapshot.Clients[2].Approval)
+	assert.Equal(ApprovalRejected, snapshot.Clients[3].Approval)
+	assert.Equal(map[string]string{"motd": "hello"}, snapshot.Config)
+
+	// Loading it into a new database gives the same setup back
+	other, _ := Connect(":memory:")
+	defer other.Close()
+
+	other.CreateTables()
+	other.InsertList("Old")
+	other.SetConfig("password", "other hash")
+
+	assert.Nil(other.Import(snapshot, false))
+	copied, err := other.Export()
+	assert.Nil(err)
+	assert.Len(copied.Lists, 3)
+
+	assert.Nil(other.Import(snapshot, true))
+	copied, err = other.Export()
+	assert.Nil(err)
+	assert.Equal(snapshot, copied)
+
+	// Secrets are neither exported nor replaced
+	value, err := other.GetConfig("password")
+	assert.Nil(err)
+	assert.Equal("other hash", value)
+
+	// Importing over approved clients puts them back as they were
+	other.ApproveClient("pi-3")
+	other.ApproveClient("pi-4")
+	assert.Nil(other.Import(snapshot, false))
+	client, _ := other.GetClient("pi-3")
+	assert.Equal(ApprovalPending, client.Approval)
+	client, _ = other.GetClient("pi-4")
+	assert.Equal(ApprovalRejected, client.Approval)
+
+	// Importing again changes nothing
+	assert.Nil(other.Import(snapshot, false))
+	copied, _ = other.Export()
+	assert.Equal(snapshot, copied)
+
+	// A bad snapshot leaves everything as it was
+	snapshot.Lists[1].Urls = append(snapshot.Lists[1].Urls, ListUrl{"http://c.example.com/", 0})
+	snapshot.Clients = append(snapshot.Clients, SnapshotClient{Identifier: "pi-5", List: "Missing"})
+	assert.NotNil(other.Import(snapshot, true))
+
+	_, err = other.FindUrlId("http://c.example.com/")
+	assert.Equal(sql.ErrNoRows, err)
+	urls, _ := other.FetchListUrlsByName("Lobby")
+	assert.Len(urls, 2)
+}
+
+func TestApply(t *testing.T) {
+	assert := assert.New(t)
+
+	db, _ := Connect(":memory:")
+	defer db.Close()
+
+	db.CreateTables()
+
+	db.InsertUrl("http://a.example.com/")
+	db.InsertUrl("http://old.example.com/")
+	db.InsertList("Old")
+	db.AssignUrlToList("Old", "http://old.example.com/")
+	db.InsertClient("pi-1", "10.0.0.1")
+	db.InsertClient("pi-2", "10.0.0.2")
+	db.AssignClientToList("Old", "pi-2")
+
+	manifest := Snapshot{
+		Lists: []SnapshotList{
+			{Name: "Lobby", Mode: ModeShuffle, Urls: []ListUrl{{"http://b.example.com/", 30}, {"http://a.example.com/", 0}}},
+		},
+		Clients: []SnapshotClient{
+			{Identifier: "pi-1", Alias: "lobby-tv", List: "Lobby"},
+		},
+	}
+
+	// A dry run changes nothing
+	changes, err := db.Apply(manifest, false, true)
+	assert.Nil(err)
+	assert.Equal([]string{
+		"+ url http://b.example.com/",
+		"+ list Lobby",
+		"~ list Lobby mode sequential -> shuffle",
+		"~ list Lobby urls [] -> [http://b.example.com/ (30s), http://a.example.com/]",
+		"~ client pi-1 alias \"\" -> \"lobby-tv\"",
+		"~ client pi-1 list (group) -> Lobby",
+	}, describeChanges(changes))
+
+	_, err = db.FindListId("Lobby")
+	assert.Equal(sql.ErrNoRows, err)
+
+	changes, err = db.Apply(manifest, false, false)
+	assert.Nil(err)
+	assert.Len(changes, 6)
+
+	urls, _ := db.FetchListUrlsByName("Lobby")
+	assert.Equal(manifest.Lists[0].Urls, urls)
+	list, _ := db.GetClientList("lobby-tv")
+	assert.Equal("Lobby", list.Name)
+
+	// Once applied, there's nothing left to do
+	changes, err = db.Apply(manifest, false, false)
+	assert.Nil(err)
+	assert.Len(changes, 0)
+
+	changes, err = db.Apply(manifest, true, false)
+	assert.Nil(err)
+	assert.Equal([]string{
+		"~ client pi-2 list Old -> (group)",
+		"- list Old",
+		"- url http://old.example.com/",
+	}, describeChanges(changes))
+
+	lists, _ := db.FetchLists()
+	assert.Equal([]string{"Default", "Lobby"}, lists)
+	defaultUrls, _ := db.FetchListUrlsById(DefaultList)
+	assert.Len(defaultUrls, 0)
+
+	// Clients keep the approval the manifest gives them
+	manifest.Clients = append(manifest.Clients, SnapshotClient{Identifier: "pi-3", Approval: ApprovalPending})
+	changes, err = db.Apply(manifest, false, false)
+	assert.Nil(err)
+	assert.Equal([]string{"+ client pi-3"}, describeChanges(changes))
+	client, _ := db.GetClient("pi-3")
+	assert.Equal(ApprovalPending, client.Approval)
+
+	manifest.Clients[1].Approval = ApprovalRejected
+	changes, err = db.Apply(manifest, false, false)
+	assert.Nil(err)
+	assert.Equal([]string{"~ client pi-3 approval pending -> rejected"}, describeChanges(changes))
+
+	manifest.Clients[1].Approval = "maybe"
+	_, err = db.Apply(manifest, false, false)
+	assert.NotNil(err)
+	manifest.Clients[1].Approval = ""
+
+	// Manifests referring to lists that won't exist are refused
+	manifest.Clients[0].List = "Missing"
+	_, err = db.Apply(manifest, false, false)
+	assert.NotNil(err)
+}
+
+func describeChanges(changes []Change) (descriptions []string) {
+	for _, change := range changes {
+		descriptions = append(descriptions, change.Description)
+	}
+
+	return
+}
+
+func TestBackup(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "wbd")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wbd.db")
+	db, _ := Connect(path)
+	defer db.Close()
+
+	db.CreateTables()
+	db.InsertList("Lobby")
+
+	start := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	backup := BackupPath("", path, start)
+	assert.Equal(filepath.Join(dir, "wbd-20260102-150405.db"), backup)
+
+	assert.Nil(db.Backup(backup))
+	assert.Equal(ErrBackupExists, db.Backup(backup))
+
+	for i := 1; i <= 3; i++ {
+		assert.Nil(db.Backup(BackupPath("", path, start.Add(time.Duration(i)*time.Hour))))
+	}
+
+	backups, err := Backups("", path)
+	assert.Nil(err)
+	assert.Len(backups, 4)
+	assert.Equal(backup, backups[0])
+
+	removed, err := PruneBackups("", path, 2)
+	assert.Nil(err)
+	assert.Equal(backups[:2], removed)
+
+	backups, _ = Backups("", path)
+	assert.Len(backups, 2)
+
+	// Restoring brings back the database as it was backed up
+	db.DeleteList("Lobby")
+	db.Close()
+
+	assert.Nil(Restore(backups[0], path))
+
+	db, _ = Connect(path)
+	_, err = db.FindListId("Lobby")
+	assert.Nil(err)
+
+	assert.NotNil(Restore(filepath.Join(dir, "missing.db"), path))
+
+	ioutil.WriteFile(filepath.Join(dir, "junk.db"), []byte("not a database"), 0600)
+	assert.Equal(ErrNotDatabase, Restore(filepath.Join(dir, "junk.db"), path))
+}
+
+func TestAudit(t *testing.T) {
+	assert := assert.New(t)
+
+	db, _ := Connect(":memory:")
+	defer db.Close()
+
+	db.CreateTables()
+
+	alice := db.As(Actor{Name: "alice", Source: SourceCli})
+	bob := db.As(Actor{Name: "bob", Source: SourceApi, Address: "10.0.0.2"})
+
+	assert.Nil(alice.InsertList("Lobby"))
+	assert.Nil(alice.InsertUrl("http://barracudanetworks.com/"))
+	assert.Nil(bob.AssignUrlToList("Lobby", "http://barracudanetworks.com/"))
+	assert.Nil(db.InsertClient("pi-1", "10.0.0.3"))
+	assert.Nil(bob.SetClientAlias("pi-1", "lobby-screen"))
+	assert.Nil(bob.AssignClientToList("Lobby", "lobby-screen"))
+	assert.Nil(alice.SetConfig("password", "hunter2"))
+
+	// Failed and no-op changes aren't recorded
+	assert.Equal(ErrListExists, alice.InsertList("Lobby"))
+	assert.Nil(alice.DeleteUrl("http://example.com/"))
+	assert.Nil(alice.SetClientAlias("pi-1", "lobby-screen"))
+
+	entries, err := db.FetchAudit(AuditFilter{})
+	assert.Nil(err)
+	assert.Len(entries, 7)
+
+	assert.Equal(Actor{"alice", SourceCli, ""}, entries[0].Actor)
+	assert.Equal("insert", entries[0].Action)
+	assert.Equal("list", entries[0].Type)
+	assert.Equal("Lobby", entries[0].Object)
+	assert.Equal("", entries[0].Before)
+	assert.Equal(`{"name":"Lobby","mode":"sequential","urls":[]}`, entries[0].After)
+
+	assert.Equal(Actor{"bob", SourceApi, "10.0.0.2"}, entries[2].Actor)
+	assert.Equal("add url", entries[2].Action)
+	assert.Equal(`{"name":"Lobby","mode":"sequential","urls":[{"url":"http://barracudanetworks.com/","duration":0}]}`, entries[2].After)
+
+	// Clients are recorded by identifier even when given by alias
+	assert.Equal("assign", entries[5].Action)
+	assert.Equal("pi-1", entries[5].Object)
+	assert.Equal(`{"identifier":"pi-1","alias":"lobby-screen","list":"Lobby"}`, entries[5].After)
+
+	// Secrets are never recorded
+	assert.Equal("config", entries[6].Type)
+	assert.Equal("password", entries[6].Object)
+	assert.Equal("(hidden)", entries[6].After)
+
+	entries, err = db.FetchAudit(AuditFilter{Type: "client", Object: "pi-1"})
+	assert.Nil(err)
+	assert.Len(entries, 3)
+
+	entries, err = db.FetchAudit(AuditFilter{Actor: "bob", Limit: 1})
+	assert.Nil(err)
+	assert.Len(entries, 1)
+	assert.Equal("assign", entries[0].Action)
+
+	entries, err = db.FetchAudit(AuditFilter{Until: time.Now().Add(-time.Hour)})
+	assert.Nil(err)
+	assert.Len(entries, 0)
+
+	entries, err = db.FetchAudit(AuditFilter{Since: time.Now().Add(-time.Hour)})
+	assert.Nil(err)
+	assert.Len(entries, 7)
+
+	// Changes rolled back with their transaction leave no entry
+	db.Transaction(func(tx *Database) error {
+		tx.InsertList("Kitchen")
+		return errors.New("Changed my mind")
+	})
+
+	entries, _ = db.FetchAudit(AuditFilter{Object: "Kitchen"})
+	assert.Len(entries, 0)
+
+	// The log can only be appended to
+	_, err = db.Conn.Exec("UPDATE audit SET actor = 'mallory';")
+	assert.NotNil(err)
+	_, err = db.Conn.Exec("DELETE FROM audit;")
+	assert.NotNil(err)
+}
@@ -0,0 +1,197 @@
+package database
+
+import (
+	"database/sql"
+)
+
+const (
+	// client_groups table
+	sqlFindGroupId        string = "SELECT id FROM client_groups WHERE name = ?;"
+	sqlGetGroupById       string = "SELECT id, name, url_list_id FROM client_groups WHERE id = ?;"
+	sqlInsertGroup        string = "INSERT INTO client_groups(name) VALUES(?);"
+	sqlFetchGroups        string = "SELECT id, name, url_list_id FROM client_groups ORDER BY name;"
+	sqlDeleteGroup        string = "DELETE FROM client_groups WHERE id = ?;"
+	sqlSetGroupList       string = "UPDATE client_groups SET url_list_id = ? WHERE id = ?;"
+	sqlCleanOrphanMembers string = "UPDATE clients SET group_id = 0 WHERE group_id = ?;"
+	sqlCleanOrphanGroups  string = "UPDATE client_groups SET url_list_id = 0 WHERE url_list_id = ?;"
+)
+
+func (db *Database) FindGroupId(name string) (id int, err error) {
+	err = db.Conn.QueryRow(sqlFindGroupId, name).Scan(&id)
+	return
+}
+
+func (db *Database) GetGroupById(id int) (group Group, err error) {
+	err = db.Conn.QueryRow(sqlGetGroupById, id).Scan(&group.Id, &group.Name, &group.UrlListId)
+	return
+}
+
+func (db *Database) GetGroup(name string) (group Group, err error) {
+	id, err := db.FindGroupId(name)
+	if err != nil {
+		return
+	}
+
+	return db.GetGroupById(id)
+}
+
+func (db *Database) InsertGroup(name string) error {
+	return db.audited("insert", "group", name, (*Database).groupState, func(tx *Database) error {
+		return tx.insertGroup(name)
+	})
+}
+
+func (db *Database) insertGroup(name string) (err error) {
+	_, err = db.FindGroupId(name)
+	if err != nil && err != sql.ErrNoRows {
+		return
+	}
+
+	if err == nil {
+		return ErrGroupExists
+	}
+
+	_, err = db.Conn.Exec(sqlInsertGroup, name)
+	return
+}
+
+// DeleteGroup removes a group, leaving its members without one
+func (db *Database) DeleteGroup(name string) error {
+	return db.audited("delete", "group", name, (*Database).groupState, func(tx *Database) error {
+		return tx.deleteGroup(name)
+	})
+}
+
+func (db *Database) deleteGroup(name string) (err error) {
+	id, err := db.FindGroupId(name)
+	if err != nil {
+		return
+	}
+
+	_, err = db.Conn.Exec(sqlCleanOrphanMembers, id)
+	if err != nil {
+		return
+	}
+
+	_, err = db.Conn.Exec(sqlDeleteGroup, id)
+	return
+}
+
+func (db *Database) FetchGroups() (groups []Group, err error) {
+	rows, err := db.Conn.Query(sqlFetchGroups)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var group Group
+
+		err = rows.Scan(&group.Id, &group.Name, &group.UrlListId)
+		if err != nil {
+			return
+		}
+
+		groups = append(groups, group)
+	}
+
+	err = rows.Err()
+
+	return
+}
+
+// AssignGroupToList makes every member of a group without a list of its own
+// show the named list
+func (db *Database) AssignGroupToList(list string, group string) error {
+	return db.audited("assign", "group", group, (*Database).groupState, func(tx *Database) error {
+		return tx.assignGroupToList(list, group)
+	})
+}
+
+func (db *Database) assignGroupToList(list string, group string) (err error) {
+	list_id, err := db.FindListId(list)
+	if err != nil {
+		return
+	}
+
+	group_id, err := db.FindGroupId(group)
+	if err != nil {
+		return
+	}
+
+	_, err = db.Conn.Exec(sqlSetGroupList, list_id, group_id)
+	return
+}
+
+// RemoveGroupFromList leaves a group's members showing the Default list
+func (db *Database) RemoveGroupFromList(group string) error {
+	return db.audited("unassign", "group", group, (*Database).groupState, func(tx *Database) error {
+		return tx.removeGroupFromList(group)
+	})
+}
+
+func (db *Database) removeGroupFromList(group string) (err error) {
+	group_id, err := db.FindGroupId(group)
+	if err != nil {
+		return
+	}
+
+	_, err = db.Conn.Exec(sqlSetGroupList, DefaultList, group_id)
+	return
+}
+
+func (db *Database) AddClientToGroup(group string, client_id string) error {
+	return db.auditedClient("group", client_id, func(tx *Database) error {
+		return tx.addClientToGroup(group, client_id)
+	})
+}
+
+func (db *Database) addClientToGroup(group string, client_id string) (err error) {
+	group_id, err := db.FindGroupId(group)
+	if err != nil {
+		return
+	}
+
+	if _, err = db.GetClient(client_id); err != nil {
+		return
+	}
+
+	_, err = db.Conn.Exec(sqlSetClientGroup, group_id, client_id, client_id)
+	return
+}
+
+func (db *Database) RemoveClientFromGroup(client_id string) error {
+	return db.auditedClient("ungroup", client_id, func(tx *Database) error {
+		return tx.removeClientFromGroup(client_id)
+	})
+}
+
+func (db *Database) removeClientFromGroup(client_id string) (err error) {
+	if _, err = db.GetClient(client_id); err != nil {
+		return
+	}
+
+	_, err = db.Conn.Exec(sqlSetClientGroup, NoGroup, client_id, client_id)
+	return
+}
+
+// FetchGroupMembers returns the clients in a group
+func (db *Database) FetchGroupMembers(name string) (members []Client, err error) {
+	id, err := db.FindGroupId(name)
+	if err != nil {
+		return
+	}
+
+	clients, err := db.FetchClients()
+	if err != nil {
+		return
+	}
+
+	for _, client := range clients {
+		if client.GroupId == id {
+			members = append(members, client)
+		}
+	}
+
+	return
+}
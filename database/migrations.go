@@ -0,0 +1,388 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+const (
+	sqlTableExists        string = "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?;"
+	sqlGetSchemaVersion   string = "SELECT value FROM config WHERE identifier = 'schema_version';"
+	sqlClearSchemaVersion string = "DELETE FROM config WHERE identifier = 'schema_version';"
+	sqlSetSchemaVersion   string = "INSERT INTO config(identifier, value) VALUES('schema_version', ?);"
+)
+
+// A Migration upgrades the schema from Version-1 to Version. Either Up (plain
+// SQL) or UpFunc may be set; UpFunc runs after Up if both are.
+type Migration struct {
+	Version     int
+	Description string
+	Up          string
+	UpFunc      func(tx *sql.Tx) error
+}
+
+// Migrations must be kept in order, and released migrations must never be
+// edited -- add a new one instead.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		Up:          sqlCreateTables,
+	},
+	{
+		Version:     2,
+		Description: "hash plaintext console password",
+		UpFunc:      migrateHashPassword,
+	},
+	{
+		Version:     3,
+		Description: "per-URL display duration",
+		Up:          "ALTER TABLE url_list_url ADD COLUMN duration INTEGER NOT NULL DEFAULT 0;",
+	},
+	{
+		Version:     4,
+		Description: "explicit ordering of URLs within a list",
+		Up: `
+		ALTER TABLE url_list_url ADD COLUMN position INTEGER NOT NULL DEFAULT 0;
+		UPDATE url_list_url SET position = (
+			SELECT count(*) FROM url_list_url AS other
+			WHERE other.url_list_id = url_list_url.url_list_id AND other.id <= url_list_url.id
+		);
+		`,
+	},
+	{
+		Version:     5,
+		Description: "per-list rotation mode",
+		Up:          "ALTER TABLE url_lists ADD COLUMN mode TEXT NOT NULL DEFAULT 'sequential';",
+	},
+	{
+		Version:     6,
+		Description: "queue of commands for the running daemon",
+		Up: `
+		CREATE TABLE commands (
+			id         INTEGER PRIMARY KEY AUTOINCREMENT,
+			action     TEXT NOT NULL,
+			data       TEXT NOT NULL DEFAULT '',
+			client     TEXT NOT NULL DEFAULT '',
+			list       TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		`,
+	},
+	{
+		Version:     7,
+		Description: "revision counter bumped on changes displays care about",
+		Up: `
+		CREATE TABLE revision (value INTEGER NOT NULL);
+		INSERT INTO revision (value) VALUES (0);
+		CREATE TRIGGER url_list_url_insert_revision AFTER INSERT ON url_list_url
+		BEGIN UPDATE revision SET value = value + 1; END;
+		CREATE TRIGGER url_list_url_update_revision AFTER UPDATE ON url_list_url
+		BEGIN UPDATE revision SET value = value + 1; END;
+		CREATE TRIGGER url_list_url_delete_revision AFTER DELETE ON url_list_url
+		BEGIN UPDATE revision SET value = value + 1; END;
+		CREATE TRIGGER url_lists_insert_revision AFTER INSERT ON url_lists
+		BEGIN UPDATE revision SET value = value + 1; END;
+		CREATE TRIGGER url_lists_update_revision AFTER UPDATE ON url_lists
+		BEGIN UPDATE revision SET value = value + 1; END;
+		CREATE TRIGGER url_lists_delete_revision AFTER DELETE ON url_lists
+		BEGIN UPDATE revision SET value = value + 1; END;
+		CREATE TRIGGER urls_update_revision AFTER UPDATE ON urls
+		BEGIN UPDATE revision SET value = value + 1; END;
+		CREATE TRIGGER urls_delete_revision AFTER DELETE ON urls
+		BEGIN UPDATE revision SET value = value + 1; END;
+		CREATE TRIGGER clients_update_revision AFTER UPDATE OF url_list_id ON clients
+		BEGIN UPDATE revision SET value = value + 1; END;
+		CREATE TRIGGER clients_delete_revision AFTER DELETE ON clients
+		BEGIN UPDATE revision SET value = value + 1; END;
+		`,
+	},
+	{
+		Version:     8,
+		Description: "time of day list schedules",
+		Up: `
+		CREATE TABLE schedules (
+			id          INTEGER PRIMARY KEY,
+			client      TEXT NOT NULL DEFAULT '',
+			list_id     INTEGER,
+			url_list_id INTEGER NOT NULL,
+			days        TEXT NOT NULL DEFAULT '',
+			start_time  TEXT NOT NULL,
+			end_time    TEXT NOT NULL,
+			timezone    TEXT NOT NULL DEFAULT ''
+		);
+		CREATE TRIGGER schedules_insert_revision AFTER INSERT ON schedules
+		BEGIN UPDATE revision SET value = value + 1; END;
+		CREATE TRIGGER schedules_update_revision AFTER UPDATE ON schedules
+		BEGIN UPDATE revision SET value = value + 1; END;
+		CREATE TRIGGER schedules_delete_revision AFTER DELETE ON schedules
+		BEGIN UPDATE revision SET value = value + 1; END;
+		`,
+	},
+	{
+		Version:     9,
+		Description: "client groups",
+		Up: `
+		CREATE TABLE client_groups (
+			id          INTEGER PRIMARY KEY,
+			name        TEXT NOT NULL UNIQUE,
+			url_list_id INTEGER NOT NULL DEFAULT 0
+		);
+		ALTER TABLE clients ADD COLUMN group_id INTEGER NOT NULL DEFAULT 0;
+		CREATE TRIGGER client_groups_insert_revision AFTER INSERT ON client_groups
+		BEGIN UPDATE revision SET value = value + 1; END;
+		CREATE TRIGGER client_groups_update_revision AFTER UPDATE ON client_groups
+		BEGIN UPDATE revision SET value = value + 1; END;
+		CREATE TRIGGER client_groups_delete_revision AFTER DELETE ON client_groups
+		BEGIN UPDATE revision SET value = value + 1; END;
+		CREATE TRIGGER clients_group_revision AFTER UPDATE OF group_id ON clients
+		BEGIN UPDATE revision SET value = value + 1; END;
+		`,
+	},
+	{
+		Version:     10,
+		Description: "latest status reported by each client",
+		Up: `
+		CREATE TABLE client_status (
+			identifier TEXT PRIMARY KEY,
+			url        TEXT NOT NULL DEFAULT '',
+			position   INTEGER NOT NULL DEFAULT 0,
+			count      INTEGER NOT NULL DEFAULT 0,
+			paused     INTEGER NOT NULL DEFAULT 0,
+			flashing   INTEGER NOT NULL DEFAULT 0,
+			loaded     INTEGER NOT NULL DEFAULT 0,
+			error      TEXT NOT NULL DEFAULT '',
+			uptime     INTEGER NOT NULL DEFAULT 0,
+			updated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		`,
+	},
+	{
+		Version:     11,
+		Description: "append-only audit log of changes to the setup",
+		Up: `
+		CREATE TABLE audit (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at  TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			actor       TEXT NOT NULL DEFAULT '',
+			source      TEXT NOT NULL DEFAULT '',
+			address     TEXT NOT NULL DEFAULT '',
+			action      TEXT NOT NULL,
+			object_type TEXT NOT NULL,
+			object      TEXT NOT NULL DEFAULT '',
+			before      TEXT NOT NULL DEFAULT '',
+			after       TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX audit_object ON audit (object_type, object);
+		CREATE TRIGGER audit_no_update BEFORE UPDATE ON audit
+		BEGIN SELECT RAISE(ABORT, 'The audit log is append-only'); END;
+		CREATE TRIGGER audit_no_delete BEFORE DELETE ON audit
+		BEGIN SELECT RAISE(ABORT, 'The audit log is append-only'); END;
+		`,
+	},
+	{
+		Version:     12,
+		Description: "user accounts with roles and API tokens",
+		Up: `
+		CREATE TABLE users (
+			id         INTEGER PRIMARY KEY,
+			name       TEXT NOT NULL UNIQUE,
+			role       TEXT NOT NULL,
+			password   TEXT NOT NULL,
+			created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE api_tokens (
+			id         INTEGER PRIMARY KEY,
+			user_id    INTEGER NOT NULL,
+			name       TEXT NOT NULL,
+			hash       TEXT NOT NULL UNIQUE,
+			created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			last_used  TEXT NOT NULL DEFAULT '',
+			UNIQUE (user_id, name)
+		);
+		`,
+		UpFunc: migrateConsolePassword,
+	},
+	{
+		Version:     13,
+		Description: "client approval",
+		Up: `
+		ALTER TABLE clients ADD COLUMN approval TEXT NOT NULL DEFAULT 'approved';
+		CREATE TRIGGER clients_approval_revision AFTER UPDATE OF approval ON clients
+		BEGIN UPDATE revision SET value = value + 1; END;
+		`,
+	},
+}
+
+// LatestSchemaVersion is the schema version this build of wbd expects.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+func (db *Database) tableExists(name string) (exists bool, err error) {
+	var count int
+	err = db.Conn.QueryRow(sqlTableExists, name).Scan(&count)
+	exists = count > 0
+	return
+}
+
+// SchemaVersion returns the version of the schema currently installed. An
+// empty database is version 0, and a database created before migrations
+// existed is version 1.
+func (db *Database) SchemaVersion() (version int, err error) {
+	exists, err := db.tableExists("config")
+	if err != nil || !exists {
+		return
+	}
+
+	var value string
+	err = db.Conn.QueryRow(sqlGetSchemaVersion).Scan(&value)
+	if err == sql.ErrNoRows {
+		return 1, nil
+	}
+	if err != nil {
+		return
+	}
+
+	version, err = strconv.Atoi(value)
+	return
+}
+
+// PendingMigrations returns the migrations that have yet to be applied.
+func (db *Database) PendingMigrations() (pending []Migration, err error) {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return
+	}
+
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+
+	return
+}
+
+// CheckSchema returns an error unless the database is at the schema version
+// this build expects.
+func (db *Database) CheckSchema() (err error) {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return
+	}
+
+	switch latest := LatestSchemaVersion(); {
+	case version < latest:
+		err = fmt.Errorf("Database schema is out of date (version %d, expected %d); run `wbd migrate`", version, latest)
+	case version > latest:
+		err = fmt.Errorf("Database schema is newer than this build of wbd (version %d, expected %d)", version, latest)
+	}
+
+	return
+}
+
+// Migrate applies every pending migration, each in its own transaction, and
+// returns the ones that were applied.
+func (db *Database) Migrate() (applied []Migration, err error) {
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		return
+	}
+
+	for _, m := range pending {
+		if err = db.applyMigration(m); err != nil {
+			return
+		}
+
+		applied = append(applied, m)
+	}
+
+	return
+}
+
+func (db *Database) applyMigration(m Migration) (err error) {
+	tx, err := db.Conn.Begin()
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Migration %d (%s) failed: %s", m.Version, m.Description, err)
+		}
+	}()
+
+	if m.Up != "" {
+		if _, err = tx.Exec(m.Up); err != nil {
+			return
+		}
+	}
+
+	if m.UpFunc != nil {
+		if err = m.UpFunc(tx.Tx); err != nil {
+			return
+		}
+	}
+
+	if _, err = tx.Exec(sqlClearSchemaVersion); err != nil {
+		return
+	}
+
+	if _, err = tx.Exec(sqlSetSchemaVersion, strconv.Itoa(m.Version)); err != nil {
+		return
+	}
+
+	err = tx.Commit()
+	return
+}
+
+// migrateConsolePassword turns the shared console password into an admin
+// user, so logging in keeps working
+func migrateConsolePassword(tx *sql.Tx) (err error) {
+	var hash string
+	err = tx.QueryRow(sqlGetConfig, "password").Scan(&hash)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+
+	if _, err = tx.Exec(sqlInsertUser, "admin", RoleAdmin, hash); err != nil {
+		return
+	}
+
+	_, err = tx.Exec(sqlDeleteConfig, "password")
+	return
+}
+
+func migrateHashPassword(tx *sql.Tx) (err error) {
+	var password string
+	err = tx.QueryRow(sqlGetConfig, "password").Scan(&password)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+
+	if _, err = tx.Exec(sqlDeleteConfig, "password"); err != nil {
+		return
+	}
+
+	// Older installs stored an empty password when none was given
+	if password == "" {
+		return
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(sqlInsertConfig, "password", hash)
+	return
+}
@@ -0,0 +1,389 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// schedules table
+	sqlInsertSchedule string = `
+	INSERT INTO schedules (client, list_id, url_list_id, days, start_time, end_time, timezone)
+	VALUES(?, ?, ?, ?, ?, ?, ?);
+	`
+	sqlFetchSchedules string = `
+	SELECT schedules.id, client, COALESCE(list_id, -1), COALESCE(source.name, ''),
+		url_list_id, target.name, days, start_time, end_time, timezone
+	FROM schedules
+	LEFT JOIN url_lists AS source ON source.id = schedules.list_id
+	INNER JOIN url_lists AS target ON target.id = schedules.url_list_id
+	ORDER BY schedules.id;
+	`
+	sqlDeleteSchedule        string = "DELETE FROM schedules WHERE id = ?;"
+	sqlDeleteClientSchedules string = "DELETE FROM schedules WHERE client = ?;"
+	sqlDeleteListSchedules   string = "DELETE FROM schedules WHERE list_id = ? OR url_list_id = ?;"
+
+	clockFormat string = "15:04"
+)
+
+var (
+	ErrScheduleTarget  = errors.New("A schedule applies to either a client or a list")
+	ErrInvalidTime     = errors.New("Times must be given as HH:MM")
+	ErrInvalidDays     = errors.New("Days must be day names or ranges, e.g. mon-fri,sun")
+	ErrInvalidTimezone = errors.New("Unknown timezone")
+)
+
+// Weekdays in the order schedules list them
+var weekdays = []time.Weekday{
+	time.Monday,
+	time.Tuesday,
+	time.Wednesday,
+	time.Thursday,
+	time.Friday,
+	time.Saturday,
+	time.Sunday,
+}
+
+// A Schedule shows the Show list instead of the usual one during a window of
+// the day, either on a single client or on every client assigned to List.
+// Windows that end before they start run past midnight, and a window that
+// starts and ends at the same time lasts all day.
+type Schedule struct {
+	Id       int
+	Client   string
+	ListId   int
+	List     string
+	ShowId   int
+	Show     string
+	Days     string
+	Start    string
+	End      string
+	Timezone string
+}
+
+// ParseDays turns something like "mon-fri,sun" into the comma separated
+// list of days a schedule stores. Every day (or an empty spec) is stored as
+// an empty string.
+func ParseDays(spec string) (days string, err error) {
+	spec = strings.ToLower(strings.Replace(spec, " ", "", -1))
+
+	switch spec {
+	case "", "all", "daily":
+		return
+	case "weekdays":
+		spec = "mon-fri"
+	case "weekends":
+		spec = "sat-sun"
+	}
+
+	selected := make(map[time.Weekday]bool)
+	for _, part := range strings.Split(spec, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+
+		first, ok := parseWeekday(bounds[0])
+		if !ok {
+			return "", ErrInvalidDays
+		}
+
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = parseWeekday(bounds[1]); !ok {
+				return "", ErrInvalidDays
+			}
+		}
+
+		// Ranges may wrap around the end of the week, e.g. fri-mon
+		for day := first; ; day = (day + 1) % 7 {
+			selected[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+
+	if len(selected) == len(weekdays) {
+		return
+	}
+
+	var names []string
+	for _, day := range weekdays {
+		if selected[day] {
+			names = append(names, weekdayName(day))
+		}
+	}
+
+	days = strings.Join(names, ",")
+	return
+}
+
+func parseWeekday(name string) (day time.Weekday, ok bool) {
+	if len(name) < 3 {
+		return
+	}
+
+	for _, day = range weekdays {
+		if strings.HasPrefix(strings.ToLower(day.String()), name) {
+			return day, true
+		}
+	}
+
+	return
+}
+
+func weekdayName(day time.Weekday) string {
+	return strings.ToLower(day.String()[:3])
+}
+
+// parseClock returns the number of minutes past midnight of an HH:MM time
+func parseClock(clock string) (minutes int, err error) {
+	t, err := time.Parse(clockFormat, clock)
+	if err != nil {
+		return 0, ErrInvalidTime
+	}
+
+	minutes = t.Hour()*60 + t.Minute()
+	return
+}
+
+func (s Schedule) location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.Local, nil
+	}
+
+	return time.LoadLocation(s.Timezone)
+}
+
+func (s Schedule) onDay(day time.Weekday) bool {
+	if s.Days == "" {
+		return true
+	}
+
+	for _, name := range strings.Split(s.Days, ",") {
+		if name == weekdayName(day) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Active reports whether the schedule's window covers the given time. The
+// days a schedule runs on are the days its window starts.
+func (s Schedule) Active(now time.Time) bool {
+	loc, err := s.location()
+	if err != nil {
+		return false
+	}
+
+	start, err := parseClock(s.Start)
+	if err != nil {
+		return false
+	}
+
+	end, err := parseClock(s.End)
+	if err != nil {
+		return false
+	}
+
+	now = now.In(loc)
+	minute := now.Hour()*60 + now.Minute()
+	today := now.Weekday()
+
+	switch {
+	case start == end:
+		return s.onDay(today)
+	case start < end:
+		return s.onDay(today) && minute >= start && minute < end
+	case minute >= start:
+		return s.onDay(today)
+	case minute < end:
+		return s.onDay((today + 6) % 7)
+	}
+
+	return false
+}
+
+// InsertSchedule validates and saves a schedule. Client may be an identifier
+// or alias, and List and Show are list names.
+func (db *Database) InsertSchedule(s Schedule) error {
+	return db.Transaction(func(tx *Database) (err error) {
+		id, err := tx.insertSchedule(s)
+		if err != nil {
+			return
+		}
+
+		object := strconv.Itoa(id)
+		after, err := tx.scheduleState(object)
+		if err != nil {
+			return
+		}
+
+		return tx.audit("insert", "schedule", object, "", after)
+	})
+}
+
+func (db *Database) insertSchedule(s Schedule) (id int, err error) {
+	if (s.Client == "") == (s.List == "") {
+		return 0, ErrScheduleTarget
+	}
+
+	if _, err = parseClock(s.Start); err != nil {
+		return
+	}
+	if _, err = parseClock(s.End); err != nil {
+		return
+	}
+
+	if s.Days, err = ParseDays(s.Days); err != nil {
+		return
+	}
+
+	if _, err = s.location(); err != nil {
+		return 0, ErrInvalidTimezone
+	}
+
+	show_id, err := db.FindListId(s.Show)
+	if err != nil {
+		return
+	}
+
+	var client string
+	var list_id interface{}
+	if s.Client != "" {
+		info, err := db.GetClient(s.Client)
+		if err != nil {
+			return 0, err
+		}
+
+		client = info.Identifier
+	} else {
+		if list_id, err = db.FindListId(s.List); err != nil {
+			return
+		}
+	}
+
+	result, err := db.Conn.Exec(sqlInsertSchedule, client, list_id, show_id, s.Days, s.Start, s.End, s.Timezone)
+	if err != nil {
+		return
+	}
+
+	last, err := result.LastInsertId()
+	id = int(last)
+	return
+}
+
+func (db *Database) FetchSchedules() (schedules []Schedule, err error) {
+	rows, err := db.Conn.Query(sqlFetchSchedules)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s Schedule
+
+		err = rows.Scan(
+			&s.Id,
+			&s.Client,
+			&s.ListId,
+			&s.List,
+			&s.ShowId,
+			&s.Show,
+			&s.Days,
+			&s.Start,
+			&s.End,
+			&s.Timezone)
+
+		if err != nil {
+			return
+		}
+
+		schedules = append(schedules, s)
+	}
+
+	err = rows.Err()
+
+	return
+}
+
+func (db *Database) DeleteSchedule(id int) error {
+	return db.audited("delete", "schedule", strconv.Itoa(id), (*Database).scheduleState, func(tx *Database) error {
+		return tx.deleteSchedule(id)
+	})
+}
+
+func (db *Database) deleteSchedule(id int) (err error) {
+	result, err := db.Conn.Exec(sqlDeleteSchedule, id)
+	if err != nil {
+		return
+	}
+
+	count, err := result.RowsAffected()
+	if err == nil && count == 0 {
+		err = sql.ErrNoRows
+	}
+
+	return
+}
+
+// ActiveSchedules returns the schedules whose windows cover the given time
+func (db *Database) ActiveSchedules(now time.Time) (active []Schedule, err error) {
+	schedules, err := db.FetchSchedules()
+	if err != nil {
+		return
+	}
+
+	for _, s := range schedules {
+		if s.Active(now) {
+			active = append(active, s)
+		}
+	}
+
+	return
+}
+
+// GetActiveList returns the list a client should be showing at the given
+// time: its own list, unless a schedule says otherwise. Schedules for the
+// client itself win over schedules for its list.
+func (db *Database) GetActiveList(identifier string, now time.Time) (list List, err error) {
+	active, err := db.ActiveSchedules(now)
+	if err != nil {
+		return
+	}
+
+	return db.GetScheduledList(identifier, active)
+}
+
+// GetScheduledList is GetActiveList given the schedules that are active, so
+// they can be worked out once for many clients
+func (db *Database) GetScheduledList(identifier string, active []Schedule) (list List, err error) {
+	list, err = db.GetClientList(identifier)
+	if err != nil {
+		return
+	}
+
+	info, err := db.GetClient(identifier)
+	if err != nil {
+		return
+	}
+
+	show := -1
+	for _, s := range active {
+		if s.Client == info.Identifier {
+			show = s.ShowId
+			break
+		}
+		if show < 0 && s.Client == "" && s.ListId == list.Id {
+			show = s.ShowId
+		}
+	}
+
+	if show < 0 {
+		return
+	}
+
+	return db.GetListById(show)
+}
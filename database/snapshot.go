@@ -0,0 +1,433 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const (
+	sqlFetchConfig string = "SELECT identifier, value FROM config WHERE identifier != 'schema_version' ORDER BY identifier;"
+	sqlClearList   string = "DELETE FROM url_list_url WHERE url_list_id = ?;"
+
+	// Everything Import replaces, leaving the schema version, secrets and
+	// the Default list
+	sqlClearSetup string = `
+	DELETE FROM url_list_url;
+	DELETE FROM urls;
+	DELETE FROM url_lists WHERE id != 0;
+	UPDATE url_lists SET mode = 'sequential' WHERE id = 0;
+	DELETE FROM client_groups;
+	DELETE FROM schedules;
+	DELETE FROM client_status;
+	DELETE FROM clients;
+	DELETE FROM config WHERE identifier NOT IN ('schema_version', 'password');
+	`
+)
+
+// A Snapshot is a wbd setup -- everything but what displays are doing right
+// now -- in a form that can be written out and read back in, e.g. to move
+// wbd to another host. Lists are referred to by name, and an empty list name
+// means the Default list (or, for a client, whatever its group shows).
+type Snapshot struct {
+	Urls      []string           `json:"urls" yaml:"urls"`
+	Lists     []SnapshotList     `json:"lists" yaml:"lists"`
+	Groups    []SnapshotGroup    `json:"groups" yaml:"groups"`
+	Clients   []SnapshotClient   `json:"clients" yaml:"clients"`
+	Schedules []SnapshotSchedule `json:"schedules" yaml:"schedules"`
+	Config    map[string]string  `json:"config" yaml:"config"`
+}
+
+// A SnapshotList's URLs are in the order they're shown
+type SnapshotList struct {
+	Name string    `json:"name" yaml:"name"`
+	Mode string    `json:"mode,omitempty" yaml:"mode,omitempty"`
+	Urls []ListUrl `json:"urls" yaml:"urls"`
+}
+
+type SnapshotGroup struct {
+	Name string `json:"name" yaml:"name"`
+	List string `json:"list,omitempty" yaml:"list,omitempty"`
+}
+
+type SnapshotClient struct {
+	Identifier string `json:"identifier" yaml:"identifier"`
+	Alias      string `json:"alias,omitempty" yaml:"alias,omitempty"`
+	List       string `json:"list,omitempty" yaml:"list,omitempty"`
+	Group      string `json:"group,omitempty" yaml:"group,omitempty"`
+	// Approval is left out for approved clients
+	Approval string `json:"approval,omitempty" yaml:"approval,omitempty"`
+}
+
+func snapshotApproval(approval string) string {
+	if approval == ApprovalApproved {
+		return ""
+	}
+
+	return approval
+}
+
+func (c SnapshotClient) approval() (string, error) {
+	if c.Approval == "" {
+		return ApprovalApproved, nil
+	}
+	if !validApproval(c.Approval) {
+		return "", fmt.Errorf("Client %s: %s", c.Identifier, ErrInvalidApproval)
+	}
+
+	return c.Approval, nil
+}
+
+type SnapshotSchedule struct {
+	Client   string `json:"client,omitempty" yaml:"client,omitempty"`
+	List     string `json:"list,omitempty" yaml:"list,omitempty"`
+	Show     string `json:"show" yaml:"show"`
+	Days     string `json:"days,omitempty" yaml:"days,omitempty"`
+	Start    string `json:"from" yaml:"from"`
+	End      string `json:"to" yaml:"to"`
+	Timezone string `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+}
+
+// FetchConfig returns every setting except the schema version and secrets
+func (db *Database) FetchConfig() (config map[string]string, err error) {
+	rows, err := db.Conn.Query(sqlFetchConfig)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	config = make(map[string]string)
+	for rows.Next() {
+		var identifier string
+		var value sql.NullString
+
+		if err = rows.Scan(&identifier, &value); err != nil {
+			return
+		}
+
+		if isSecret(identifier) {
+			continue
+		}
+
+		config[identifier] = value.String
+	}
+
+	err = rows.Err()
+
+	return
+}
+
+// listName is the name a snapshot uses for a list id: empty for the Default
+// list or a list that no longer exists
+func (db *Database) listName(id int) (name string, err error) {
+	if id == DefaultList {
+		return "", nil
+	}
+
+	name, err = db.FindListName(id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return
+}
+
+// Export takes a consistent snapshot of the whole setup
+func (db *Database) Export() (s Snapshot, err error) {
+	err = db.Transaction(func(tx *Database) (err error) {
+		s, err = tx.export()
+		return
+	})
+
+	return
+}
+
+func (db *Database) export() (s Snapshot, err error) {
+	if s.Urls, err = db.FetchUrls(); err != nil {
+		return
+	}
+
+	names, err := db.FetchLists()
+	if err != nil {
+		return
+	}
+
+	for _, name := range names {
+		list, err := db.GetList(name)
+		if err != nil {
+			return s, err
+		}
+
+		urls, err := db.FetchListUrlsById(list.Id)
+		if err != nil {
+			return s, err
+		}
+
+		s.Lists = append(s.Lists, SnapshotList{list.Name, list.Mode, urls})
+	}
+
+	groups, err := db.FetchGroups()
+	if err != nil {
+		return
+	}
+
+	groupNames := make(map[int]string)
+	for _, group := range groups {
+		groupNames[group.Id] = group.Name
+
+		list, err := db.listName(group.UrlListId)
+		if err != nil {
+			return s, err
+		}
+
+		s.Groups = append(s.Groups, SnapshotGroup{group.Name, list})
+	}
+
+	clients, err := db.FetchClients()
+	if err != nil {
+		return
+	}
+
+	for _, client := range clients {
+		list, err := db.listName(client.UrlListId)
+		if err != nil {
+			return s, err
+		}
+
+		s.Clients = append(s.Clients, SnapshotClient{client.Identifier, client.Alias, list, groupNames[client.GroupId], snapshotApproval(client.Approval)})
+	}
+
+	schedules, err := db.FetchSchedules()
+	if err != nil {
+		return
+	}
+
+	for _, schedule := range schedules {
+		s.Schedules = append(s.Schedules, SnapshotSchedule{
+			Client:   schedule.Client,
+			List:     schedule.List,
+			Show:     schedule.Show,
+			Days:     schedule.Days,
+			Start:    schedule.Start,
+			End:      schedule.End,
+			Timezone: schedule.Timezone,
+		})
+	}
+
+	s.Config, err = db.FetchConfig()
+
+	return
+}
+
+// Import loads a snapshot in a single transaction. With replace, the setup
+// becomes exactly the snapshot. Otherwise it's merged in: everything in the
+// snapshot is added or updated, and everything else is left alone, except
+// that a list's URLs are replaced by the snapshot's so their order holds.
+func (db *Database) Import(s Snapshot, replace bool) (err error) {
+	return db.Transaction(func(tx *Database) error {
+		return tx.importSnapshot(s, replace)
+	})
+}
+
+func (db *Database) importSnapshot(s Snapshot, replace bool) (err error) {
+	if replace {
+		if err = db.clearSetup(); err != nil {
+			return
+		}
+	}
+
+	urls := s.Urls
+	for _, list := range s.Lists {
+		for _, url := range list.Urls {
+			urls = append(urls, url.Url)
+		}
+	}
+
+	for _, url := range urls {
+		if err = db.InsertUrl(url); err != nil && err != ErrUrlExists {
+			return
+		}
+	}
+
+	for _, list := range s.Lists {
+		if err = db.importList(list); err != nil {
+			return fmt.Errorf("List %s: %s", list.Name, err)
+		}
+	}
+
+	for _, group := range s.Groups {
+		if err = db.importGroup(group); err != nil {
+			return fmt.Errorf("Group %s: %s", group.Name, err)
+		}
+	}
+
+	for _, client := range s.Clients {
+		if err = db.importClient(client); err != nil {
+			return fmt.Errorf("Client %s: %s", client.Identifier, err)
+		}
+	}
+
+	existing, err := db.FetchSchedules()
+	if err != nil {
+		return
+	}
+
+	for _, schedule := range s.Schedules {
+		if err = db.importSchedule(schedule, existing); err != nil {
+			return fmt.Errorf("Schedule showing %s: %s", schedule.Show, err)
+		}
+	}
+
+	for identifier, value := range s.Config {
+		// Older exports carried secrets, which are left as they are
+		if identifier == "schema_version" || isSecret(identifier) {
+			continue
+		}
+
+		if err = db.SetConfig(identifier, value); err != nil {
+			return
+		}
+	}
+
+	return nil
+}
+
+// clearSetup empties everything Import replaces, recording what was there in
+// the audit log
+func (db *Database) clearSetup() (err error) {
+	before, err := db.export()
+	if err != nil {
+		return
+	}
+
+	state, err := auditState(before)
+	if err != nil {
+		return
+	}
+
+	if _, err = db.Conn.Exec(sqlClearSetup); err != nil {
+		return
+	}
+
+	return db.audit("replace", "setup", "", state, "")
+}
+
+// findList is FindListId with an error that says which list is missing
+func (db *Database) findList(name string) (id int, err error) {
+	id, err = db.FindListId(name)
+	if err == sql.ErrNoRows {
+		err = fmt.Errorf("No list named %q", name)
+	}
+
+	return
+}
+
+func (db *Database) importList(list SnapshotList) (err error) {
+	if err = db.InsertList(list.Name); err != nil && err != ErrListExists {
+		return
+	}
+
+	mode := list.Mode
+	if mode == "" {
+		mode = ModeSequential
+	}
+	if err = db.SetListMode(list.Name, mode); err != nil {
+		return
+	}
+
+	return db.setListUrls(list.Name, list.Urls)
+}
+
+func (db *Database) importGroup(group SnapshotGroup) (err error) {
+	if err = db.InsertGroup(group.Name); err != nil && err != ErrGroupExists {
+		return
+	}
+
+	if group.List == "" {
+		return db.RemoveGroupFromList(group.Name)
+	}
+
+	if _, err = db.findList(group.List); err != nil {
+		return
+	}
+
+	return db.AssignGroupToList(group.List, group.Name)
+}
+
+func (db *Database) importClient(client SnapshotClient) (err error) {
+	if client.Identifier == "" {
+		return errors.New("Clients need an identifier")
+	}
+
+	approval, err := client.approval()
+	if err != nil {
+		return
+	}
+
+	info, err := db.GetClient(client.Identifier)
+	if err == sql.ErrNoRows {
+		err = db.addClient(client.Identifier, "", approval)
+	} else if err == nil && info.Approval != approval {
+		err = db.SetClientApproval(client.Identifier, approval)
+	}
+	if err != nil {
+		return
+	}
+
+	if err = db.SetClientAlias(client.Identifier, client.Alias); err != nil {
+		return
+	}
+
+	if client.List == "" {
+		err = db.RemoveClientFromList(client.Identifier)
+	} else if _, err = db.findList(client.List); err == nil {
+		err = db.AssignClientToList(client.List, client.Identifier)
+	}
+	if err != nil {
+		return
+	}
+
+	if client.Group == "" {
+		return db.RemoveClientFromGroup(client.Identifier)
+	}
+
+	if _, err = db.FindGroupId(client.Group); err == sql.ErrNoRows {
+		return fmt.Errorf("No group named %q", client.Group)
+	}
+
+	return db.AddClientToGroup(client.Group, client.Identifier)
+}
+
+// importSchedule adds a schedule unless an identical one already exists
+func (db *Database) importSchedule(schedule SnapshotSchedule, existing []Schedule) (err error) {
+	days, err := ParseDays(schedule.Days)
+	if err != nil {
+		return
+	}
+
+	for _, s := range existing {
+		if s.Client == schedule.Client && s.List == schedule.List && s.Show == schedule.Show &&
+			s.Days == days && s.Start == schedule.Start && s.End == schedule.End && s.Timezone == schedule.Timezone {
+			return nil
+		}
+	}
+
+	if _, err = db.findList(schedule.Show); err != nil {
+		return
+	}
+	if schedule.List != "" {
+		if _, err = db.findList(schedule.List); err != nil {
+			return
+		}
+	}
+
+	return db.InsertSchedule(Schedule{
+		Client:   schedule.Client,
+		List:     schedule.List,
+		Show:     schedule.Show,
+		Days:     schedule.Days,
+		Start:    schedule.Start,
+		End:      schedule.End,
+		Timezone: schedule.Timezone,
+	})
+}
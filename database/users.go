@@ -0,0 +1,459 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// users table
+	sqlInsertUser      string = "INSERT INTO users(name, role, password) VALUES(?, ?, ?);"
+	sqlGetUser         string = "SELECT id, name, role, created_at FROM users WHERE name = ?;"
+	sqlGetUserById     string = "SELECT id, name, role, created_at FROM users WHERE id = ?;"
+	sqlGetUserPassword string = "SELECT password FROM users WHERE name = ?;"
+	sqlFetchUsers      string = "SELECT id, name, role, created_at FROM users ORDER BY name;"
+	sqlSetUserRole     string = "UPDATE users SET role = ? WHERE id = ?;"
+	sqlSetUserPassword string = "UPDATE users SET password = ? WHERE id = ?;"
+	sqlDeleteUser      string = "DELETE FROM users WHERE id = ?;"
+	sqlCountUsers      string = "SELECT count(*) FROM users;"
+	sqlCountAdmins     string = "SELECT count(*) FROM users WHERE role = 'admin';"
+
+	// api_tokens table
+	sqlInsertToken string = "INSERT INTO api_tokens(user_id, name, hash) VALUES(?, ?, ?);"
+	sqlFetchTokens string = `
+	SELECT api_tokens.id, users.name, api_tokens.name, api_tokens.created_at, api_tokens.last_used
+	FROM api_tokens
+	INNER JOIN users ON users.id = api_tokens.user_id
+	ORDER BY users.name, api_tokens.name;
+	`
+	sqlFindToken        string = "SELECT user_id, name, last_used < datetime('now', '-1 minute') FROM api_tokens WHERE hash = ?;"
+	sqlFindTokenId      string = "SELECT id FROM api_tokens WHERE user_id = ? AND name = ?;"
+	sqlTouchToken       string = "UPDATE api_tokens SET last_used = CURRENT_TIMESTAMP WHERE hash = ?;"
+	sqlDeleteToken      string = "DELETE FROM api_tokens WHERE id = ?;"
+	sqlDeleteUserTokens string = "DELETE FROM api_tokens WHERE user_id = ?;"
+
+	// tokenPrefix marks wbd API tokens, so they're easy to spot if leaked
+	tokenPrefix string = "wbd_"
+)
+
+// Roles, each allowed everything the ones before it are
+const (
+	// Viewers can see the console and the state of the displays
+	RoleViewer Role = "viewer"
+
+	// Operators can also flash URLs and control and reload displays
+	RoleOperator Role = "operator"
+
+	// Admins can also change URLs, lists, clients and assignments
+	RoleAdmin Role = "admin"
+)
+
+var (
+	ErrUserExists    = errors.New("A user already exists with that name")
+	ErrInvalidRole   = errors.New("Role must be one of viewer, operator or admin")
+	ErrEmptyPassword = errors.New("Password can't be empty")
+	ErrLastAdmin     = errors.New("There must be at least one admin while there are users")
+	ErrTokenExists   = errors.New("That user already has a token with that name")
+)
+
+// A Role is what a user may do
+type Role string
+
+func (r Role) rank() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleOperator:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+
+	return 0
+}
+
+// Allows reports whether a user with this role may do what needs the role
+// needed
+func (r Role) Allows(needed Role) bool {
+	return r.rank() > 0 && r.rank() >= needed.rank()
+}
+
+func ParseRole(name string) (role Role, err error) {
+	role = Role(name)
+	if role.rank() == 0 {
+		return "", ErrInvalidRole
+	}
+
+	return
+}
+
+type User struct {
+	Id      int    `json:"id"`
+	Name    string `json:"name"`
+	Role    Role   `json:"role"`
+	Created string `json:"created"`
+}
+
+// A Token lets scripts use the API as a user. Only a hash of it is kept, so
+// the token itself is only seen when it's created.
+type Token struct {
+	Id       int    `json:"id"`
+	User     string `json:"user"`
+	Name     string `json:"name"`
+	Created  string `json:"created"`
+	LastUsed string `json:"last_used"`
+}
+
+func (db *Database) GetUser(name string) (user User, err error) {
+	err = db.Conn.QueryRow(sqlGetUser, name).Scan(&user.Id, &user.Name, &user.Role, &user.Created)
+	return
+}
+
+func (db *Database) GetUserById(id int) (user User, err error) {
+	err = db.Conn.QueryRow(sqlGetUserById, id).Scan(&user.Id, &user.Name, &user.Role, &user.Created)
+	return
+}
+
+func (db *Database) FetchUsers() (users []User, err error) {
+	rows, err := db.Conn.Query(sqlFetchUsers)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user User
+
+		err = rows.Scan(&user.Id, &user.Name, &user.Role, &user.Created)
+		if err != nil {
+			return
+		}
+
+		users = append(users, user)
+	}
+
+	err = rows.Err()
+
+	return
+}
+
+// HasUsers reports whether any users have been added. Until one is, the
+// console and API are open to everyone.
+func (db *Database) HasUsers() (found bool, err error) {
+	var count int
+	err = db.Conn.QueryRow(sqlCountUsers).Scan(&count)
+	found = count > 0
+
+	return
+}
+
+func (db *Database) InsertUser(name string, role Role, password string) error {
+	return db.audited("insert", "user", name, (*Database).userState, func(tx *Database) error {
+		return tx.insertUser(name, role, password)
+	})
+}
+
+func (db *Database) insertUser(name string, role Role, password string) (err error) {
+	if _, err = ParseRole(string(role)); err != nil {
+		return
+	}
+
+	if password == "" {
+		return ErrEmptyPassword
+	}
+
+	_, err = db.GetUser(name)
+	if err != nil && err != sql.ErrNoRows {
+		return
+	}
+
+	if err == nil {
+		return ErrUserExists
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return
+	}
+
+	if _, err = db.Conn.Exec(sqlInsertUser, name, role, hash); err != nil {
+		return
+	}
+
+	return db.checkAdmins()
+}
+
+// DeleteUser removes a user and revokes their tokens
+func (db *Database) DeleteUser(name string) error {
+	return db.audited("delete", "user", name, (*Database).userState, func(tx *Database) error {
+		return tx.deleteUser(name)
+	})
+}
+
+func (db *Database) deleteUser(name string) (err error) {
+	user, err := db.GetUser(name)
+	if err != nil {
+		return
+	}
+
+	if _, err = db.Conn.Exec(sqlDeleteUserTokens, user.Id); err != nil {
+		return
+	}
+
+	if _, err = db.Conn.Exec(sqlDeleteUser, user.Id); err != nil {
+		return
+	}
+
+	return db.checkAdmins()
+}
+
+func (db *Database) SetUserRole(name string, role Role) error {
+	return db.audited("set role", "user", name, (*Database).userState, func(tx *Database) error {
+		return tx.setUserRole(name, role)
+	})
+}
+
+func (db *Database) setUserRole(name string, role Role) (err error) {
+	if _, err = ParseRole(string(role)); err != nil {
+		return
+	}
+
+	user, err := db.GetUser(name)
+	if err != nil {
+		return
+	}
+
+	if _, err = db.Conn.Exec(sqlSetUserRole, role, user.Id); err != nil {
+		return
+	}
+
+	return db.checkAdmins()
+}
+
+// checkAdmins makes sure a change hasn't left users without an admin to
+// manage them
+func (db *Database) checkAdmins() (err error) {
+	var users, admins int
+	if err = db.Conn.QueryRow(sqlCountUsers).Scan(&users); err != nil {
+		return
+	}
+	if err = db.Conn.QueryRow(sqlCountAdmins).Scan(&admins); err != nil {
+		return
+	}
+
+	if users > 0 && admins == 0 {
+		return ErrLastAdmin
+	}
+
+	return
+}
+
+// SetUserPassword stores a salted hash of a user's new password
+func (db *Database) SetUserPassword(name string, password string) error {
+	return db.Transaction(func(tx *Database) (err error) {
+		if err = tx.setUserPassword(name, password); err != nil {
+			return
+		}
+
+		return tx.audit("set password", "user", name, hiddenValue, hiddenValue)
+	})
+}
+
+func (db *Database) setUserPassword(name string, password string) (err error) {
+	if password == "" {
+		return ErrEmptyPassword
+	}
+
+	user, err := db.GetUser(name)
+	if err != nil {
+		return
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return
+	}
+
+	_, err = db.Conn.Exec(sqlSetUserPassword, hash, user.Id)
+	return
+}
+
+// CheckUser returns the user with the given name and password. ok is false
+// if there's no such user or the password is wrong.
+func (db *Database) CheckUser(name string, password string) (user User, ok bool, err error) {
+	var hash string
+	err = db.Conn.QueryRow(sqlGetUserPassword, name).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return user, false, nil
+	}
+	if err != nil {
+		return
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return
+	}
+
+	user, err = db.GetUser(name)
+	ok = err == nil
+
+	return
+}
+
+func hashPassword(password string) (hash string, err error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hash = string(b)
+	return
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateToken makes a new API token for a user, returning the token
+func (db *Database) CreateToken(user string, name string) (token string, err error) {
+	err = db.audited("insert", "token", user+"/"+name, (*Database).tokenState, func(tx *Database) (err error) {
+		token, err = tx.createToken(user, name)
+		return
+	})
+
+	return
+}
+
+func (db *Database) createToken(user string, name string) (token string, err error) {
+	info, err := db.GetUser(user)
+	if err != nil {
+		return
+	}
+
+	var id int
+	err = db.Conn.QueryRow(sqlFindTokenId, info.Id, name).Scan(&id)
+	if err != nil && err != sql.ErrNoRows {
+		return
+	}
+
+	if err == nil {
+		return "", ErrTokenExists
+	}
+
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	token = tokenPrefix + hex.EncodeToString(b)
+
+	_, err = db.Conn.Exec(sqlInsertToken, info.Id, name, hashToken(token))
+	return
+}
+
+// DeleteToken revokes one of a user's API tokens
+func (db *Database) DeleteToken(user string, name string) error {
+	return db.audited("delete", "token", user+"/"+name, (*Database).tokenState, func(tx *Database) error {
+		return tx.deleteToken(user, name)
+	})
+}
+
+func (db *Database) deleteToken(user string, name string) (err error) {
+	info, err := db.GetUser(user)
+	if err != nil {
+		return
+	}
+
+	var id int
+	if err = db.Conn.QueryRow(sqlFindTokenId, info.Id, name).Scan(&id); err != nil {
+		return
+	}
+
+	_, err = db.Conn.Exec(sqlDeleteToken, id)
+	return
+}
+
+func (db *Database) FetchTokens() (tokens []Token, err error) {
+	rows, err := db.Conn.Query(sqlFetchTokens)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var token Token
+
+		err = rows.Scan(&token.Id, &token.User, &token.Name, &token.Created, &token.LastUsed)
+		if err != nil {
+			return
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	err = rows.Err()
+
+	return
+}
+
+// CheckToken returns the user an API token belongs to and the token's name,
+// noting when it was used, to within a minute. ok is false if there's no
+// such token.
+func (db *Database) CheckToken(token string) (user User, name string, ok bool, err error) {
+	hash := hashToken(token)
+
+	var user_id int
+	var stale bool
+	err = db.Conn.QueryRow(sqlFindToken, hash).Scan(&user_id, &name, &stale)
+	if err == sql.ErrNoRows {
+		return user, "", false, nil
+	}
+	if err != nil {
+		return
+	}
+
+	if user, err = db.GetUserById(user_id); err != nil {
+		return
+	}
+
+	// Only note the time now and then, so reads don't all become writes
+	if stale {
+		if _, err = db.Conn.Exec(sqlTouchToken, hash); err != nil {
+			return
+		}
+	}
+
+	return user, name, true, nil
+}
+
+func (db *Database) userState(name string) (state string, err error) {
+	user, err := db.GetUser(name)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return
+	}
+
+	return auditState(struct {
+		Name string `json:"name"`
+		Role Role   `json:"role"`
+	}{user.Name, user.Role})
+}
+
+// tokenState is a token's name, given as user/name, if it exists
+func (db *Database) tokenState(object string) (state string, err error) {
+	tokens, err := db.FetchTokens()
+	if err != nil {
+		return
+	}
+
+	for _, token := range tokens {
+		if token.User+"/"+token.Name == object {
+			return object, nil
+		}
+	}
+
+	return "", nil
+}
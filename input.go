@@ -3,11 +3,26 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
+
+	"github.com/howeyc/gopass"
 )
 
+// stdin is shared by every prompt, since a buffered reader can read ahead
+// into the answers to later ones
+var stdin = bufio.NewReader(os.Stdin)
+
+// stdinReader lets gopass read through stdin's buffer while still turning
+// off echo when stdin is a terminal
+type stdinReader struct{ *bufio.Reader }
+
+func (stdinReader) Fd() uintptr {
+	return os.Stdin.Fd()
+}
+
 func confirmDefault(question string, defaultAnswer bool) bool {
 	var prompt string
 	if defaultAnswer {
@@ -17,8 +32,7 @@ func confirmDefault(question string, defaultAnswer bool) bool {
 	}
 	fmt.Print(question, " ", prompt, " ")
 
-	r := bufio.NewReader(os.Stdin)
-	answer, err := r.ReadString('\n')
+	answer, err := readAnswer()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -33,3 +47,57 @@ func confirmDefault(question string, defaultAnswer bool) bool {
 		return defaultAnswer
 	}
 }
+
+// readAnswer reads a line from stdin. Without a terminal, stdin may end
+// before an answer is given, which counts as giving none.
+func readAnswer() (answer string, err error) {
+	answer, err = stdin.ReadString('\n')
+	if err == io.EOF {
+		fmt.Println()
+		if answer == "" {
+			log.Print("No answer before the end of input, using the default")
+		}
+		err = nil
+	}
+
+	return
+}
+
+// promptDefault asks for a line of input, returning defaultAnswer if none is
+// given
+func promptDefault(question string, defaultAnswer string) string {
+	fmt.Printf("%s [%s]: ", question, defaultAnswer)
+
+	answer, err := readAnswer()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return defaultAnswer
+	}
+
+	return answer
+}
+
+// promptPassword asks for a new password twice without echoing it
+func promptPassword() string {
+	password, err := gopass.GetPasswdPrompt("New password: ", false, stdinReader{stdin}, os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	confirm, err := gopass.GetPasswdPrompt("Confirm password: ", false, stdinReader{stdin}, os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(password) == 0 {
+		log.Fatal("Password can't be empty")
+	}
+	if string(password) != string(confirm) {
+		log.Fatal("Passwords don't match")
+	}
+
+	return string(password)
+}
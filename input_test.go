@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipedAnswers(t *testing.T) {
+	assert := assert.New(t)
+
+	r, w, err := os.Pipe()
+	assert.Nil(err)
+
+	saved := os.Stdin
+	os.Stdin, stdin = r, bufio.NewReader(r)
+	defer func() {
+		os.Stdin, stdin = saved, bufio.NewReader(saved)
+	}()
+
+	// All the answers arrive at once, as they do from a script
+	w.WriteString("y\nbob\nhunter2\nhunter2\n")
+	w.Close()
+
+	assert.True(confirmDefault("Add a user?", false))
+	assert.Equal("bob", promptDefault("User name", "admin"))
+	assert.Equal("hunter2", promptPassword())
+
+	// Once they run out, the defaults are used
+	assert.False(confirmDefault("Add another?", false))
+	assert.Equal("admin", promptDefault("User name", "admin"))
+}
@@ -25,30 +25,20 @@ func main() {
 
 			Action: handleRun,
 
-			Flags: []cli.Flag{
-				cli.StringFlag{
-					Name:   "url,w",
-					Value:  "",
-					Usage:  "location urls will be relative to (e.g. \"/wbd\")",
-					EnvVar: "WBD_URL",
-				},
-				cli.StringFlag{
-					Name:   "listen,l",
-					Value:  "0.0.0.0",
-					Usage:  "ip address to listen on",
-					EnvVar: "WBD_LISTEN",
-				},
-				cli.IntFlag{
-					Name:   "port,p",
-					Value:  80,
-					Usage:  "port to listen on",
-					EnvVar: "WBD_PORT",
-				},
-				cli.StringFlag{
-					Name:   "database,D",
-					Value:  "wbd.db",
-					Usage:  "sqlite database location",
-					EnvVar: "WBD_DATABASE",
+			Flags: runFlags,
+		},
+		{
+			Name:  "config",
+			Usage: "show the configuration wbd would run with",
+
+			Subcommands: []cli.Command{
+				{
+					Name:  "show",
+					Usage: "print the effective configuration, after flags, environment variables and the configuration file",
+
+					Action: handleConfigShow,
+
+					Flags: runFlags,
 				},
 			},
 		},
@@ -72,12 +62,9 @@ func main() {
 					Name:  "list,l",
 					Usage: "list urls in rotation (can be combined with --delete or --add)",
 				},
-				cli.StringFlag{
-					Name:   "database,D",
-					Value:  "wbd.db",
-					Usage:  "sqlite database location",
-					EnvVar: "WBD_DATABASE",
-				},
+				configFlag,
+				databaseFlag,
+				serverFlag,
 			},
 		},
 		{
@@ -101,17 +88,22 @@ func main() {
 					Usage: "list url lists in database (can be combined with --delete or --add)",
 				},
 				cli.StringFlag{
-					Name:   "database,D",
-					Value:  "wbd.db",
-					Usage:  "sqlite database location",
-					EnvVar: "WBD_DATABASE",
+					Name:  "mode,m",
+					Usage: "rotation mode: sequential, shuffle, or stagger (have machines show different pages)",
+				},
+				cli.StringFlag{
+					Name:  "name,n",
+					Usage: "list to set the mode of (defaults to the list given to --add)",
 				},
+				configFlag,
+				databaseFlag,
+				serverFlag,
 			},
 		},
 		{
 			Name:    "client",
 			Aliases: []string{"c"},
-			Usage:   "alias, remove, or list clients",
+			Usage:   "alias, approve, remove, or list clients",
 
 			Action: handleClient,
 
@@ -128,16 +120,58 @@ func main() {
 					Name:  "delete,d",
 					Usage: "remove specified client from database",
 				},
+				cli.StringFlag{
+					Name:  "approve",
+					Usage: "let a client awaiting approval show its list",
+				},
+				cli.StringFlag{
+					Name:  "reject",
+					Usage: "keep a client on the welcome page, even if it reconnects",
+				},
 				cli.BoolFlag{
 					Name:  "list,l",
 					Usage: "list known clients",
 				},
+				configFlag,
+				databaseFlag,
+				serverFlag,
+			},
+		},
+		{
+			Name:    "group",
+			Aliases: []string{"g"},
+			Usage:   "add, remove, or list client groups",
+
+			Action: handleGroup,
+
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "add,a",
+					Usage: "create a new group",
+				},
+				cli.StringFlag{
+					Name:  "delete,d",
+					Usage: "remove an existing group (its clients stay, without a group)",
+				},
+				cli.StringFlag{
+					Name:  "client,c",
+					Usage: "client to add to a group",
+				},
+				cli.StringFlag{
+					Name:  "name,n",
+					Usage: "group to add the client to (defaults to the group given to --add)",
+				},
 				cli.StringFlag{
-					Name:   "database,D",
-					Value:  "wbd.db",
-					Usage:  "sqlite database location",
-					EnvVar: "WBD_DATABASE",
+					Name:  "remove,r",
+					Usage: "client to take out of its group",
+				},
+				cli.BoolFlag{
+					Name:  "list,l",
+					Usage: "list groups and their clients (can be combined with --delete or --add)",
 				},
+				configFlag,
+				databaseFlag,
+				serverFlag,
 			},
 		},
 		{
@@ -158,18 +192,238 @@ func main() {
 				},
 				cli.StringFlag{
 					Name:  "client,c",
-					Usage: "client to assign to list",
+					Usage: "client to assign to list (overrides its group's list)",
+				},
+				cli.StringFlag{
+					Name:  "group,g",
+					Usage: "client group to assign to list",
+				},
+				cli.IntFlag{
+					Name:  "duration,s",
+					Usage: "seconds to show the url for in this list (0 uses the display's default)",
+				},
+				cli.IntFlag{
+					Name:  "position,p",
+					Usage: "position in the list to insert the url at, or move it to (starting from 1)",
+				},
+				cli.StringFlag{
+					Name:  "move,m",
+					Usage: "move the url \"up\" or \"down\" one place in the list",
 				},
 				cli.BoolFlag{
 					Name:  "delete,d",
 					Usage: "remove association between a list and a client or url",
 				},
+				configFlag,
+				databaseFlag,
+				serverFlag,
+			},
+		},
+		{
+			Name:    "schedule",
+			Aliases: []string{"s"},
+			Usage:   "show a different list at certain times of day",
+
+			Action: handleSchedule,
+
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "add,a",
+					Usage: "add a schedule showing this list",
+				},
+				cli.StringFlag{
+					Name:  "client,c",
+					Usage: "client the schedule applies to",
+				},
 				cli.StringFlag{
-					Name:   "database,D",
-					Value:  "wbd.db",
-					Usage:  "sqlite database location",
-					EnvVar: "WBD_DATABASE",
+					Name:  "list,l",
+					Usage: "list the schedule applies to (every client assigned to it)",
+				},
+				cli.StringFlag{
+					Name:  "days,w",
+					Usage: "days the schedule runs, e.g. \"mon-fri\" or \"sat,sun\" (default every day)",
 				},
+				cli.StringFlag{
+					Name:  "from,f",
+					Value: "00:00",
+					Usage: "time the schedule starts (HH:MM)",
+				},
+				cli.StringFlag{
+					Name:  "to,t",
+					Value: "00:00",
+					Usage: "time the schedule ends (HH:MM); earlier than --from runs past midnight",
+				},
+				cli.StringFlag{
+					Name:  "timezone,z",
+					Usage: "timezone of --from and --to, e.g. \"America/New_York\" (default server time)",
+				},
+				cli.IntFlag{
+					Name:  "delete,d",
+					Usage: "remove the schedule with this number",
+				},
+				cli.BoolFlag{
+					Name:  "show,s",
+					Usage: "list schedules (can be combined with --delete or --add)",
+				},
+				configFlag,
+				databaseFlag,
+				serverFlag,
+			},
+		},
+		{
+			Name:  "flash",
+			Usage: "briefly show a url on running displays",
+
+			Action: handleFlash,
+
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "url,u",
+					Usage: "url to show",
+				},
+				cli.IntFlag{
+					Name:  "duration,s",
+					Value: 60,
+					Usage: "seconds to show the url for before resuming rotation",
+				},
+				cli.StringFlag{
+					Name:  "client,c",
+					Usage: "only flash this client (identifier or alias)",
+				},
+				cli.StringFlag{
+					Name:  "list,l",
+					Usage: "only flash clients on this list",
+				},
+				configFlag,
+				databaseFlag,
+				serverFlag,
+			},
+		},
+		{
+			Name:      "control",
+			Usage:     "tell running displays to go to the next or previous page, pause, resume, reload or disconnect",
+			ArgsUsage: "next|previous|pause|resume|reload|disconnect",
+
+			Action: handleControl,
+
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "client,c",
+					Usage: "only control this client (identifier or alias)",
+				},
+				cli.StringFlag{
+					Name:  "list,l",
+					Usage: "only control clients on this list",
+				},
+				configFlag,
+				databaseFlag,
+				serverFlag,
+			},
+		},
+		{
+			Name:  "export",
+			Usage: "write out urls, lists, groups, clients, schedules and settings",
+
+			Action: handleExport,
+
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output,o",
+					Usage: "file to write to (default: standard output)",
+				},
+				cli.StringFlag{
+					Name:  "format,f",
+					Usage: "json or yaml (default: json if the output file ends in .json, otherwise yaml)",
+				},
+				configFlag,
+				databaseFlag,
+				serverFlag,
+			},
+		},
+		{
+			Name:      "import",
+			Usage:     "load an export, merging it in or replacing everything",
+			ArgsUsage: "<file, or - for standard input>",
+
+			Action: handleImport,
+
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "replace",
+					Usage: "replace the whole setup with the file's, instead of merging it in",
+				},
+				configFlag,
+				databaseFlag,
+				serverFlag,
+			},
+		},
+		{
+			Name:  "apply",
+			Usage: "bring lists, urls, groups, clients and schedules in line with a manifest",
+
+			Action: handleApply,
+
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "file,f",
+					Usage: "manifest to apply, in the same form as wbd export writes (- for standard input)",
+				},
+				cli.BoolFlag{
+					Name:  "prune",
+					Usage: "also remove what the manifest doesn't mention, in the sections it has",
+				},
+				cli.BoolFlag{
+					Name:  "diff",
+					Usage: "show the changes that would be made, without making them",
+				},
+				configFlag,
+				databaseFlag,
+				serverFlag,
+			},
+		},
+		{
+			Name:  "user",
+			Usage: "add, remove, or list the users who can log in to the console and API",
+
+			Action: handleUser,
+
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "add,a",
+					Usage: "add a user, asking for their password",
+				},
+				cli.StringFlag{
+					Name:  "delete,d",
+					Usage: "remove a user and their API tokens",
+				},
+				cli.StringFlag{
+					Name:  "name,n",
+					Usage: "user to change (defaults to the user given to --add)",
+				},
+				cli.StringFlag{
+					Name:  "role,r",
+					Value: "viewer",
+					Usage: "viewer (sees the console), operator (also flashes and controls displays) or admin (also changes the setup)",
+				},
+				cli.StringFlag{
+					Name:  "password,p",
+					Usage: "set a new password for a user",
+				},
+				cli.StringFlag{
+					Name:  "token,t",
+					Usage: "create an API token with this label for the user, printing it once",
+				},
+				cli.StringFlag{
+					Name:  "revoke",
+					Usage: "revoke the user's API token with this label",
+				},
+				cli.BoolFlag{
+					Name:  "list,l",
+					Usage: "list users and their API tokens",
+				},
+				configFlag,
+				databaseFlag,
+				serverFlag,
 			},
 		},
 		{
@@ -180,12 +434,99 @@ func main() {
 			Action: handleInstall,
 
 			Flags: []cli.Flag{
+				configFlag,
+				databaseFlag,
+			},
+		},
+		{
+			Name:    "migrate",
+			Aliases: []string{"m"},
+			Usage:   "upgrade the database schema",
+
+			Action: handleMigrate,
+
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "status,s",
+					Usage: "show the schema version and pending migrations",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run,n",
+					Usage: "list the migrations that would be applied without applying them",
+				},
+				configFlag,
+				databaseFlag,
+			},
+		},
+		{
+			Name:  "audit",
+			Usage: "show who changed what, oldest first",
+
+			Action: handleAudit,
+
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "object,o",
+					Usage: "only changes to a type of object, e.g. list, or to one object, e.g. list:Lobby",
+				},
 				cli.StringFlag{
-					Name:   "database,D",
-					Value:  "wbd.db",
-					Usage:  "sqlite database location",
-					EnvVar: "WBD_DATABASE",
+					Name:  "actor,a",
+					Usage: "only changes made by this user or session",
 				},
+				cli.StringFlag{
+					Name:  "since",
+					Usage: "only changes from this time on, e.g. 2026-01-02, \"2026-01-02 15:04\" or 24h for the last day",
+				},
+				cli.StringFlag{
+					Name:  "until",
+					Usage: "only changes before this time",
+				},
+				cli.IntFlag{
+					Name:  "limit,n",
+					Value: 50,
+					Usage: "show only the latest changes (0 shows them all)",
+				},
+				configFlag,
+				databaseFlag,
+				serverFlag,
+			},
+		},
+		{
+			Name:  "backup",
+			Usage: "take a consistent copy of the database, even while wbd is running",
+
+			Action: handleBackup,
+
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output,o",
+					Usage: "file to write the backup to (default: a timestamped file in --backup-dir)",
+				},
+				cli.BoolFlag{
+					Name:  "list,l",
+					Usage: "list the backups in --backup-dir",
+				},
+				configFlag,
+				databaseFlag,
+				backupDirFlag,
+			},
+		},
+		{
+			Name:      "restore",
+			Usage:     "replace the database with a backup (stop wbd run first)",
+			ArgsUsage: "<backup>",
+
+			Action: handleRestore,
+
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "yes,y",
+					Usage: "don't ask before replacing the database (it's still backed up first)",
+				},
+				configFlag,
+				databaseFlag,
+				serverFlag,
+				backupDirFlag,
 			},
 		},
 		{
@@ -195,15 +536,121 @@ func main() {
 			Action: handleClean,
 
 			Flags: []cli.Flag{
-				cli.StringFlag{
-					Name:   "database,D",
-					Value:  "wbd.db",
-					Usage:  "sqlite database location",
-					EnvVar: "WBD_DATABASE",
+				cli.BoolFlag{
+					Name:  "no-backup",
+					Usage: "don't offer to back up the database first",
 				},
+				configFlag,
+				databaseFlag,
+				backupDirFlag,
 			},
 		},
 	}
 
 	app.Run(os.Args)
 }
+
+// Settings are taken from flags, then environment variables, then the
+// configuration file, then the defaults in the config package. Flag values
+// are only used when they're set, so none of these have a Value.
+var (
+	configFlag = cli.StringFlag{
+		Name:   "config",
+		Usage:  "configuration file (default: the first of wbd.yaml, ~/.config/wbd/wbd.yaml and /etc/wbd/wbd.yaml that exists)",
+		EnvVar: "WBD_CONFIG",
+	}
+	databaseFlag = cli.StringFlag{
+		Name:   "database,D",
+		Usage:  "sqlite database location (default: \"wbd.db\")",
+		EnvVar: "WBD_DATABASE",
+	}
+	serverFlag = cli.StringFlag{
+		Name:   "server,S",
+		Usage:  "admin socket of the wbd daemon (default: the database location + \".sock\")",
+		EnvVar: "WBD_SERVER",
+	}
+	backupDirFlag = cli.StringFlag{
+		Name:   "backup-dir",
+		Usage:  "directory backups go in (default: next to the database)",
+		EnvVar: "WBD_BACKUP_DIR",
+	}
+)
+
+// runFlags are the daemon's settings. `config show` takes them too, so that
+// it shows what `run` would do with the same arguments.
+var runFlags = []cli.Flag{
+	configFlag,
+	databaseFlag,
+	serverFlag,
+	cli.StringFlag{
+		Name:   "url,w",
+		Usage:  "path to serve wbd under (e.g. \"/wbd\")",
+		EnvVar: "WBD_URL",
+	},
+	cli.StringFlag{
+		Name:   "listen,l",
+		Usage:  "ip address to listen on (default: \"0.0.0.0\")",
+		EnvVar: "WBD_LISTEN",
+	},
+	cli.IntFlag{
+		Name:   "port,p",
+		Usage:  "port to listen on (default: 80, or 443 when serving HTTPS)",
+		EnvVar: "WBD_PORT",
+	},
+	cli.StringFlag{
+		Name:   "trusted-proxies",
+		Usage:  "comma separated addresses or CIDR networks of proxies whose X-Forwarded-For, -Proto and -Host headers are believed",
+		EnvVar: "WBD_TRUSTED_PROXIES",
+	},
+	cli.StringFlag{
+		Name:   "tls-cert",
+		Usage:  "serve HTTPS using this certificate file",
+		EnvVar: "WBD_TLS_CERT",
+	},
+	cli.StringFlag{
+		Name:   "tls-key",
+		Usage:  "private key file for --tls-cert",
+		EnvVar: "WBD_TLS_KEY",
+	},
+	cli.IntFlag{
+		Name:   "redirect-port",
+		Usage:  "when serving HTTPS, also listen on this port and redirect plain HTTP to HTTPS (e.g. 80)",
+		EnvVar: "WBD_REDIRECT_PORT",
+	},
+	cli.DurationFlag{
+		Name:   "rotation-duration",
+		Usage:  "how long displays show URLs that don't have a duration of their own (default: 1m)",
+		EnvVar: "WBD_ROTATION_DURATION",
+	},
+	cli.DurationFlag{
+		Name:   "poll-interval",
+		Usage:  "how often to check the database for changes made outside the daemon (default: 1s)",
+		EnvVar: "WBD_POLL_INTERVAL",
+	},
+	cli.BoolFlag{
+		Name:   "require-approval",
+		Usage:  "only show lists to clients an admin has approved; others see the welcome page",
+		EnvVar: "WBD_REQUIRE_APPROVAL",
+	},
+	cli.StringFlag{
+		Name:   "enrollment-token",
+		Usage:  "approve clients straight away when their URL has ?enroll= this token",
+		EnvVar: "WBD_ENROLLMENT_TOKEN",
+	},
+	cli.DurationFlag{
+		Name:   "session-lifetime",
+		Usage:  "how long console logins last (default: 24h)",
+		EnvVar: "WBD_SESSION_LIFETIME",
+	},
+	cli.DurationFlag{
+		Name:   "backup-interval",
+		Usage:  "back up the database this often while running (e.g. 24h; default: never)",
+		EnvVar: "WBD_BACKUP_INTERVAL",
+	},
+	backupDirFlag,
+	cli.IntFlag{
+		Name:   "backup-keep",
+		Usage:  "how many scheduled backups to keep, 0 for all (default: 7)",
+		EnvVar: "WBD_BACKUP_KEEP",
+	},
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/barracudanetworks/wbd/database"
+
+	"gopkg.in/yaml.v3"
+)
+
+var ErrInvalidFormat = errors.New("Format must be json or yaml")
+
+// snapshotFormat picks the format to write a snapshot in: the one asked for,
+// else the one the file name suggests, else YAML
+func snapshotFormat(format string, path string) (string, error) {
+	if format == "" {
+		format = "yaml"
+		if strings.HasSuffix(path, ".json") {
+			format = "json"
+		}
+	}
+
+	switch format {
+	case "json", "yaml":
+		return format, nil
+	}
+
+	return "", ErrInvalidFormat
+}
+
+func encodeSnapshot(s database.Snapshot, format string) (out []byte, err error) {
+	if format == "json" {
+		out, err = json.MarshalIndent(s, "", "  ")
+		return append(out, '\n'), err
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err = encoder.Encode(s); err != nil {
+		return
+	}
+
+	return buf.Bytes(), encoder.Close()
+}
+
+// decodeSnapshot reads a snapshot written in either format. Unknown fields
+// are an error, so that typos in hand edited files don't go unnoticed.
+func decodeSnapshot(data []byte) (s database.Snapshot, err error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&s)
+		return
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&s)
+	return
+}
+
+// readInput reads a file, or standard input if path is "-"
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+
+	return ioutil.ReadFile(path)
+}
@@ -0,0 +1,37 @@
+package web
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/barracudanetworks/wbd/admin"
+)
+
+// ServeAdmin answers CLI commands on a Unix socket, so they can make
+// changes through the daemon and see who's connected
+func (a *App) ServeAdmin(path string) {
+	l, err := admin.Listen(path)
+	if err != nil {
+		log.Fatalf("Can't open admin socket %s: %s", path, err)
+	}
+
+	// Don't leave the socket behind when we're stopped
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		l.Close()
+		os.Exit(0)
+	}()
+
+	server := &admin.Server{
+		Database:    a.Database,
+		Changed:     hub.Changed,
+		Connections: hub.Connections,
+	}
+
+	log.Printf("Admin socket listening on %s", path)
+	log.Fatal(server.Serve(l))
+}
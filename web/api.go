@@ -0,0 +1,714 @@
+package web
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/barracudanetworks/wbd/admin"
+	"github.com/barracudanetworks/wbd/database"
+
+	"github.com/gorilla/mux"
+)
+
+const apiPrefix = "/api/v1"
+
+type apiHandler struct{ App }
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type apiUrl struct {
+	Url string `json:"url"`
+}
+
+type apiListUrl struct {
+	Url      string `json:"url"`
+	Duration *int   `json:"duration,omitempty"`
+	Position *int   `json:"position,omitempty"`
+}
+
+type apiList struct {
+	Name string             `json:"name"`
+	Mode string             `json:"mode"`
+	Urls []database.ListUrl `json:"urls"`
+}
+
+type apiClient struct {
+	database.Client
+	List   string                 `json:"list"`
+	Group  string                 `json:"group"`
+	Status *database.ClientStatus `json:"status,omitempty"`
+}
+
+type apiAlias struct {
+	Alias string `json:"alias"`
+}
+
+type apiApproval struct {
+	Approval string `json:"approval"`
+}
+
+type apiAssignment struct {
+	List string `json:"list"`
+}
+
+// apiFlash leaves out the duration to use the default
+type apiFlash struct {
+	Url      string `json:"url"`
+	Duration *int   `json:"duration,omitempty"`
+	Client   string `json:"client,omitempty"`
+	List     string `json:"list,omitempty"`
+}
+
+// RegisterApi mounts the JSON API on the router
+func (a *App) RegisterApi(r *mux.Router) {
+	api := &apiHandler{*a}
+	s := r.PathPrefix(apiPrefix).Subrouter()
+
+	// Each request gets a handler whose changes are recorded in the audit
+	// log as made by whoever sent it
+	route := func(path string, role database.Role, f func(*apiHandler, http.ResponseWriter, *http.Request), methods ...string) {
+		s.Handle(path, a.RequireApiAuth(role, notifyHub(func(w http.ResponseWriter, r *http.Request) {
+			ah := &apiHandler{*a}
+			ah.Database = a.Database.As(a.Actor(r))
+			f(ah, w, r)
+		}))).Methods(methods...)
+	}
+
+	s.HandleFunc("/openapi.json", api.openApi).Methods("GET")
+
+	route("/urls", database.RoleViewer, (*apiHandler).listUrls, "GET")
+	route("/urls", database.RoleAdmin, (*apiHandler).createUrl, "POST")
+	route("/urls", database.RoleAdmin, (*apiHandler).deleteUrl, "DELETE")
+
+	route("/lists", database.RoleViewer, (*apiHandler).listLists, "GET")
+	route("/lists", database.RoleAdmin, (*apiHandler).createList, "POST")
+	route("/lists/{name}", database.RoleViewer, (*apiHandler).getList, "GET")
+	route("/lists/{name}", database.RoleAdmin, (*apiHandler).updateList, "PUT")
+	route("/lists/{name}", database.RoleAdmin, (*apiHandler).deleteList, "DELETE")
+	route("/lists/{name}/urls", database.RoleViewer, (*apiHandler).listListUrls, "GET")
+	route("/lists/{name}/urls", database.RoleAdmin, (*apiHandler).assignUrl, "POST")
+	route("/lists/{name}/urls", database.RoleAdmin, (*apiHandler).removeUrl, "DELETE")
+
+	route("/clients", database.RoleViewer, (*apiHandler).listClients, "GET")
+	route("/clients/{id}", database.RoleViewer, (*apiHandler).getClient, "GET")
+	route("/clients/{id}", database.RoleAdmin, (*apiHandler).deleteClient, "DELETE")
+	route("/clients/{id}/alias", database.RoleAdmin, (*apiHandler).setAlias, "PUT")
+	route("/clients/{id}/approval", database.RoleAdmin, (*apiHandler).setApproval, "PUT")
+	route("/clients/{id}/list", database.RoleAdmin, (*apiHandler).assignClient, "PUT")
+	route("/clients/{id}/list", database.RoleAdmin, (*apiHandler).removeClient, "DELETE")
+
+	route("/connections", database.RoleViewer, (*apiHandler).listConnections, "GET")
+
+	route("/flash", database.RoleOperator, (*apiHandler).flash, "POST")
+	route("/control", database.RoleOperator, (*apiHandler).control, "POST")
+}
+
+// RequireApiAuth rejects requests from anyone not logged in, or whose role
+// doesn't allow what they asked for, with a JSON error
+func (a *App) RequireApiAuth(role database.Role, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("API %s %s from %s", r.Method, r.URL.Path, a.GetClient(r).RemoteAddr)
+
+		l, ok := a.authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="wbd"`)
+			writeError(w, http.StatusUnauthorized, "Login required")
+			return
+		}
+
+		if !l.Role.Allows(role) {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("This needs the %s role", role))
+			return
+		}
+
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), loginKey{}, l)))
+	})
+}
+
+// notifyHub lets displays see changes made through the API straight away
+func notifyHub(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r)
+
+		if r.Method != "GET" {
+			hub.Changed()
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if v == nil {
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{message})
+}
+
+// writeDatabaseError maps database errors onto status codes, treating missing
+// rows as the given resource not being found
+func writeDatabaseError(w http.ResponseWriter, err error, resource string) {
+	switch err {
+	case sql.ErrNoRows:
+		writeError(w, http.StatusNotFound, resource+" not found")
+	case database.ErrUrlExists, database.ErrListExists:
+		writeError(w, http.StatusConflict, err.Error())
+	case database.ErrDefaultList, database.ErrNegativeDuration, database.ErrInvalidMode:
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		log.Println(err)
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+		return false
+	}
+
+	return true
+}
+
+// emptyIfNil prevents empty slices from being marshalled into null
+func emptyIfNil(s []string) []string {
+	if s == nil {
+		return make([]string, 0)
+	}
+
+	return s
+}
+
+func emptyListUrlsIfNil(s []database.ListUrl) []database.ListUrl {
+	if s == nil {
+		return make([]database.ListUrl, 0)
+	}
+
+	return s
+}
+
+func (ah *apiHandler) openApi(w http.ResponseWriter, r *http.Request) {
+	// The API moves along with the base path
+	server, _ := json.Marshal(ah.Address + apiPrefix)
+	spec := strings.Replace(openApiSpec, `"url": "`+apiPrefix+`"`, `"url": `+string(server), 1)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(spec))
+}
+
+func (ah *apiHandler) listUrls(w http.ResponseWriter, r *http.Request) {
+	urls, err := ah.Database.FetchUrls()
+	if err != nil {
+		writeDatabaseError(w, err, "URL")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, emptyIfNil(urls))
+}
+
+func (ah *apiHandler) createUrl(w http.ResponseWriter, r *http.Request) {
+	var body apiUrl
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	if body.Url == "" {
+		writeError(w, http.StatusBadRequest, "Missing url")
+		return
+	}
+
+	if err := ah.Database.InsertUrl(body.Url); err != nil {
+		writeDatabaseError(w, err, "URL")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, body)
+}
+
+func (ah *apiHandler) deleteUrl(w http.ResponseWriter, r *http.Request) {
+	url := r.FormValue("url")
+	if url == "" {
+		writeError(w, http.StatusBadRequest, "Missing url parameter")
+		return
+	}
+
+	if _, err := ah.Database.FindUrlId(url); err != nil {
+		writeDatabaseError(w, err, "URL")
+		return
+	}
+
+	if err := ah.Database.DeleteUrl(url); err != nil {
+		writeDatabaseError(w, err, "URL")
+		return
+	}
+
+	writeJSON(w, http.StatusNoContent, nil)
+}
+
+func (ah *apiHandler) fetchList(name string) (list apiList, err error) {
+	info, err := ah.Database.GetList(name)
+	if err != nil {
+		return
+	}
+
+	urls, err := ah.Database.FetchListUrlsById(info.Id)
+	if err != nil {
+		return
+	}
+
+	list = apiList{
+		Name: info.Name,
+		Mode: info.Mode,
+		Urls: emptyListUrlsIfNil(urls),
+	}
+
+	return
+}
+
+func (ah *apiHandler) listLists(w http.ResponseWriter, r *http.Request) {
+	names, err := ah.Database.FetchLists()
+	if err != nil {
+		writeDatabaseError(w, err, "List")
+		return
+	}
+
+	lists := make([]apiList, 0, len(names))
+	for _, name := range names {
+		list, err := ah.fetchList(name)
+		if err != nil {
+			writeDatabaseError(w, err, "List")
+			return
+		}
+
+		lists = append(lists, list)
+	}
+
+	writeJSON(w, http.StatusOK, lists)
+}
+
+func (ah *apiHandler) createList(w http.ResponseWriter, r *http.Request) {
+	var body apiList
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	if body.Name == "" {
+		writeError(w, http.StatusBadRequest, "Missing name")
+		return
+	}
+
+	if err := ah.Database.InsertList(body.Name); err != nil {
+		writeDatabaseError(w, err, "List")
+		return
+	}
+
+	if body.Mode != "" {
+		if err := ah.Database.SetListMode(body.Name, body.Mode); err != nil {
+			writeDatabaseError(w, err, "List")
+			return
+		}
+	}
+
+	list, err := ah.fetchList(body.Name)
+	if err != nil {
+		writeDatabaseError(w, err, "List")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, list)
+}
+
+func (ah *apiHandler) getList(w http.ResponseWriter, r *http.Request) {
+	list, err := ah.fetchList(mux.Vars(r)["name"])
+	if err != nil {
+		writeDatabaseError(w, err, "List")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (ah *apiHandler) updateList(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	var body apiList
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	if body.Mode != "" {
+		if err := ah.Database.SetListMode(name, body.Mode); err != nil {
+			writeDatabaseError(w, err, "List")
+			return
+		}
+	}
+
+	list, err := ah.fetchList(name)
+	if err != nil {
+		writeDatabaseError(w, err, "List")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (ah *apiHandler) deleteList(w http.ResponseWriter, r *http.Request) {
+	if err := ah.Database.DeleteList(mux.Vars(r)["name"]); err != nil {
+		writeDatabaseError(w, err, "List")
+		return
+	}
+
+	writeJSON(w, http.StatusNoContent, nil)
+}
+
+func (ah *apiHandler) listListUrls(w http.ResponseWriter, r *http.Request) {
+	list, err := ah.fetchList(mux.Vars(r)["name"])
+	if err != nil {
+		writeDatabaseError(w, err, "List")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, list.Urls)
+}
+
+func (ah *apiHandler) assignUrl(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	var body apiListUrl
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	// Nothing may change if the duration is refused
+	if body.Duration != nil && *body.Duration < 0 {
+		writeError(w, http.StatusBadRequest, database.ErrNegativeDuration.Error())
+		return
+	}
+
+	if _, err := ah.Database.FindListId(name); err != nil {
+		writeDatabaseError(w, err, "List")
+		return
+	}
+
+	placement := database.UrlPlacement{Position: body.Position, Duration: body.Duration}
+	if err := ah.Database.PlaceUrlInList(name, body.Url, placement); err != nil {
+		writeDatabaseError(w, err, "URL")
+		return
+	}
+
+	list, err := ah.fetchList(name)
+	if err != nil {
+		writeDatabaseError(w, err, "List")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, list)
+}
+
+func (ah *apiHandler) removeUrl(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	url := r.FormValue("url")
+	if url == "" {
+		writeError(w, http.StatusBadRequest, "Missing url parameter")
+		return
+	}
+
+	if _, err := ah.Database.FindListId(name); err != nil {
+		writeDatabaseError(w, err, "List")
+		return
+	}
+
+	if err := ah.Database.RemoveUrlFromList(name, url); err != nil {
+		writeDatabaseError(w, err, "URL")
+		return
+	}
+
+	writeJSON(w, http.StatusNoContent, nil)
+}
+
+func (ah *apiHandler) fetchClient(identifier string) (client apiClient, err error) {
+	client.Client, err = ah.Database.GetClient(identifier)
+	if err != nil {
+		return
+	}
+
+	list, err := ah.Database.GetClientList(client.Identifier)
+	if err != nil {
+		return
+	}
+	client.List = list.Name
+
+	if client.GroupId != database.NoGroup {
+		group, err := ah.Database.GetGroupById(client.GroupId)
+		if err != nil {
+			return client, err
+		}
+		client.Group = group.Name
+	}
+
+	status, err := ah.Database.GetClientStatus(client.Identifier)
+	if err == sql.ErrNoRows {
+		return client, nil
+	}
+	if err != nil {
+		return
+	}
+	client.Status = &status
+
+	return
+}
+
+func (ah *apiHandler) listClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := ah.Database.FetchClients()
+	if err != nil {
+		writeDatabaseError(w, err, "Client")
+		return
+	}
+
+	result := make([]apiClient, 0, len(clients))
+	for _, c := range clients {
+		client, err := ah.fetchClient(c.Identifier)
+		if err != nil {
+			writeDatabaseError(w, err, "Client")
+			return
+		}
+
+		result = append(result, client)
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (ah *apiHandler) getClient(w http.ResponseWriter, r *http.Request) {
+	client, err := ah.fetchClient(mux.Vars(r)["id"])
+	if err != nil {
+		writeDatabaseError(w, err, "Client")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, client)
+}
+
+func (ah *apiHandler) deleteClient(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if _, err := ah.Database.GetClient(id); err != nil {
+		writeDatabaseError(w, err, "Client")
+		return
+	}
+
+	if err := ah.Database.DeleteClient(id); err != nil {
+		writeDatabaseError(w, err, "Client")
+		return
+	}
+
+	writeJSON(w, http.StatusNoContent, nil)
+}
+
+func (ah *apiHandler) setAlias(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var body apiAlias
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	client, err := ah.Database.GetClient(id)
+	if err != nil {
+		writeDatabaseError(w, err, "Client")
+		return
+	}
+
+	if err := ah.Database.SetClientAlias(client.Identifier, body.Alias); err != nil {
+		writeDatabaseError(w, err, "Client")
+		return
+	}
+
+	updated, err := ah.fetchClient(client.Identifier)
+	if err != nil {
+		writeDatabaseError(w, err, "Client")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (ah *apiHandler) setApproval(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var body apiApproval
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	client, err := ah.Database.GetClient(id)
+	if err != nil {
+		writeDatabaseError(w, err, "Client")
+		return
+	}
+
+	switch body.Approval {
+	case database.ApprovalApproved:
+		err = ah.Database.ApproveClient(client.Identifier)
+	case database.ApprovalRejected:
+		err = ah.Database.RejectClient(client.Identifier)
+	default:
+		writeError(w, http.StatusBadRequest, "Approval must be approved or rejected")
+		return
+	}
+	if err != nil {
+		writeDatabaseError(w, err, "Client")
+		return
+	}
+
+	updated, err := ah.fetchClient(client.Identifier)
+	if err != nil {
+		writeDatabaseError(w, err, "Client")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (ah *apiHandler) assignClient(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var body apiAssignment
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	client, err := ah.Database.GetClient(id)
+	if err != nil {
+		writeDatabaseError(w, err, "Client")
+		return
+	}
+
+	if err := ah.Database.AssignClientToList(body.List, client.Identifier); err != nil {
+		writeDatabaseError(w, err, "List")
+		return
+	}
+
+	updated, err := ah.fetchClient(client.Identifier)
+	if err != nil {
+		writeDatabaseError(w, err, "Client")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (ah *apiHandler) removeClient(w http.ResponseWriter, r *http.Request) {
+	client, err := ah.Database.GetClient(mux.Vars(r)["id"])
+	if err != nil {
+		writeDatabaseError(w, err, "Client")
+		return
+	}
+
+	if err := ah.Database.RemoveClientFromList(client.Identifier); err != nil {
+		writeDatabaseError(w, err, "Client")
+		return
+	}
+
+	updated, err := ah.fetchClient(client.Identifier)
+	if err != nil {
+		writeDatabaseError(w, err, "Client")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (ah *apiHandler) listConnections(w http.ResponseWriter, r *http.Request) {
+	connections := hub.Connections()
+	if connections == nil {
+		connections = make([]admin.Connection, 0)
+	}
+
+	writeJSON(w, http.StatusOK, connections)
+}
+
+// checkTarget makes sure the client and list a message is aimed at exist
+func (ah *apiHandler) checkTarget(w http.ResponseWriter, client string, list string) bool {
+	if client != "" {
+		if _, err := ah.Database.GetClient(client); err != nil {
+			writeDatabaseError(w, err, "Client")
+			return false
+		}
+	}
+
+	if list != "" {
+		if _, err := ah.Database.FindListId(list); err != nil {
+			writeDatabaseError(w, err, "List")
+			return false
+		}
+	}
+
+	return true
+}
+
+func (ah *apiHandler) flash(w http.ResponseWriter, r *http.Request) {
+	var body apiFlash
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	if body.Url == "" {
+		writeError(w, http.StatusBadRequest, "A URL is required")
+		return
+	}
+
+	flash := flashRequest{body.Url, defaultFlashDuration, body.Client, body.List}
+	if body.Duration != nil {
+		if *body.Duration <= 0 {
+			writeError(w, http.StatusBadRequest, "Duration must be positive")
+			return
+		}
+
+		flash.Duration = *body.Duration
+	}
+
+	if !ah.checkTarget(w, flash.Client, flash.List) {
+		return
+	}
+
+	hub.broadcast <- flash.hubMessage()
+
+	writeJSON(w, http.StatusAccepted, flash)
+}
+
+func (ah *apiHandler) control(w http.ResponseWriter, r *http.Request) {
+	var body controlRequest
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	if !isControlAction(body.Action) {
+		writeError(w, http.StatusBadRequest, ErrInvalidControl.Error())
+		return
+	}
+
+	if !ah.checkTarget(w, body.Client, body.List) {
+		return
+	}
+
+	hub.broadcast <- body.hubMessage()
+
+	writeJSON(w, http.StatusAccepted, body)
+}
@@ -0,0 +1,197 @@
+package web
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/barracudanetworks/wbd/database"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// apiRequest sends a request through the API's router, as name if given
+func apiRequest(method string, path string, body string, name string) *httptest.ResponseRecorder {
+	r := mux.NewRouter()
+	testApp.RegisterApi(r)
+
+	req := httptest.NewRequest(method, apiPrefix+path, strings.NewReader(body))
+	if name != "" {
+		req.SetBasicAuth(name, "secret")
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestApiRoles(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testApp.Database
+	assert.Nil(db.InsertUser("api-admin", database.RoleAdmin, "secret"))
+	assert.Nil(db.InsertUser("api-operator", database.RoleOperator, "secret"))
+	assert.Nil(db.InsertUser("api-viewer", database.RoleViewer, "secret"))
+	defer db.DeleteUser("api-admin")
+	defer db.DeleteUser("api-operator")
+	defer db.DeleteUser("api-viewer")
+
+	routes := []struct {
+		method string
+		path   string
+		body   string
+		role   database.Role
+	}{
+		{"GET", "/urls", "", database.RoleViewer},
+		{"POST", "/urls", `{"url": "http://roles.example.com/"}`, database.RoleAdmin},
+		{"DELETE", "/urls", `{"url": "http://missing.example.com/"}`, database.RoleAdmin},
+		{"GET", "/lists", "", database.RoleViewer},
+		{"POST", "/lists", `{"name": "Roles"}`, database.RoleAdmin},
+		{"GET", "/lists/Default", "", database.RoleViewer},
+		{"PUT", "/lists/Default", `{"mode": "sequential"}`, database.RoleAdmin},
+		{"DELETE", "/lists/Missing", "", database.RoleAdmin},
+		{"GET", "/lists/Default/urls", "", database.RoleViewer},
+		{"POST", "/lists/Missing/urls", `{"url": "http://roles.example.com/"}`, database.RoleAdmin},
+		{"DELETE", "/lists/Missing/urls", `{"url": "http://roles.example.com/"}`, database.RoleAdmin},
+		{"GET", "/clients", "", database.RoleViewer},
+		{"GET", "/clients/missing", "", database.RoleViewer},
+		{"DELETE", "/clients/missing", "", database.RoleAdmin},
+		{"PUT", "/clients/missing/alias", `{"alias": "roles"}`, database.RoleAdmin},
+		{"PUT", "/clients/missing/approval", `{"approval": "approved"}`, database.RoleAdmin},
+		{"PUT", "/clients/missing/list", `{"list": "Default"}`, database.RoleAdmin},
+		{"DELETE", "/clients/missing/list", "", database.RoleAdmin},
+		{"GET", "/connections", "", database.RoleViewer},
+		{"POST", "/flash", `{"url": "http://example.com/", "duration": 5, "client": "missing"}`, database.RoleOperator},
+		{"POST", "/control", `{"action": "next", "client": "missing"}`, database.RoleOperator},
+	}
+
+	users := map[string]database.Role{
+		"api-viewer":   database.RoleViewer,
+		"api-operator": database.RoleOperator,
+		"api-admin":    database.RoleAdmin,
+	}
+
+	for _, route := range routes {
+		w := apiRequest(route.method, route.path, route.body, "")
+		assert.Equal(http.StatusUnauthorized, w.Code, "%s %s without logging in", route.method, route.path)
+
+		for name, role := range users {
+			w := apiRequest(route.method, route.path, route.body, name)
+			if role.Allows(route.role) {
+				assert.NotContains([]int{http.StatusUnauthorized, http.StatusForbidden}, w.Code, "%s %s as %s", route.method, route.path, role)
+			} else {
+				assert.Equal(http.StatusForbidden, w.Code, "%s %s as %s", route.method, route.path, role)
+			}
+		}
+	}
+
+	// The console's users are logged in, so they're told they lack the
+	// role rather than sent back to log in
+	token, err := testApp.Sessions.Create("api-viewer")
+	assert.Nil(err)
+
+	r := mux.NewRouter()
+	testApp.RegisterApi(r)
+	req := httptest.NewRequest("POST", apiPrefix+"/flash", strings.NewReader(`{"url": "http://example.com/"}`))
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: token})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(http.StatusForbidden, w.Code)
+	assert.Contains(w.Body.String(), "This needs the operator role")
+}
+
+func TestForwardedFor(t *testing.T) {
+	assert := assert.New(t)
+
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	app := *testApp
+	app.TrustedProxies = []*net.IPNet{proxies}
+
+	// Anyone else's forwarded headers are ignored
+	r := httptest.NewRequest("GET", "/?client=spoofed", nil)
+	r.RemoteAddr = "203.0.113.5:4000"
+	r.Header.Set("X-Forwarded-For", "10.0.0.9")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	assert.Equal("203.0.113.5", app.GetClient(r).RemoteAddr)
+	assert.Equal("http", app.Scheme(r))
+
+	// Behind a proxy, an address the client made up is skipped over
+	r.RemoteAddr = "10.0.0.2:4000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.5")
+	assert.Equal("203.0.113.5", app.GetClient(r).RemoteAddr)
+	assert.Equal("https", app.Scheme(r))
+
+	r.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.5, 10.0.0.3")
+	assert.Equal("203.0.113.5", app.GetClient(r).RemoteAddr)
+}
+
+func TestPendingClientUrls(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testApp.Database
+	assert.Nil(db.InsertUrl("http://pending.example.com/"))
+	assert.Nil(db.AssignUrlToList("Default", "http://pending.example.com/"))
+	defer func() {
+		db.DeleteClient("pending-display")
+		db.RemoveUrlFromList("Default", "http://pending.example.com/")
+		db.DeleteUrl("http://pending.example.com/")
+	}()
+
+	setApproval := func(required bool) {
+		hub.inspect <- func() {
+			hub.requireApproval = required
+		}
+	}
+	setApproval(true)
+	defer setApproval(false)
+
+	server := httptest.NewServer(testApp.Route("websocket"))
+	defer server.Close()
+
+	ws := dial(t, server, "client=pending-display", nil)
+	defer ws.Close()
+
+	// Waiting for approval, it's given nothing to show
+	assert.Nil(ws.WriteJSON(websocketMessage{"sendUrls", nil}))
+	update := readAction(t, ws, "updateUrls").Data.(map[string]interface{})
+	assert.Empty(update["urls"])
+
+	client, err := db.GetClient("pending-display")
+	assert.Nil(err)
+	assert.Equal(database.ApprovalPending, client.Approval)
+
+	w := apiRequest("PUT", "/clients/pending-display/approval", `{"approval": "approved"}`, "")
+	assert.Equal(http.StatusOK, w.Code)
+
+	update = readAction(t, ws, "updateUrls").Data.(map[string]interface{})
+	assert.NotEmpty(update["urls"])
+}
+
+func TestAssignNegativeDuration(t *testing.T) {
+	assert := assert.New(t)
+
+	w := apiRequest("POST", "/lists/Default/urls", `{"url": "http://negative.example.com/", "duration": -5}`, "")
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Contains(w.Body.String(), database.ErrNegativeDuration.Error())
+
+	// Nothing was added on the way
+	_, err := testApp.Database.FindUrlId("http://negative.example.com/")
+	assert.NotNil(err)
+}
+
+func TestFlashDuration(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, duration := range []string{"0", "-5"} {
+		w := apiRequest("POST", "/flash", `{"url": "http://example.com/", "duration": `+duration+`}`, "")
+		assert.Equal(http.StatusBadRequest, w.Code, "Flashing for %s seconds", duration)
+	}
+
+	// Leaving it out gets the default
+	w := apiRequest("POST", "/flash", `{"url": "http://example.com/"}`, "")
+	assert.Equal(http.StatusAccepted, w.Code)
+	assert.Contains(w.Body.String(), `"duration":60`)
+}
@@ -0,0 +1,91 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The pages and everything they load are built into the binary, so displays
+// never need to reach the internet
+
+//go:embed templates/*.html
+var templateFiles embed.FS
+
+//go:embed static
+var staticFiles embed.FS
+
+// A staticAsset is served under /static/ with a version that changes along
+// with its content, so browsers can cache it for good
+type staticAsset struct {
+	content []byte
+	version string
+}
+
+var staticAssets = loadStaticAssets()
+
+func loadStaticAssets() map[string]staticAsset {
+	assets := make(map[string]staticAsset)
+
+	files, err := staticFiles.ReadDir("static")
+	if err != nil {
+		panic(err)
+	}
+
+	for _, f := range files {
+		content, err := staticFiles.ReadFile("static/" + f.Name())
+		if err != nil {
+			panic(err)
+		}
+
+		sum := sha256.Sum256(content)
+		assets[f.Name()] = staticAsset{content, hex.EncodeToString(sum[:6])}
+	}
+
+	return assets
+}
+
+// parseTemplates loads the pages, which link to static assets with
+// {{ static "name" }}
+func (a *App) parseTemplates() (*template.Template, error) {
+	funcs := template.FuncMap{
+		"static": func(name string) (string, error) {
+			asset, ok := staticAssets[name]
+			if !ok {
+				return "", fmt.Errorf("No static asset named %s", name)
+			}
+
+			return fmt.Sprintf("%s/static/%s?v=%s", a.Address, name, asset.version), nil
+		},
+	}
+
+	return template.New("").Funcs(funcs).ParseFS(templateFiles, "templates/*.html")
+}
+
+func serveStatic(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	asset, ok := staticAssets[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Links from the templates carry the version; anything else has to
+	// check back
+	if r.URL.Query().Get("v") == asset.version {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Header().Set("ETag", `"`+asset.version+`"`)
+
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(asset.content))
+}
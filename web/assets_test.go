@@ -0,0 +1,47 @@
+package web
+
+import (
+	"embed"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Pages must work without internet access, so nothing may be loaded from
+// elsewhere
+func TestNoExternalAssets(t *testing.T) {
+	assert := assert.New(t)
+
+	external := regexp.MustCompile(`(?i)(src|href)\s*=\s*['"]?(https?:)?//|url\(\s*['"]?(https?:)?//|@import`)
+
+	check := func(files embed.FS, dir string) {
+		entries, err := files.ReadDir(dir)
+		assert.Nil(err)
+
+		for _, entry := range entries {
+			content, err := files.ReadFile(dir + "/" + entry.Name())
+			assert.Nil(err)
+			assert.False(external.Match(content), "%s/%s loads something from another site", dir, entry.Name())
+		}
+	}
+
+	check(templateFiles, "templates")
+	check(staticFiles, "static")
+}
+
+func TestTemplates(t *testing.T) {
+	assert := assert.New(t)
+
+	a := &App{Address: "/wbd"}
+	templates, err := a.parseTemplates()
+	assert.Nil(err)
+
+	for _, name := range []string{"index.html", "welcome.html", "login.html", "console.html"} {
+		assert.NotNil(templates.Lookup(name), "missing template %s", name)
+	}
+
+	asset, ok := staticAssets["display.js"]
+	assert.True(ok)
+	assert.NotEqual("", asset.version)
+}
@@ -0,0 +1,202 @@
+package web
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barracudanetworks/wbd/database"
+)
+
+const sessionCookie = "wbd_session"
+
+type sessionStore struct {
+	sync.Mutex
+	sessions map[string]session
+
+	// How long a login lasts
+	lifetime time.Duration
+}
+
+// A session remembers who logged in. Their role is looked up afresh on every
+// request, so changes apply straight away.
+type session struct {
+	user    string
+	expires time.Time
+}
+
+func newSessionStore(lifetime time.Duration) *sessionStore {
+	return &sessionStore{
+		sessions: make(map[string]session),
+		lifetime: lifetime,
+	}
+}
+
+func (s *sessionStore) Create(user string) (token string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	token = hex.EncodeToString(b)
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.sessions[token] = session{user, time.Now().Add(s.lifetime)}
+
+	return
+}
+
+// User returns who a session belongs to, if it hasn't expired
+func (s *sessionStore) User(token string) (user string, ok bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	session, ok := s.sessions[token]
+	if !ok {
+		return
+	}
+
+	if time.Now().After(session.expires) {
+		delete(s.sessions, token)
+		return "", false
+	}
+
+	return session.user, true
+}
+
+func (s *sessionStore) Delete(token string) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.sessions, token)
+}
+
+// A login is who made a request, and how they proved it
+type login struct {
+	database.User
+
+	// Source is how they logged in: the console, or the API with a password
+	// or token
+	Source string
+}
+
+// authenticate works out who made a request, from a console session, an API
+// token sent as a bearer token, or a user's name and password sent with
+// HTTP basic auth. Until the first user is added, everyone is an admin.
+func (a *App) authenticate(r *http.Request) (l login, ok bool) {
+	found, err := a.Database.HasUsers()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if !found {
+		return login{database.User{Role: database.RoleAdmin}, database.SourceApi}, true
+	}
+
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		if name, valid := a.Sessions.User(cookie.Value); valid {
+			user, err := a.Database.GetUser(name)
+			if err != nil {
+				return
+			}
+
+			return login{user, database.SourceConsole}, true
+		}
+	}
+
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		user, name, valid, err := a.Database.CheckToken(strings.TrimPrefix(auth, "Bearer "))
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		return login{user, database.SourceApi + " token " + name}, valid
+	}
+
+	if name, password, found := r.BasicAuth(); found {
+		user, valid, err := a.Database.CheckUser(name, password)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		return login{user, database.SourceApi}, valid
+	}
+
+	return
+}
+
+// Role is what the user making a request may do, or empty if they aren't
+// logged in
+func (a *App) Role(r *http.Request) database.Role {
+	l, ok := a.authenticate(r)
+	if !ok {
+		return ""
+	}
+
+	return l.Role
+}
+
+// Enrolled reports whether token is the enrollment token, which approves the
+// displays that bring it
+func (a *App) Enrolled(token string) bool {
+	return a.EnrollmentToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.EnrollmentToken)) == 1
+}
+
+// loginKey is where RequireApiAuth leaves the request's login in its context
+type loginKey struct{}
+
+// Actor is who a request's changes are recorded as made by in the audit log
+func (a *App) Actor(r *http.Request) database.Actor {
+	l, ok := r.Context().Value(loginKey{}).(login)
+	if !ok {
+		l, _ = a.authenticate(r)
+	}
+
+	return database.Actor{Name: l.Name, Source: l.Source, Address: a.GetClient(r).RemoteAddr}
+}
+
+// RequireRole redirects requests from anyone not logged in to the login
+// page, and turns away users whose role doesn't allow what they asked for
+func (a *App) RequireRole(role database.Role, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l, ok := a.authenticate(r)
+		if !ok {
+			http.Redirect(w, r, a.Address+"/login?next="+url.QueryEscape(r.URL.Path), http.StatusSeeOther)
+			return
+		}
+
+		if !l.Role.Allows(role) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (a *App) cookiePath() string {
+	if a.Address == "" {
+		return "/"
+	}
+
+	return a.Address
+}
+
+// Only redirect to local paths after logging in
+func (a *App) redirectTarget(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return a.Address + "/console"
+	}
+
+	return next
+}
@@ -0,0 +1,39 @@
+package web
+
+import (
+	"log"
+	"time"
+
+	"github.com/barracudanetworks/wbd/config"
+	"github.com/barracudanetworks/wbd/database"
+)
+
+// scheduleBackups backs up the database every BackupInterval, keeping the
+// newest BackupKeep backups
+func scheduleBackups(db *database.Database, c *config.Configuration) {
+	log.Printf("Backing up the database every %s", c.BackupInterval)
+
+	ticker := time.NewTicker(c.BackupInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		path := database.BackupPath(c.BackupDir, c.Database, time.Now())
+		if err := db.Backup(path); err != nil {
+			log.Printf("Scheduled backup failed: %s", err)
+			continue
+		}
+		log.Printf("Backed up the database to %s", path)
+
+		if c.BackupKeep == 0 {
+			continue
+		}
+
+		removed, err := database.PruneBackups(c.BackupDir, c.Database, c.BackupKeep)
+		for _, backup := range removed {
+			log.Printf("Removed old backup %s", backup)
+		}
+		if err != nil {
+			log.Printf("Couldn't remove old backups: %s", err)
+		}
+	}
+}
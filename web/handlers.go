@@ -5,6 +5,9 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
+
+	"github.com/barracudanetworks/wbd/database"
 )
 
 type indexHandler struct{ App }
@@ -13,34 +16,30 @@ func (ih *indexHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	c := ih.App.GetClient(r)
 
 	// Web address to use in template
-	addr := fmt.Sprintf("%s%s", r.Host, ih.App.Address)
+	addr := fmt.Sprintf("%s%s", ih.App.Host(r), ih.App.Address)
 
 	// Show welcome page by default
-	defaultUrl := fmt.Sprintf("http://%s/welcome?client=%s", addr, c.Id)
+	defaultUrl := fmt.Sprintf("%s://%s/welcome?client=%s", ih.App.Scheme(r), addr, c.Id)
 
-	// Get URLs from database
-	urls, err := ih.App.Database.FetchUrls()
-	if err != nil {
-		log.Println(err)
-		return
-	}
+	wsUrl := fmt.Sprintf("%s://%s/ws", ih.App.WebsocketScheme(r), addr)
+	if c.Id != "" {
+		wsUrl += "?client=" + url.QueryEscape(c.Id)
 
-	// Load template, parse vars, write to client
-	t, err := template.New("index").Parse(indexTemplate)
-	if err != nil {
-		log.Println(err)
-		return
+		// Pass the enrollment token on, so the display is approved
+		if token := r.URL.Query().Get("enroll"); token != "" {
+			wsUrl += "&enroll=" + url.QueryEscape(token)
+		}
 	}
-	t.Execute(w, struct {
-		Address    template.URL
-		DefaultUrl template.URL
-		Client     string
-		URLs       []string
+
+	// Parse vars, write to client
+	ih.App.render(w, "index.html", struct {
+		DefaultUrl       template.URL
+		WebsocketUrl     template.URL
+		RotationDuration int
 	}{
-		template.URL(addr),
 		template.URL(defaultUrl),
-		c.Id,
-		urls,
+		template.URL(wsUrl),
+		int(ih.App.RotationDuration.Seconds()),
 	})
 }
 
@@ -49,38 +48,135 @@ type welcomeHandler struct{ App }
 func (wh *welcomeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	c := wh.App.GetClient(r)
 
-	// Load template, parse vars, write to client
-	t, _ := template.New("welcome").Parse(welcomeTemplate)
-	t.Execute(w, struct {
+	// Explain why a display that isn't approved stays here
+	var approval string
+	if wh.App.RequireApproval {
+		approval = database.ApprovalPending
+		if c.Id != "" {
+			if info, err := wh.App.Database.GetClient(c.Id); err == nil {
+				approval = info.Approval
+			}
+		}
+	}
+
+	// Parse vars, write to client
+	wh.App.render(w, "welcome.html", struct {
 		Client     string
 		RemoteAddr string
+		Approval   string
 	}{
 		c.Id,
 		c.RemoteAddr,
+		approval,
 	})
 }
 
 type consoleHandler struct{ App }
 
 func (ah *consoleHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	c := ah.App.GetClient(r)
-
 	// Web address to use in template
-	addr := fmt.Sprintf("%s%s", r.Host, ah.App.Address)
+	addr := fmt.Sprintf("%s%s", ah.App.Host(r), ah.App.Address)
 
-	// Load template, parse vars, write to client
-	t, _ := template.New("console").Parse(consoleTemplate)
-	t.Execute(w, struct {
-		Address    template.URL
-		Client     string
-		RemoteAddr string
+	// RequireRole has already let them in
+	l, _ := ah.App.authenticate(r)
+
+	// Parse vars, write to client
+	ah.App.render(w, "console.html", struct {
+		WebsocketUrl template.URL
+		ApiUrl       template.URL
+		LoginUrl     template.URL
+		LogoutUrl    string
+		User         string
+		Role         database.Role
+
+		RequireApproval bool
 	}{
-		template.URL(addr),
-		c.Id,
-		c.RemoteAddr,
+		template.URL(fmt.Sprintf("%s://%s/ws", ah.App.WebsocketScheme(r), addr)),
+		template.URL(ah.App.Address + apiPrefix),
+		template.URL(ah.App.Address + "/login?next=" + url.QueryEscape(r.URL.Path)),
+		ah.App.Address + "/logout",
+		l.Name,
+		l.Role,
+		ah.App.RequireApproval,
 	})
 }
 
+type loginHandler struct{ App }
+
+func (lh *loginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	next := lh.App.redirectTarget(r.FormValue("next"))
+
+	// Nothing to log in to until users are added
+	if _, ok := lh.App.authenticate(r); ok {
+		http.Redirect(w, r, next, http.StatusSeeOther)
+		return
+	}
+
+	failed := false
+	if r.Method == "POST" {
+		user, ok, err := lh.App.Database.CheckUser(r.FormValue("user"), r.FormValue("password"))
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+
+		if ok {
+			token, err := lh.App.Sessions.Create(user.Name)
+			if err != nil {
+				log.Println(err)
+				http.Error(w, "Internal server error", 500)
+				return
+			}
+
+			http.SetCookie(w, &http.Cookie{
+				Name:     sessionCookie,
+				Value:    token,
+				Path:     lh.App.cookiePath(),
+				MaxAge:   int(lh.App.Sessions.lifetime.Seconds()),
+				HttpOnly: true,
+				Secure:   lh.App.Scheme(r) == "https",
+				SameSite: http.SameSiteLaxMode,
+			})
+
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+
+		log.Printf("Failed login attempt for %q from %s", r.FormValue("user"), lh.App.GetClient(r).RemoteAddr)
+		failed = true
+	}
+
+	// Parse vars, write to client
+	lh.App.render(w, "login.html", struct {
+		Action string
+		Next   string
+		User   string
+		Failed bool
+	}{
+		lh.App.Address + "/login",
+		next,
+		r.FormValue("user"),
+		failed,
+	})
+}
+
+type logoutHandler struct{ App }
+
+func (lh *logoutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		lh.App.Sessions.Delete(cookie.Value)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:   sessionCookie,
+		Path:   lh.App.cookiePath(),
+		MaxAge: -1,
+	})
+
+	http.Redirect(w, r, lh.App.Address+"/login", http.StatusSeeOther)
+}
+
 type websocketHandler struct{ App }
 
 func (wh *websocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -98,7 +194,9 @@ func (wh *websocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	id := c.Id
 
-	client := NewWebsocketClient(wh.Database, ws, id, r.RemoteAddr)
+	client := NewWebsocketClient(wh.Database, ws, id, c.RemoteAddr)
+	client.Role = func() database.Role { return wh.App.Role(r) }
+	client.Enrollment = r.URL.Query().Get("enroll")
 
 	hub.register <- client
 	go client.writePump()
@@ -0,0 +1,160 @@
+package web
+
+import (
+	"log"
+	"time"
+
+	"github.com/barracudanetworks/wbd/database"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Why connections get closed
+const (
+	closeDisconnected = "disconnected"
+	closeDropped      = "dropped"
+	closeKicked       = "kicked"
+)
+
+var (
+	messagesSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wbd_websocket_messages_sent_total",
+		Help: "Websocket messages sent to clients, by action.",
+	}, []string{"action"})
+
+	messagesReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wbd_websocket_messages_received_total",
+		Help: "Websocket messages received from clients, by action.",
+	}, []string{"action"})
+
+	hubRegistrations = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "wbd_hub_registrations_total",
+		Help: "Connections added to the hub.",
+	})
+
+	hubUnregistrations = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "wbd_hub_unregistrations_total",
+		Help: "Connections removed from the hub after the client went away.",
+	})
+
+	connectionsClosed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wbd_connections_closed_total",
+		Help: "Connections closed by the hub, by reason: disconnected, dropped (the client fell behind) or kicked.",
+	}, []string{"reason"})
+
+	// SQLite queries mostly take well under a millisecond
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "wbd_database_query_duration_seconds",
+		Help:    "How long database queries took, by operation.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 2.5, 10),
+	}, []string{"operation"})
+
+	connectedDesc = prometheus.NewDesc(
+		"wbd_connected_clients",
+		"Displays connected to the hub, by the list they're showing.",
+		[]string{"list"}, nil)
+
+	lastPingDesc = prometheus.NewDesc(
+		"wbd_client_last_ping_age_seconds",
+		"Seconds since each known client was last heard from.",
+		[]string{"client", "alias"}, nil)
+)
+
+// Actions clients may send. Anything else is counted as unknown, so that
+// misbehaving clients can't create endless series.
+var receivedActions = map[string]bool{
+	"flagController": true,
+	"sendUrls":       true,
+	"sendClients":    true,
+	"status":         true,
+	"flashUrl":       true,
+	"next":           true,
+	"previous":       true,
+	"pause":          true,
+	"resume":         true,
+	"reload":         true,
+	"disconnect":     true,
+}
+
+func init() {
+	prometheus.MustRegister(
+		messagesSent,
+		messagesReceived,
+		hubRegistrations,
+		hubUnregistrations,
+		connectionsClosed,
+		queryDuration,
+	)
+}
+
+func receivedAction(action string) string {
+	if !receivedActions[action] {
+		return "unknown"
+	}
+
+	return action
+}
+
+// hubCollector reports who is connected to the hub, and how long ago every
+// client was last heard from
+type hubCollector struct {
+	db *database.Database
+}
+
+func (hc *hubCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- connectedDesc
+	ch <- lastPingDesc
+}
+
+func (hc *hubCollector) Collect(ch chan<- prometheus.Metric) {
+	lists, err := hc.db.FetchLists()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	// Lists nobody is showing are still reported
+	counts := make(map[string]int)
+	for _, list := range lists {
+		counts[list] = 0
+	}
+	for _, conn := range hub.Connections() {
+		if !conn.Controller {
+			counts[conn.List]++
+		}
+	}
+	for list, count := range counts {
+		ch <- prometheus.MustNewConstMetric(connectedDesc, prometheus.GaugeValue, float64(count), list)
+	}
+
+	clients, err := hc.db.FetchClients()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	now := time.Now()
+	for _, client := range clients {
+		// SQLite's CURRENT_TIMESTAMP is in UTC
+		last, err := time.ParseInLocation("2006-01-02 15:04:05", client.LastPing, time.UTC)
+		if err != nil {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(lastPingDesc, prometheus.GaugeValue, now.Sub(last).Seconds(), client.Identifier, client.Alias)
+	}
+}
+
+// RegisterMetrics serves Prometheus metrics at /metrics, behind the same
+// login as the API
+func (a *App) RegisterMetrics(r *mux.Router) {
+	prometheus.MustRegister(&hubCollector{a.Database})
+
+	a.Database.Conn.Observe = func(operation string, duration time.Duration) {
+		queryDuration.WithLabelValues(operation).Observe(duration.Seconds())
+	}
+
+	r.Handle("/metrics", a.RequireApiAuth(database.RoleViewer, promhttp.Handler()))
+}
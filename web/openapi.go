@@ -0,0 +1,357 @@
+package web
+
+// OpenAPI description of the JSON API, served at /api/v1/openapi.json
+const openApiSpec string = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "wbd",
+    "description": "Manage wallboard URLs, lists and clients. Viewers can read everything, flashing and control need the operator role, and other changes need admin.",
+    "version": "1"
+  },
+  "servers": [{"url": "/api/v1"}],
+  "security": [{"bearerAuth": []}, {"basicAuth": []}, {"sessionCookie": []}],
+  "paths": {
+    "/urls": {
+      "get": {
+        "summary": "List every URL",
+        "responses": {
+          "200": {"description": "URLs", "content": {"application/json": {"schema": {"type": "array", "items": {"type": "string"}}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"}
+        }
+      },
+      "post": {
+        "summary": "Add a URL",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Url"}}}},
+        "responses": {
+          "201": {"description": "URL added", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Url"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "409": {"$ref": "#/components/responses/Conflict"}
+        }
+      },
+      "delete": {
+        "summary": "Remove a URL",
+        "parameters": [{"$ref": "#/components/parameters/UrlQuery"}],
+        "responses": {
+          "204": {"description": "URL removed"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      }
+    },
+    "/lists": {
+      "get": {
+        "summary": "List every URL list and its URLs",
+        "responses": {
+          "200": {"description": "Lists", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/List"}}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"}
+        }
+      },
+      "post": {
+        "summary": "Create a URL list",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/List"}}}},
+        "responses": {
+          "201": {"description": "List created", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/List"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "409": {"$ref": "#/components/responses/Conflict"}
+        }
+      }
+    },
+    "/lists/{name}": {
+      "parameters": [{"$ref": "#/components/parameters/ListName"}],
+      "get": {
+        "summary": "Get a URL list",
+        "responses": {
+          "200": {"description": "List", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/List"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      },
+      "put": {
+        "summary": "Change a URL list's rotation mode",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object", "properties": {"mode": {"$ref": "#/components/schemas/Mode"}}}}}},
+        "responses": {
+          "200": {"description": "List", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/List"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      },
+      "delete": {
+        "summary": "Delete a URL list, moving its clients back to the Default list",
+        "responses": {
+          "204": {"description": "List deleted"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      }
+    },
+    "/lists/{name}/urls": {
+      "parameters": [{"$ref": "#/components/parameters/ListName"}],
+      "get": {
+        "summary": "List the URLs in a list",
+        "responses": {
+          "200": {"description": "URLs", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/ListUrl"}}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      },
+      "post": {
+        "summary": "Assign an existing URL to a list, or update its duration and position if it is already in the list",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ListUrl"}}}},
+        "responses": {
+          "201": {"description": "URL assigned", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/List"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      },
+      "delete": {
+        "summary": "Remove a URL from a list",
+        "parameters": [{"$ref": "#/components/parameters/UrlQuery"}],
+        "responses": {
+          "204": {"description": "URL removed from list"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      }
+    },
+    "/clients": {
+      "get": {
+        "summary": "List known clients",
+        "responses": {
+          "200": {"description": "Clients", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Client"}}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"}
+        }
+      }
+    },
+    "/clients/{id}": {
+      "parameters": [{"$ref": "#/components/parameters/ClientId"}],
+      "get": {
+        "summary": "Get a client by identifier or alias",
+        "responses": {
+          "200": {"description": "Client", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Client"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      },
+      "delete": {
+        "summary": "Forget a client",
+        "responses": {
+          "204": {"description": "Client removed"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      }
+    },
+    "/clients/{id}/alias": {
+      "parameters": [{"$ref": "#/components/parameters/ClientId"}],
+      "put": {
+        "summary": "Alias a client to something human",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object", "properties": {"alias": {"type": "string"}}, "required": ["alias"]}}}},
+        "responses": {
+          "200": {"description": "Client", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Client"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      }
+    },
+    "/clients/{id}/approval": {
+      "parameters": [{"$ref": "#/components/parameters/ClientId"}],
+      "put": {
+        "summary": "Approve a client, letting it show its list, or reject it, keeping it on the welcome page",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object", "properties": {"approval": {"type": "string", "enum": ["approved", "rejected"]}}, "required": ["approval"]}}}},
+        "responses": {
+          "200": {"description": "Client", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Client"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      }
+    },
+    "/clients/{id}/list": {
+      "parameters": [{"$ref": "#/components/parameters/ClientId"}],
+      "put": {
+        "summary": "Assign a client to a list",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object", "properties": {"list": {"type": "string"}}, "required": ["list"]}}}},
+        "responses": {
+          "200": {"description": "Client", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Client"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      },
+      "delete": {
+        "summary": "Remove a client's own list, leaving it on its group's list (or the Default list)",
+        "responses": {
+          "200": {"description": "Client", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Client"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      }
+    },
+    "/connections": {
+      "get": {
+        "summary": "List the websocket connections to the server, with what each display is showing",
+        "responses": {
+          "200": {"description": "Connections", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Connection"}}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"}
+        }
+      }
+    },
+    "/control": {
+      "post": {
+        "summary": "Control playback on connected displays",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Control"}}}},
+        "responses": {
+          "202": {"description": "Action sent", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Control"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      }
+    },
+    "/flash": {
+      "post": {
+        "summary": "Show a URL on connected displays for a while, then resume their rotation",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Flash"}}}},
+        "responses": {
+          "202": {"description": "Flash sent", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Flash"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "bearerAuth": {"type": "http", "scheme": "bearer", "description": "An API token made with wbd user --token"},
+      "basicAuth": {"type": "http", "scheme": "basic", "description": "A user's name and password"},
+      "sessionCookie": {"type": "apiKey", "in": "cookie", "name": "wbd_session"}
+    },
+    "parameters": {
+      "ListName": {"name": "name", "in": "path", "required": true, "schema": {"type": "string"}},
+      "ClientId": {"name": "id", "in": "path", "required": true, "description": "Client identifier or alias", "schema": {"type": "string"}},
+      "UrlQuery": {"name": "url", "in": "query", "required": true, "schema": {"type": "string"}}
+    },
+    "schemas": {
+      "Error": {"type": "object", "properties": {"error": {"type": "string"}}},
+      "Mode": {
+        "type": "string",
+        "enum": ["sequential", "shuffle", "stagger"],
+        "description": "sequential rotates in order, shuffle randomises the order each cycle, and stagger starts each client on a different URL"
+      },
+      "Url": {"type": "object", "properties": {"url": {"type": "string"}}, "required": ["url"]},
+      "ListUrl": {
+        "type": "object",
+        "properties": {
+          "url": {"type": "string"},
+          "duration": {"type": "integer", "minimum": 0, "description": "Seconds on screen; 0 uses the display's default"},
+          "position": {"type": "integer", "minimum": 1, "writeOnly": true, "description": "Position in the list to insert at or move to; URLs are returned in list order"}
+        },
+        "required": ["url"]
+      },
+      "List": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "mode": {"$ref": "#/components/schemas/Mode"},
+          "urls": {"type": "array", "items": {"$ref": "#/components/schemas/ListUrl"}, "readOnly": true}
+        },
+        "required": ["name"]
+      },
+      "Control": {
+        "type": "object",
+        "properties": {
+          "action": {
+            "type": "string",
+            "enum": ["next", "previous", "pause", "resume", "reload", "disconnect"],
+            "description": "reload reloads the display page, and disconnect drops its connection so it reconnects"
+          },
+          "client": {"type": "string", "description": "Only control this client (identifier or alias)"},
+          "list": {"type": "string", "description": "Only control clients on this list"}
+        },
+        "required": ["action"]
+      },
+      "Flash": {
+        "type": "object",
+        "properties": {
+          "url": {"type": "string"},
+          "duration": {"type": "integer", "description": "Seconds to show the URL for; must be positive, and defaults to 60"},
+          "client": {"type": "string", "description": "Only flash this client (identifier or alias)"},
+          "list": {"type": "string", "description": "Only flash clients on this list"}
+        },
+        "required": ["url"]
+      },
+      "Connection": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string", "description": "Client identifier, empty for generic connections"},
+          "ip_address": {"type": "string"},
+          "controller": {"type": "boolean", "description": "Whether this is a console or dashboard rather than a display"},
+          "generic": {"type": "boolean"},
+          "list": {"type": "string", "description": "The list the display is showing"},
+          "status": {"$ref": "#/components/schemas/ClientStatus"}
+        }
+      },
+      "ClientStatus": {
+        "type": "object",
+        "description": "What the client last reported it was showing",
+        "properties": {
+          "url": {"type": "string"},
+          "position": {"type": "integer", "description": "Position of the URL in the client's rotation, from 1"},
+          "count": {"type": "integer", "description": "Number of URLs in the client's rotation"},
+          "paused": {"type": "boolean"},
+          "flashing": {"type": "boolean"},
+          "loaded": {"type": "boolean", "description": "Whether the URL has finished loading"},
+          "error": {"type": "string", "description": "Why the URL failed to load, if it did"},
+          "uptime": {"type": "integer", "description": "Seconds since the display page was loaded"},
+          "updated": {"type": "string"}
+        }
+      },
+      "Client": {
+        "type": "object",
+        "properties": {
+          "identifier": {"type": "string"},
+          "alias": {"type": "string"},
+          "ip_address": {"type": "string"},
+          "last_ping": {"type": "string"},
+          "url_list_id": {"type": "integer", "description": "The client's own list, or 0 to follow its group"},
+          "group_id": {"type": "integer", "description": "0 if the client isn't in a group"},
+          "approval": {"type": "string", "enum": ["approved", "pending", "rejected"], "description": "When approval is required, only approved clients are shown their list"},
+          "list": {"type": "string", "description": "The list the client is assigned, directly or through its group"},
+          "group": {"type": "string"},
+          "status": {"$ref": "#/components/schemas/ClientStatus"}
+        }
+      }
+    },
+    "responses": {
+      "BadRequest": {"description": "Invalid request", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Unauthorized": {"description": "Login required", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Forbidden": {"description": "The user's role doesn't allow this", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "NotFound": {"description": "Not found", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Conflict": {"description": "Already exists", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+    }
+  }
+}
+`
@@ -0,0 +1,552 @@
+// The dashboard: manages URLs, lists and displays through the JSON API, and
+// listens on the websocket for displays coming, going and moving on
+
+(function() {
+	var apiUrl, loginUrl, role, requireApproval;
+
+	// Each role may do everything the ones before it may
+	var roles = ['viewer', 'operator', 'admin'];
+
+	var attempts = 1;
+	var refreshTimeout;
+
+	// Everything the dashboard shows, as last fetched from the API
+	var state = {
+		urls: [],
+		lists: [],
+		clients: [],
+		connections: {}
+	};
+
+	function generateInterval(k) {
+		var maxInterval = (Math.pow(2, k) - 1) * 1000;
+
+		if (maxInterval > 30*1000) {
+			maxInterval = 30*1000; // If the generated interval is more than 30 seconds, truncate it down to 30 seconds.
+		}
+
+		// generate the interval to a random number between 0 and the maxInterval determined from above
+		return Math.random() * maxInterval;
+	}
+
+	// Whether the user's role allows what needs the given one; the server
+	// checks too, this just keeps controls they can't use out of the way
+	function can(needed) {
+		return roles.indexOf(role) >= roles.indexOf(needed);
+	}
+
+	function byId(id) {
+		return document.getElementById(id);
+	}
+
+	// Build an element, e.g. el('td', { className: 'muted' }, 'text', child)
+	function el(tag, props) {
+		var node = document.createElement(tag);
+
+		for (var key in props || {}) {
+			node[key] = props[key];
+		}
+
+		for (var i = 2; i < arguments.length; i++) {
+			var child = arguments[i];
+			if (child === null || typeof child === 'undefined') {
+				continue;
+			}
+
+			node.appendChild(typeof child === 'string' ? document.createTextNode(child) : child);
+		}
+
+		return node;
+	}
+
+	function empty(node) {
+		while (node.firstChild) {
+			node.removeChild(node.firstChild);
+		}
+
+		return node;
+	}
+
+	function showError(message) {
+		var box = byId('error');
+		box.textContent = message;
+		box.style.display = 'block';
+	}
+
+	function hideError() {
+		byId('error').style.display = 'none';
+	}
+
+	// Call the JSON API, sending anyone whose session ran out back to the
+	// login page
+	function api(method, path, body) {
+		var options = {
+			method: method,
+			credentials: 'same-origin',
+			headers: { 'Accept': 'application/json' }
+		};
+
+		if (typeof body !== 'undefined') {
+			options.headers['Content-Type'] = 'application/json';
+			options.body = JSON.stringify(body);
+		}
+
+		return fetch(apiUrl + path, options).then(function(response) {
+			if (response.status == 401) {
+				window.location = loginUrl;
+				throw new Error("Login required");
+			}
+
+			if (response.status == 204) {
+				return null;
+			}
+
+			return response.json().then(function(data) {
+				if (!response.ok) {
+					throw new Error(data && data.error || "Request failed: " + response.statusText);
+				}
+
+				return data;
+			});
+		}).catch(function(err) {
+			showError(err.message);
+			throw err;
+		});
+	}
+
+	// Make a change, then show its effect
+	function change(method, path, body) {
+		hideError();
+
+		return api(method, path, body).then(function(data) {
+			refresh();
+			return data;
+		});
+	}
+
+	function refresh() {
+		Promise.all([
+			api('GET', '/urls'),
+			api('GET', '/lists'),
+			api('GET', '/clients'),
+			api('GET', '/connections')
+		]).then(function(results) {
+			state.urls = results[0];
+			state.lists = results[1];
+			state.clients = results[2];
+
+			state.connections = {};
+			results[3].forEach(function(conn) {
+				if (!conn.generic && !conn.controller) {
+					state.connections[conn.id] = conn;
+				}
+			});
+
+			render();
+		}).catch(function() {});
+	}
+
+	// Several updates often arrive together, so wait for them to settle
+	function scheduleRefresh() {
+		clearTimeout(refreshTimeout);
+		refreshTimeout = setTimeout(refresh, 250);
+	}
+
+	function clientPath(identifier) {
+		return '/clients/' + encodeURIComponent(identifier);
+	}
+
+	function listPath(name) {
+		return '/lists/' + encodeURIComponent(name);
+	}
+
+	function displayName(client) {
+		return client.alias || client.identifier;
+	}
+
+	function describeStatus(status) {
+		var notes = [];
+		if (status.count > 0) {
+			notes.push(status.position + '/' + status.count);
+		}
+		if (status.flashing) { notes.push('flashing'); }
+		if (status.paused) { notes.push('paused'); }
+		if (!status.error && !status.loaded) { notes.push('loading'); }
+
+		return notes.join(', ');
+	}
+
+	function button(label, onClick) {
+		var b = el('button', { type: 'button', className: 'small' }, label);
+		b.addEventListener('click', onClick);
+		return b;
+	}
+
+	function dot(online) {
+		return el('span', { className: online ? 'dot online' : 'dot' });
+	}
+
+	// Unapproved displays only see the welcome page, when approval is required
+	function approved(client) {
+		return !requireApproval || client.approval == 'approved';
+	}
+
+	function link(url) {
+		return el('a', { href: url, target: '_blank' }, url);
+	}
+
+	function ignore() {}
+
+	function render() {
+		renderRoster();
+		renderBoard();
+		renderUrls();
+		renderTargets();
+	}
+
+	function renderRoster() {
+		var tbody = empty(byId('roster').tBodies[0]);
+
+		if (state.clients.length == 0) {
+			tbody.appendChild(el('tr', {}, el('td', { colSpan: 5, className: 'muted' }, 'No displays have connected yet')));
+			return;
+		}
+
+		state.clients.forEach(function(client) {
+			var conn = state.connections[client.identifier];
+
+			var name = el('td', {},
+				dot(!!conn),
+				el('strong', {}, displayName(client)),
+				client.alias ? el('div', { className: 'muted' }, client.identifier) : null,
+				el('div', { className: 'muted' }, client.ip_address),
+				approved(client) ? null : el('div', { className: 'problem' }, client.approval == 'pending' ? 'Awaiting approval' : 'Rejected'));
+
+			var list = el('td', {}, client.list,
+				client.group ? el('div', { className: 'muted' }, 'Group ' + client.group) : null);
+
+			// Live status from the connection, or the last one saved
+			var status = conn && conn.status || client.status;
+			var showing;
+			if (status) {
+				showing = el('td', {},
+					link(status.url),
+					el('div', { className: 'muted' }, describeStatus(status)),
+					status.error ? el('div', { className: 'problem' }, status.error) : null,
+					conn ? null : el('div', { className: 'muted' }, 'as of ' + status.updated));
+			} else {
+				showing = el('td', { className: 'muted' }, 'Unknown');
+			}
+
+			var actions = el('td', { className: 'actions' });
+			if (conn && can('operator')) {
+				actions.appendChild(button('Previous', function() { control('previous', client); }));
+				actions.appendChild(button('Next', function() { control('next', client); }));
+
+				if (status && status.paused) {
+					actions.appendChild(button('Resume', function() { control('resume', client); }));
+				} else {
+					actions.appendChild(button('Pause', function() { control('pause', client); }));
+				}
+
+				actions.appendChild(button('Reload', function() { control('reload', client); }));
+			}
+			if (can('admin') && !approved(client)) {
+				actions.appendChild(button('Approve', function() {
+					change('PUT', clientPath(client.identifier) + '/approval', { approval: 'approved' }).catch(ignore);
+				}));
+				if (client.approval == 'pending') {
+					actions.appendChild(button('Reject', function() {
+						change('PUT', clientPath(client.identifier) + '/approval', { approval: 'rejected' }).catch(ignore);
+					}));
+				}
+			}
+			if (can('admin')) {
+				actions.appendChild(button('Rename', function() {
+					var alias = prompt('Alias for ' + client.identifier + ' (leave empty to clear)', client.alias);
+					if (alias !== null) {
+						change('PUT', clientPath(client.identifier) + '/alias', { alias: alias }).catch(ignore);
+					}
+				}));
+				actions.appendChild(button('Forget', function() {
+					if (confirm('Forget ' + displayName(client) + '? It will be added again if it reconnects.')) {
+						change('DELETE', clientPath(client.identifier)).catch(ignore);
+					}
+				}));
+			}
+
+			tbody.appendChild(el('tr', {}, name, list, showing,
+				el('td', {}, conn ? 'Connected' : client.last_ping),
+				actions));
+		});
+	}
+
+	// Displays can be dragged between lists; the first column holds those
+	// that follow their group (or the Default list)
+	function renderBoard() {
+		var board = empty(byId('board'));
+
+		board.appendChild(column(null));
+		state.lists.forEach(function(list) {
+			board.appendChild(column(list));
+		});
+	}
+
+	function column(list) {
+		var col = el('div', { className: 'column' });
+
+		if (list === null) {
+			col.appendChild(el('h3', {}, 'Group or Default'));
+			col.appendChild(el('p', { className: 'hint' }, "Displays here show their group's list, or the Default list"));
+		} else {
+			col.appendChild(el('h3', {}, list.name + ' ', list.name == 'Default' || !can('admin') ? null : button('Delete', function() {
+				if (confirm('Delete the ' + list.name + ' list?')) {
+					change('DELETE', listPath(list.name)).catch(ignore);
+				}
+			})));
+
+			var mode = el('select', { disabled: !can('admin') });
+			['sequential', 'shuffle', 'stagger'].forEach(function(m) {
+				mode.appendChild(el('option', { value: m }, m));
+			});
+			mode.value = list.mode;
+			mode.addEventListener('change', function() {
+				change('PUT', listPath(list.name), { mode: mode.value }).catch(ignore);
+			});
+			col.appendChild(mode);
+
+			var urls = el('ul');
+			list.urls.forEach(function(entry) {
+				urls.appendChild(el('li', {}, entry.url + ' ',
+					entry.duration > 0 ? el('span', { className: 'muted' }, '(' + entry.duration + 's) ') : null,
+					!can('admin') ? null : button('Remove', function() {
+						change('DELETE', listPath(list.name) + '/urls?url=' + encodeURIComponent(entry.url)).catch(ignore);
+					})));
+			});
+			col.appendChild(urls);
+
+			if (!can('admin')) {
+				return cards(col, list);
+			}
+
+			var add = el('select', {}, el('option', { value: '' }, 'Add a URL...'));
+			state.urls.forEach(function(url) {
+				add.appendChild(el('option', { value: url }, url));
+			});
+			add.addEventListener('change', function() {
+				if (add.value != '') {
+					change('POST', listPath(list.name) + '/urls', { url: add.value }).catch(ignore);
+				}
+			});
+			col.appendChild(add);
+		}
+
+		if (!can('admin')) {
+			return cards(col, list);
+		}
+
+		cards(col, list);
+
+		col.addEventListener('dragover', function(evt) {
+			evt.preventDefault();
+			col.classList.add('over');
+		});
+		col.addEventListener('dragleave', function() {
+			col.classList.remove('over');
+		});
+		col.addEventListener('drop', function(evt) {
+			evt.preventDefault();
+			col.classList.remove('over');
+
+			var identifier = evt.dataTransfer.getData('text/plain');
+			if (list === null) {
+				change('DELETE', clientPath(identifier) + '/list').catch(ignore);
+			} else {
+				change('PUT', clientPath(identifier) + '/list', { list: list.name }).catch(ignore);
+			}
+		});
+
+		return col;
+	}
+
+	// cards adds the displays assigned to a list to its column, which admins
+	// can drag to another
+	function cards(col, list) {
+		state.clients.forEach(function(client) {
+			var assigned = list === null ? client.url_list_id == 0 : client.url_list_id != 0 && client.list == list.name;
+			if (!assigned) {
+				return;
+			}
+
+			var card = el('div', { className: 'card', draggable: can('admin') },
+				dot(!!state.connections[client.identifier]),
+				el('span', {}, displayName(client)));
+
+			card.addEventListener('dragstart', function(evt) {
+				evt.dataTransfer.setData('text/plain', client.identifier);
+			});
+			col.appendChild(card);
+		});
+
+		return col;
+	}
+
+	function renderUrls() {
+		var ul = empty(byId('url-list'));
+
+		if (state.urls.length == 0) {
+			ul.appendChild(el('li', { className: 'muted' }, 'No URLs yet'));
+		}
+
+		state.urls.forEach(function(url) {
+			ul.appendChild(el('li', {}, link(url), ' ', !can('admin') ? null : button('Delete', function() {
+				if (confirm('Delete ' + url + ' from every list?')) {
+					change('DELETE', '/urls?url=' + encodeURIComponent(url)).catch(ignore);
+				}
+			})));
+		});
+	}
+
+	// Flash and reload can be aimed at everything, a list or a display
+	function renderTargets() {
+		var select = byId('target');
+		var selected = select.value;
+
+		empty(select).appendChild(el('option', { value: '' }, 'All displays'));
+		state.lists.forEach(function(list) {
+			select.appendChild(el('option', { value: 'list:' + list.name }, 'List: ' + list.name));
+		});
+		state.clients.forEach(function(client) {
+			select.appendChild(el('option', { value: 'client:' + client.identifier }, 'Display: ' + displayName(client)));
+		});
+
+		select.value = selected;
+		if (select.value !== selected) {
+			select.value = '';
+		}
+	}
+
+	function target() {
+		var value = byId('target').value;
+		if (value.indexOf('list:') == 0) {
+			return { list: value.substring(5) };
+		}
+		if (value.indexOf('client:') == 0) {
+			return { client: value.substring(7) };
+		}
+
+		return {};
+	}
+
+	function control(action, client) {
+		var body = client ? { client: client.identifier } : target();
+		body.action = action;
+
+		hideError();
+		api('POST', '/control', body).catch(ignore);
+	}
+
+	// Submitting a form calls f with its text input, clearing it once the
+	// change has been made
+	function onSubmit(form, input, f) {
+		form.addEventListener('submit', function(evt) {
+			evt.preventDefault();
+
+			f(input.value).then(function() {
+				input.value = '';
+			}).catch(ignore);
+		});
+	}
+
+	// The websocket tells us when displays come and go or move on to a new
+	// page
+	function wbdConnect(endpoint) {
+		if (!window["WebSocket"]) return false;
+
+		var conn = new WebSocket(endpoint);
+		conn.onopen = function(evt) {
+			console.log("Connected to websocket server");
+			byId('connection').textContent = 'Live';
+
+			conn.send(JSON.stringify({
+				action: 'flagController'
+			}));
+
+			// reset reconnection counter
+			attempts = 1;
+			refresh();
+		}
+
+		conn.onclose = function(evt) {
+			console.log("Disconnected from websocket server");
+			byId('connection').textContent = 'Reconnecting...';
+
+			// attempt reconnection
+			var time = generateInterval(attempts);
+			console.log("Attempting reconnection in " + time + " milliseconds")
+
+			setTimeout(function() {
+				attempts++;
+
+				wbdConnect(endpoint);
+			}, time);
+		}
+
+		conn.onmessage = function(evt) {
+			var message = JSON.parse(evt.data);
+
+			switch (message.action) {
+			case 'updateClients':
+			case 'clientStatus':
+				scheduleRefresh();
+
+				break;
+			case 'unauthorized':
+				window.location = loginUrl;
+
+				break;
+			case 'forbidden':
+				showError(message.data.message);
+
+				break;
+			}
+		}
+	}
+
+	// The page passes in where the API and websocket are
+	document.addEventListener('DOMContentLoaded', function() {
+		apiUrl = document.body.getAttribute('data-api-url');
+		loginUrl = document.body.getAttribute('data-login-url');
+		role = document.body.getAttribute('data-role');
+		requireApproval = document.body.getAttribute('data-require-approval') == 'true';
+
+		byId('flash-form').addEventListener('submit', function(evt) {
+			evt.preventDefault();
+
+			var body = target();
+			body.url = byId('flash-url').value;
+			body.duration = parseInt(byId('flash-duration').value, 10) || 0;
+
+			hideError();
+			api('POST', '/flash', body).catch(ignore);
+		});
+
+		byId('reload-button').addEventListener('click', function() {
+			control('reload');
+		});
+
+		onSubmit(byId('list-form'), byId('list-name'), function(name) {
+			return change('POST', '/lists', { name: name });
+		});
+
+		onSubmit(byId('url-form'), byId('url-input'), function(url) {
+			return change('POST', '/urls', { url: url });
+		});
+
+		wbdConnect(document.body.getAttribute('data-websocket-url'));
+		refresh();
+
+		// Keep last active times fresh
+		setInterval(refresh, 30*1000);
+	});
+})();
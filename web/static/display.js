@@ -0,0 +1,445 @@
+// The display page: rotates through the URLs the server sends in a
+// full-screen iframe, and takes orders from the server over a websocket
+
+var attempts = 1;
+var lastStatus = '';
+
+// How long a page gets to load before it's reported as broken, and how
+// often the display checks in even when nothing has changed
+var loadTimeoutSeconds = 30;
+var heartbeatSeconds = 30;
+
+function generateInterval(k) {
+	var maxInterval = (Math.pow(2, k) - 1) * 1000;
+
+	if (maxInterval > 30*1000) {
+		maxInterval = 30*1000; // If the generated interval is more than 30 seconds, truncate it down to 30 seconds.
+	}
+
+	// generate the interval to a random number between 0 and the maxInterval determined from above
+	return Math.random() * maxInterval;
+}
+
+function SiteRotator (defaultDuration, defaultUrl) {
+	var self = this;
+	var frameId = 0;
+	var urls = [{ url: defaultUrl, duration: 0 }];
+	var mode = 'sequential';
+	var offset = 0;
+
+	// order holds indexes into urls, and position is how far through
+	// it we are
+	var order = [0];
+	var position = 0;
+
+	var rotateTimeout;
+	var flashing = false;
+	var paused = false;
+	var currentUrl = defaultUrl;
+
+	var loaded = false;
+	var loadError = '';
+	var loadTimeout;
+	var started = Date.now();
+
+	// Called whenever what the display is showing changes
+	this.onChange = function() {};
+
+	// Older servers send bare URL strings rather than {url, duration}
+	// objects, so accept either
+	var normalize = function(entries) {
+		return entries.map(function(entry) {
+			if (typeof entry === 'string') {
+				return { url: entry, duration: 0 };
+			}
+
+			return { url: entry.url, duration: entry.duration || 0 };
+		});
+	};
+
+	var shuffle = function(indexes) {
+		for (var i = indexes.length - 1; i > 0; i--) {
+			var j = Math.floor(Math.random() * (i + 1));
+			var tmp = indexes[i];
+			indexes[i] = indexes[j];
+			indexes[j] = tmp;
+		}
+	};
+
+	// Work out the order to show URLs in for the next cycle
+	var buildOrder = function() {
+		var last = order[position];
+
+		order = [];
+		for (var i = 0; i < urls.length; i++) {
+			order.push(i);
+		}
+
+		if (mode == 'shuffle') {
+			shuffle(order);
+
+			// Don't show the same page twice in a row across cycles
+			if (order.length > 1 && order[0] === last) {
+				order.push(order.shift());
+			}
+		}
+	};
+
+	this.init = function() {
+		// Load first URL when initialized
+		console.log("Initializing rotator in", mode, "mode");
+
+		buildOrder();
+
+		// Staggered clients each start on a different URL
+		var start = 0;
+		if (mode == 'stagger') {
+			start = offset % order.length;
+		}
+
+		// Don't cut a flashed URL short
+		if (flashing) {
+			position = start;
+			return;
+		}
+
+		this.show(start);
+	};
+
+	this.setUrls = function(newUrls, newMode, newOffset) {
+		if (typeof newUrls === 'undefined') {
+			console.error("Must pass list of URLs to function setUrls");
+			return;
+		}
+
+		// Try to use the default URLs if we don't have any
+		if (newUrls == null || newUrls.length < 1) {
+			console.warn("Updated list was empty -- using default URL");
+			newUrls = [defaultUrl];
+		}
+
+		newUrls = normalize(newUrls);
+		newMode = newMode || 'sequential';
+		newOffset = newOffset || 0;
+
+		// Only update if something changed -- this reinits the rotator
+		if (JSON.stringify(urls) !== JSON.stringify(newUrls) || mode !== newMode || offset !== newOffset) {
+			console.log("Current URLs:", urls);
+			console.log("Updated URLs:", newUrls);
+
+			urls = newUrls;
+			mode = newMode;
+			offset = newOffset;
+
+			this.init();
+		}
+	};
+
+	this.show = function(newPosition) {
+		// Showing anything else ends a flash, whose timeout scheduleNext
+		// replaces
+		flashing = false;
+		position = newPosition;
+
+		this.load(urls[order[position]].url);
+		this.scheduleNext();
+		this.onChange();
+	};
+
+	this.load = function(url) {
+		// If there's only one URL in our list, and it's the one we're on,
+		// don't waste time refreshing
+		if (currentUrl == url)
+		{
+			console.log("Skipping rotation because URL hasn't changed");
+			return;
+		}
+
+		currentUrl = url;
+
+		console.info("Loading URL:", currentUrl)
+
+		var wrapper = document.getElementById('iframe-wrapper');
+		var newFrame = document.createElement('iframe');
+
+		newFrame.id = 'iframe-' + (++frameId);
+		newFrame.className = 'loading';
+		newFrame.src = currentUrl;
+		wrapper.appendChild(newFrame);
+
+		loaded = false;
+		loadError = '';
+
+		if (typeof loadTimeout !== 'undefined') {
+			clearTimeout(loadTimeout);
+		}
+		loadTimeout = setTimeout(function() {
+			console.warn("Timed out loading URL:", url);
+
+			loadError = 'Timed out loading URL';
+			self.onChange();
+		}, loadTimeoutSeconds * 1000);
+
+		newFrame.addEventListener('load', function() {
+			var old = wrapper.querySelectorAll('iframe.loaded');
+			for (var i = 0; i < old.length; i++) {
+				wrapper.removeChild(old[i]);
+			}
+			newFrame.className = 'loaded';
+
+			// Only the newest frame counts
+			if (currentUrl === url) {
+				clearTimeout(loadTimeout);
+				loaded = true;
+				loadError = '';
+				self.onChange();
+			}
+		});
+
+		this.onChange();
+	};
+
+	this.next = function() {
+		console.log("Moving to next URL");
+
+		if (urls.length < 1) {
+			return;
+		}
+
+		var next = position + 1;
+		if (next >= order.length) {
+			next = 0;
+
+			// Shuffled lists get a new order every cycle
+			if (mode == 'shuffle') {
+				buildOrder();
+			}
+		}
+
+		this.show(next);
+	};
+
+	this.previous = function() {
+		console.log("Moving to previous URL");
+
+		if (urls.length < 1) {
+			return;
+		}
+
+		var previous = position - 1;
+		if (previous < 0) {
+			previous = order.length - 1;
+		}
+
+		this.show(previous);
+	};
+
+	// Show a URL for a while, then carry on where we left off
+	this.flash = function(url, duration) {
+		if (typeof rotateTimeout !== 'undefined') {
+			clearTimeout(rotateTimeout);
+		}
+
+		console.log("Flashing", url, "for", duration, "seconds");
+
+		flashing = true;
+		this.load(url);
+
+		var self = this;
+		rotateTimeout = setTimeout(function() {
+			flashing = false;
+
+			// The list may have changed underneath us
+			if (position >= order.length) {
+				position = 0;
+			}
+
+			self.show(position);
+		}, duration * 1000);
+	};
+
+	// Stay on the current page until resumed
+	this.pause = function() {
+		if (paused) { return; }
+
+		console.log("Pausing rotation");
+
+		// A flashed page stays up while paused
+		paused = true;
+		flashing = false;
+
+		if (typeof rotateTimeout !== 'undefined') {
+			clearTimeout(rotateTimeout);
+		}
+
+		this.onChange();
+	};
+
+	this.resume = function() {
+		if (!paused) { return; }
+
+		console.log("Resuming rotation");
+
+		paused = false;
+		this.show(position);
+	};
+
+	// Each URL stays up for its own duration, falling back to the
+	// rotator's default
+	this.scheduleNext = function() {
+		// Remove old rotation timeout if one is set
+		if (typeof rotateTimeout !== 'undefined') {
+			clearTimeout(rotateTimeout);
+		}
+
+		if (paused || typeof defaultDuration === 'undefined') {
+			return;
+		}
+
+		var duration = urls[order[position]].duration;
+		if (duration <= 0) {
+			duration = defaultDuration;
+		}
+
+		var self = this;
+		rotateTimeout = setTimeout(function() {
+			self.next();
+		}, duration * 1000);
+
+		console.log("Next rotation in", duration, "seconds");
+	};
+
+	// What the display is showing, for the server
+	this.status = function() {
+		return {
+			url: currentUrl,
+			position: position + 1,
+			count: order.length,
+			paused: paused,
+			flashing: flashing,
+			loaded: loaded,
+			error: loadError,
+			uptime: Math.floor((Date.now() - started) / 1000)
+		};
+	};
+
+	this.init();
+}
+
+// Tell the server what we're showing, if it changed since we last did
+// or force is set
+function sendStatus(rotator, force) {
+	if (typeof conn === 'undefined' || conn.readyState !== WebSocket.OPEN) {
+		return;
+	}
+
+	// Uptime always moves on, so leave it out when looking for changes
+	var status = rotator.status();
+	var uptime = status.uptime;
+
+	status.uptime = 0;
+	var current = JSON.stringify(status);
+	status.uptime = uptime;
+
+	if (!force && current === lastStatus) {
+		return;
+	}
+	lastStatus = current;
+
+	conn.send(JSON.stringify({
+		"action": "status",
+		"data": status
+	}));
+}
+
+function wbdConnect(endpoint, rotator) {
+	if (typeof endpoint === 'undefined') return false;
+	if (!window["WebSocket"]) return false;
+
+	conn = new WebSocket(endpoint);
+	conn.onopen = function(evt) {
+		console.log("Connected to websocket server");
+		conn.send(JSON.stringify({
+			"action": "sendUrls"
+		}));
+		sendStatus(rotator, true);
+
+		// reset reconnection counter
+		attempts = 1;
+	}
+	conn.onclose = function(evt) {
+		console.log("Disconnected from websocket server");
+
+		// attempt reconnection
+		var time = generateInterval(attempts);
+		console.log("Attempting reconnection in", time, "milliseconds")
+
+		setTimeout(function() {
+			console.log("Attempting reconnection");
+
+			attempts++;
+
+			wbdConnect(endpoint, rotator);
+		}, time);
+	}
+	conn.onmessage = function(evt) {
+		message = JSON.parse(evt.data);
+
+		if (typeof message.action === 'undefined')
+		{
+			console.error("No action in message from server:", message)
+			return;
+		}
+
+		switch (message.action) {
+		case 'updateUrls':
+			rotator.setUrls(message.data.urls, message.data.mode, message.data.offset);
+
+			break;
+		case 'flashUrl':
+			rotator.flash(message.data.url, message.data.duration);
+
+			break;
+		case 'next':
+			rotator.next();
+
+			break;
+		case 'previous':
+			rotator.previous();
+
+			break;
+		case 'pause':
+			rotator.pause();
+
+			break;
+		case 'resume':
+			rotator.resume();
+
+			break;
+		case 'reload':
+			window.location.reload();
+
+			break;
+		default:
+			console.error("Unknown action in message from server:", message)
+			break;
+		}
+	}
+}
+
+// The page passes in where to start, where to connect to and how long to
+// show URLs for
+document.addEventListener("DOMContentLoaded", function(event) {
+	var duration = parseInt(document.body.getAttribute('data-rotation-duration'), 10) || 60;
+	var rotator = new SiteRotator(duration, document.body.getAttribute('data-default-url'));
+
+	rotator.onChange = function() {
+		sendStatus(rotator, false);
+	};
+	setInterval(function() {
+		sendStatus(rotator, true);
+	}, heartbeatSeconds * 1000);
+
+	// Connect to WebSocket server (provides control)
+	wbdConnect(document.body.getAttribute('data-websocket-url'), rotator);
+});
@@ -2,9 +2,14 @@ package web
 
 import (
 	"fmt"
+	"html/template"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/barracudanetworks/wbd/config"
 	"github.com/barracudanetworks/wbd/database"
@@ -15,6 +20,24 @@ import (
 type App struct {
 	Address  string
 	Database *database.Database
+	Sessions *sessionStore
+
+	// Proxies whose X-Forwarded-* headers are believed
+	TrustedProxies []*net.IPNet
+
+	// How long displays show URLs without a duration of their own
+	RotationDuration time.Duration
+
+	// How often the hub checks the database for changes and queued
+	// commands
+	PollInterval time.Duration
+
+	// Only show lists to approved clients, approving those that bring the
+	// enrollment token
+	RequireApproval bool
+	EnrollmentToken string
+
+	templates *template.Template
 }
 
 type Client struct {
@@ -31,19 +54,108 @@ func (c *Client) Touch() (err error) {
 func (a *App) GetClient(r *http.Request) (client Client) {
 	client.Database = a.Database
 
-	client.Id = r.FormValue("client")
+	// only look at the query string so request bodies are left unread
+	client.Id = r.URL.Query().Get("client")
 
-	// attempt to set via X-Forwarded-For header
-	client.RemoteAddr = r.Header.Get("X-Forwarded-For")
+	// use the ip address from the request, minus the port
+	client.RemoteAddr = remoteHost(r)
 
-	// if none was set, use the ip address from the request, minus the port
-	if client.RemoteAddr == "" {
-		client.RemoteAddr = r.RemoteAddr[:strings.LastIndex(r.RemoteAddr, ":")]
+	// Behind trusted proxies, the client is the last address in
+	// X-Forwarded-For that isn't one of them. Each proxy appends the
+	// address it got the request from.
+	if !a.trusted(client.RemoteAddr) {
+		return
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(addr) == nil {
+			break
+		}
+
+		client.RemoteAddr = addr
+		if !a.trusted(addr) {
+			break
+		}
 	}
 
 	return
 }
 
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// trusted reports whether addr is one of the proxies allowed to set
+// X-Forwarded-* headers
+func (a *App) trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range a.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forwarded returns the value a trusted proxy put in a X-Forwarded-* header.
+// The first value is the one the client sent to the outermost proxy.
+func (a *App) forwarded(r *http.Request, header string) string {
+	if !a.trusted(remoteHost(r)) {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.Split(r.Header.Get(header), ",")[0])
+}
+
+// Scheme returns "https" if the client reached us over TLS, either directly
+// or through a trusted proxy that terminated it, and "http" otherwise
+func (a *App) Scheme(r *http.Request) string {
+	if r.TLS != nil || strings.EqualFold(a.forwarded(r, "X-Forwarded-Proto"), "https") {
+		return "https"
+	}
+
+	return "http"
+}
+
+// Host returns the host the client asked for, which a trusted proxy may
+// have passed on in X-Forwarded-Host
+func (a *App) Host(r *http.Request) string {
+	if host := a.forwarded(r, "X-Forwarded-Host"); host != "" {
+		return host
+	}
+
+	return r.Host
+}
+
+// WebsocketScheme returns the websocket scheme matching Scheme, so that pages
+// loaded over HTTPS don't make insecure connections
+func (a *App) WebsocketScheme(r *http.Request) string {
+	if a.Scheme(r) == "https" {
+		return "wss"
+	}
+
+	return "ws"
+}
+
+// render writes out one of the page templates
+func (a *App) render(w http.ResponseWriter, name string, data interface{}) {
+	if err := a.templates.ExecuteTemplate(w, name, data); err != nil {
+		log.Println(err)
+	}
+}
+
 func (a *App) Route(route string) http.Handler {
 	var handler http.Handler
 
@@ -55,7 +167,11 @@ func (a *App) Route(route string) http.Handler {
 	case route == "welcome":
 		handler = &welcomeHandler{*a}
 	case route == "console":
-		handler = &consoleHandler{*a}
+		handler = a.RequireRole(database.RoleViewer, &consoleHandler{*a})
+	case route == "login":
+		handler = &loginHandler{*a}
+	case route == "logout":
+		handler = &logoutHandler{*a}
 	}
 
 	wrapper := func(w http.ResponseWriter, r *http.Request) {
@@ -76,30 +192,105 @@ func (a *App) Route(route string) http.Handler {
 }
 
 func Start(c *config.Configuration) {
-	r := mux.NewRouter()
+	root := mux.NewRouter()
+	r := root
 
 	db, err := database.Connect(c.Database)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// Refuse to serve from a database that hasn't been migrated
+	if err := db.CheckSchema(); err != nil {
+		log.Fatal(err)
+	}
+
 	a := App{
-		Address:  c.WebAddress,
-		Database: db,
+		Address:          c.WebAddress,
+		Database:         db,
+		Sessions:         newSessionStore(c.SessionLifetime),
+		TrustedProxies:   c.TrustedProxies,
+		RotationDuration: c.RotationDuration,
+		PollInterval:     c.PollInterval,
+		RequireApproval:  c.RequireApproval,
+		EnrollmentToken:  c.EnrollmentToken,
+	}
+
+	a.templates, err = a.parseTemplates()
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	// Goroutine the websocket loop
 	go hub.run(&a)
 
+	if c.AdminSocket != "" {
+		go a.ServeAdmin(c.AdminSocket)
+	}
+
+	if c.BackupInterval > 0 {
+		go scheduleBackups(db, c)
+	}
+
+	// Everything lives under the base path, if there is one
+	if a.Address != "" {
+		r.Handle(a.Address, http.RedirectHandler(a.Address+"/", http.StatusMovedPermanently))
+		r = r.PathPrefix(a.Address).Subrouter()
+	}
+
+	r.HandleFunc("/static/{name}", serveStatic)
 	r.Handle("/", a.Route("index"))
 	r.Handle("/ws", a.Route("websocket"))
 	r.Handle("/welcome", a.Route("welcome"))
 	r.Handle("/console", a.Route("console"))
+	r.Handle("/login", a.Route("login"))
+	r.Handle("/logout", a.Route("logout"))
+	a.RegisterApi(r)
+	a.RegisterMetrics(r)
 
 	// Register mux router
-	http.Handle("/", r)
+	http.Handle("/", root)
 
 	addr := fmt.Sprintf("%s:%d", c.ListenAddress, c.ListenPort)
-	log.Printf("Web server listening on http://%s", addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
+
+	if c.TLSCert == "" {
+		log.Printf("Web server listening on http://%s", addr)
+		log.Fatal(http.ListenAndServe(addr, nil))
+	}
+
+	if c.RedirectPort != 0 {
+		redirectAddr := fmt.Sprintf("%s:%d", c.ListenAddress, c.RedirectPort)
+		log.Printf("Redirecting http://%s to HTTPS", redirectAddr)
+
+		go func() {
+			log.Fatal(http.ListenAndServe(redirectAddr, redirectToHttps(c.ListenPort)))
+		}()
+	}
+
+	log.Printf("Web server listening on https://%s", addr)
+	log.Fatal(http.ListenAndServeTLS(addr, c.TLSCert, c.TLSKey, nil))
+}
+
+// redirectToHttps sends plain HTTP requests to the same place on the HTTPS
+// port
+func redirectToHttps(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+
+		target := url.URL{
+			Scheme:   "https",
+			Host:     host,
+			Path:     r.URL.Path,
+			RawQuery: r.URL.RawQuery,
+		}
+
+		http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+	})
 }
@@ -3,10 +3,16 @@ package web
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/barracudanetworks/wbd/admin"
 	"github.com/barracudanetworks/wbd/database"
 	"github.com/gorilla/websocket"
 )
@@ -17,9 +23,16 @@ const (
 	pongWait = 60 * time.Second
 	pingWait = (pongWait * 9) / 10
 
-	urlPollWait = pongWait / 2
+	// Commands queued while the daemon was down are stale by the time it
+	// starts, so drop anything older than this many seconds
+	commandExpiry = 60
 
-	maxMessageSize = 512
+	defaultFlashDuration = 60
+
+	// Statuses and flashes carry whole URLs, which can be long
+	maxMessageSize = 64 * 1024
+
+	sendBufferSize = 16
 )
 
 type websocketMessage struct {
@@ -27,19 +40,154 @@ type websocketMessage struct {
 	Data   interface{} `json:"data"`
 }
 
+// decode unpacks the data of a message received from a client
+func (wm *websocketMessage) decode(v interface{}) error {
+	b, err := json.Marshal(wm.Data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+// A hubMessage is sent to every display matching Client (an identifier or
+// alias) and List. Leaving both empty sends it to all of them.
+type hubMessage struct {
+	Client  string
+	List    string
+	Message *websocketMessage
+}
+
+type flashRequest struct {
+	Url      string `json:"url"`
+	Duration int    `json:"duration"`
+	Client   string `json:"client,omitempty"`
+	List     string `json:"list,omitempty"`
+}
+
+func (f *flashRequest) hubMessage() *hubMessage {
+	if f.Duration <= 0 {
+		f.Duration = defaultFlashDuration
+	}
+
+	return &hubMessage{
+		Client: f.Client,
+		List:   f.List,
+		Message: &websocketMessage{
+			Action: "flashUrl",
+			Data: struct {
+				Url      string `json:"url"`
+				Duration int    `json:"duration"`
+			}{
+				f.Url,
+				f.Duration,
+			},
+		},
+	}
+}
+
+// Playback actions controllers can send to displays
+var controlActions = []string{"next", "previous", "pause", "resume", "reload", "disconnect"}
+
+var ErrInvalidControl = errors.New("Control action must be one of next, previous, pause, resume, reload or disconnect")
+
+func isControlAction(action string) bool {
+	for _, a := range controlActions {
+		if a == action {
+			return true
+		}
+	}
+
+	return false
+}
+
+type controlRequest struct {
+	Action string `json:"action"`
+	Client string `json:"client,omitempty"`
+	List   string `json:"list,omitempty"`
+}
+
+func (cr *controlRequest) hubMessage() *hubMessage {
+	return &hubMessage{
+		Client:  cr.Client,
+		List:    cr.List,
+		Message: &websocketMessage{Action: cr.Action},
+	}
+}
+
+// ControlCommand builds a command for the running daemon to pass a playback
+// action on to the targeted displays
+func ControlCommand(action string, client string, list string) (cmd database.Command, err error) {
+	if !isControlAction(action) {
+		err = ErrInvalidControl
+		return
+	}
+
+	cmd = database.Command{
+		Action: action,
+		Client: client,
+		List:   list,
+	}
+
+	return
+}
+
+// FlashCommand builds a command for the running daemon to show url on the
+// targeted displays for duration seconds
+func FlashCommand(url string, duration int, client string, list string) (cmd database.Command, err error) {
+	f := flashRequest{url, duration, client, list}
+	m := f.hubMessage()
+
+	data, err := json.Marshal(m.Message.Data)
+	if err != nil {
+		return
+	}
+
+	cmd = database.Command{
+		Action: m.Message.Action,
+		Data:   string(data),
+		Client: client,
+		List:   list,
+	}
+
+	return
+}
+
+type statusReport struct {
+	Client *websocketClient
+	Status database.ClientStatus
+}
+
 // The map is a bit weird in that it's pointer->string, but it's kind of a cheap
 // hack around issues with overwriting a connection that has the same client name
 type websocketHub struct {
-	broadcast   chan *websocketMessage
+	broadcast   chan *hubMessage
 	register    chan *websocketClient
 	unregister  chan *websocketClient
+	refresh     chan *websocketClient
+	changed     chan bool
+	inspect     chan func()
+	report      chan *statusReport
 	connections map[*websocketClient]string
+
+	// Last database revision and set of active schedules pushed out to
+	// clients
+	revision  int
+	schedules string
+	active    []database.Schedule
+
+	// Only approved clients are sent their URLs
+	requireApproval bool
 }
 
 var hub = websocketHub{
-	broadcast:   make(chan *websocketMessage),
+	broadcast:   make(chan *hubMessage),
 	register:    make(chan *websocketClient),
 	unregister:  make(chan *websocketClient),
+	refresh:     make(chan *websocketClient),
+	changed:     make(chan bool, 1),
+	inspect:     make(chan func()),
+	report:      make(chan *statusReport),
 	connections: make(map[*websocketClient]string),
 }
 
@@ -51,7 +199,14 @@ var upgrader = websocket.Upgrader{
 func (h *websocketHub) run(a *App) {
 	db := a.Database
 
-	ticker := time.NewTicker(urlPollWait)
+	revision, active, err := h.state(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	h.revision, h.schedules, h.active = revision, scheduleIds(active), active
+	h.requireApproval = a.RequireApproval
+
+	ticker := time.NewTicker(a.PollInterval)
 	defer func() {
 		ticker.Stop()
 	}()
@@ -61,14 +216,22 @@ func (h *websocketHub) run(a *App) {
 		// Save connection to hub
 		case c := <-h.register:
 			log.Printf("Added client '%s' to the hub", c.Id)
+			hubRegistrations.Inc()
 
 			if !c.Generic {
 				// get client info and touch last ping
 				client, err := db.GetClient(c.Id)
+				actor := database.Actor{Name: c.Id, Source: database.SourceWebsocket, Address: c.IpAddress}
+				enrolled := a.Enrolled(c.Enrollment)
 				switch {
+				case err == sql.ErrNoRows && h.requireApproval && !enrolled:
+					log.Printf("Unknown client, creating record awaiting approval")
+					if err := db.As(actor).InsertPendingClient(c.Id, c.IpAddress); err != nil {
+						log.Fatal(err)
+					}
 				case err == sql.ErrNoRows:
 					log.Printf("Unknown client, creating record")
-					if err := db.InsertClient(c.Id, c.IpAddress); err != nil {
+					if err := db.As(actor).InsertClient(c.Id, c.IpAddress); err != nil {
 						log.Fatal(err)
 					}
 				case err != nil:
@@ -79,60 +242,423 @@ func (h *websocketHub) run(a *App) {
 					c.Database = db
 					c.Touch()
 					c.UpdateIpAddress()
+
+					// Rejected clients stay rejected, token or not
+					if h.requireApproval && enrolled && client.Approval == database.ApprovalPending {
+						log.Printf("Approving client '%s' with the enrollment token", c.Id)
+						if err := db.As(actor).ApproveClient(c.Id); err != nil {
+							log.Fatal(err)
+						}
+					}
 				}
+
+				h.refreshList(db, c)
 			} else {
 				log.Printf("Not attempting to track generic client")
+
+				// There's no record to approve, so nothing is shown
+				c.Approved = !h.requireApproval
 			}
 
 			h.connections[c] = c.Id
 
+			if !c.Generic {
+				h.sendControllers(h.clientUpdateMessage())
+			}
+
+			// Everyone on a staggered list moves over to make room
+			if c.List.Mode == database.ModeStagger {
+				h.sendListUpdates(db, c.List.Id)
+			}
+
 		// Remove connection from hub
 		case c := <-h.unregister:
+			hubUnregistrations.Inc()
+
 			if _, ok := h.connections[c]; ok {
 				log.Printf("Removing client '%s'", c.Id)
-				h.CloseConnection(c)
+				h.CloseConnection(c, closeDisconnected)
+
+				if !c.Generic {
+					h.sendControllers(h.clientUpdateMessage())
+				}
+
+				if c.List.Mode == database.ModeStagger {
+					h.sendListUpdates(db, c.List.Id)
+				}
+			}
+
+		// Send URLs to a client that asked for them
+		case c := <-h.refresh:
+			if _, ok := h.connections[c]; ok {
+				h.sendUrlUpdate(db, c, true)
 			}
 
 		// Broadcast messages to clients
 		case m := <-h.broadcast:
-			for c := range h.connections {
-				select {
-				case c.send <- m:
-					log.Printf("Sent message to client '%s', type '%s'", c.Id, m.Action)
-				default:
-					h.CloseConnection(c)
-				}
+			h.dispatch(db, m)
+
+		// Changes made through this daemon don't need to wait for the poll
+		case <-h.changed:
+			h.runCommands(db)
+			h.pushChanges(db)
+
+		// Look at the hub's state from outside
+		case f := <-h.inspect:
+			f()
+
+		// Keep track of what displays are showing
+		case r := <-h.report:
+			if _, ok := h.connections[r.Client]; ok {
+				h.updateStatus(db, r.Client, r.Status)
 			}
 
-		// Send out URL updates every so often
+		// Pass on commands queued by the CLI and pick up changes made
+		// by other processes
 		case <-ticker.C:
-			log.Print("Polling for URL changes in database")
+			h.runCommands(db)
+			h.pushChanges(db)
+		}
+	}
+}
 
-			// Send the JSON to all connected clients
-			for c := range h.connections {
-				urls, err := db.FetchUrlsByClientId(c.Id)
-				if err != nil && err != sql.ErrNoRows {
-					log.Fatal(err)
-				}
+// Connections describes the live websocket connections
+func (h *websocketHub) Connections() (connections []admin.Connection) {
+	done := make(chan bool)
+	h.inspect <- func() {
+		for c := range h.connections {
+			connections = append(connections, admin.Connection{
+				Id:         c.Id,
+				IpAddress:  c.IpAddress,
+				Controller: c.Controller,
+				Generic:    c.Generic,
+				List:       c.List.Name,
+				Status:     c.Status,
+			})
+		}
 
-				urlWm, err := urlUpdateMessage(urls)
-				if err != nil {
-					log.Fatal(err)
-				}
+		close(done)
+	}
+	<-done
 
-				select {
-				case c.send <- urlWm:
-					log.Printf("Sent updated URL list to client '%s'", c.Id)
-				default:
-					h.CloseConnection(c)
-				}
+	sort.Slice(connections, func(i, j int) bool {
+		return connections[i].Id < connections[j].Id
+	})
+
+	return
+}
+
+// updateStatus records a display's status, and lets controllers know when
+// it's showing something different
+func (h *websocketHub) updateStatus(db *database.Database, c *websocketClient, status database.ClientStatus) {
+	previous := c.Status
+	c.Status = &status
+
+	if !c.Generic {
+		if err := db.SetClientStatus(c.Id, status); err != nil {
+			log.Print(err)
+		}
+	}
+
+	// Heartbeats only move the uptime on
+	if previous != nil {
+		status.Uptime, status.Updated = previous.Uptime, previous.Updated
+		if status == *previous {
+			return
+		}
+	}
+
+	h.sendControllers(clientStatusMessage(c.Id, *c.Status))
+}
+
+// sendControllers passes a message on to every controller, such as the
+// dashboard
+func (h *websocketHub) sendControllers(wm *websocketMessage) {
+	for c := range h.connections {
+		if !c.Controller {
+			continue
+		}
+
+		h.send(c, wm)
+	}
+}
+
+// send passes a message on to a client, dropping it if it can't keep up
+func (h *websocketHub) send(c *websocketClient, wm *websocketMessage) {
+	select {
+	case c.send <- wm:
+	default:
+		h.CloseConnection(c, closeDropped)
+	}
+}
+
+// reply sends a client a message from its readPump. The hub owns the send
+// channel, which it closes when the client goes, so the message is handed
+// over to it. Messages for clients that have gone are dropped.
+func (h *websocketHub) reply(c *websocketClient, message func() *websocketMessage) {
+	h.inspect <- func() {
+		if _, ok := h.connections[c]; ok {
+			h.send(c, message())
+		}
+	}
+}
+
+// Changed tells the hub the database was just modified
+func (h *websocketHub) Changed() {
+	select {
+	case h.changed <- true:
+	default:
+	}
+}
+
+// state returns what decides which URLs clients are shown: the database
+// revision, and which schedules are active right now
+func (h *websocketHub) state(db *database.Database) (revision int, active []database.Schedule, err error) {
+	revision, err = db.Revision()
+	if err != nil {
+		return
+	}
+
+	active, err = db.ActiveSchedules(time.Now())
+
+	return
+}
+
+// scheduleIds identifies a set of active schedules, to tell when it changes
+func scheduleIds(active []database.Schedule) string {
+	var ids []string
+	for _, s := range active {
+		ids = append(ids, strconv.Itoa(s.Id))
+	}
+
+	return strings.Join(ids, ",")
+}
+
+// pushChanges sends URLs to the clients whose list changed since the last
+// revision of the database, or since a schedule started or ended
+func (h *websocketHub) pushChanges(db *database.Database) {
+	revision, active, err := h.state(db)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	schedules := scheduleIds(active)
+	switch {
+	case revision != h.revision:
+		log.Printf("Database changed (revision %d), updating clients", revision)
+	case schedules != h.schedules:
+		log.Printf("Active schedules changed, updating clients")
+	default:
+		return
+	}
+	h.revision, h.schedules, h.active = revision, schedules, active
+
+	// Pick up list changes before working out stagger offsets
+	for c := range h.connections {
+		if !c.Generic {
+			h.refreshList(db, c)
+		}
+	}
+
+	for c := range h.connections {
+		h.sendUrlUpdate(db, c, false)
+	}
+}
+
+// dispatch sends a message to the displays it targets. Controllers never
+// receive these, and generic clients only get messages meant for everyone.
+func (h *websocketHub) dispatch(db *database.Database, m *hubMessage) {
+	var client string
+	if m.Client != "" {
+		info, err := db.GetClient(m.Client)
+		if err == sql.ErrNoRows {
+			log.Printf("Not sending '%s' to unknown client '%s'", m.Message.Action, m.Client)
+			return
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		client = info.Identifier
+	}
+
+	list := -1
+	if m.List != "" {
+		id, err := db.FindListId(m.List)
+		if err == sql.ErrNoRows {
+			log.Printf("Not sending '%s' to unknown list '%s'", m.Message.Action, m.List)
+			return
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		list = id
+	}
+
+	for c := range h.connections {
+		if c.Controller || (c.Generic && (client != "" || list >= 0)) {
+			continue
+		}
+
+		// Unapproved displays stay on the welcome page
+		if !c.Approved && m.Message.Action != "disconnect" {
+			continue
+		}
+		if (client != "" && c.Id != client) || (list >= 0 && c.List.Id != list) {
+			continue
+		}
+
+		// Dropped displays reconnect by themselves
+		if m.Message.Action == "disconnect" {
+			h.CloseConnection(c, closeKicked)
+			continue
+		}
+
+		select {
+		case c.send <- m.Message:
+			log.Printf("Sent message to client '%s', type '%s'", c.Id, m.Message.Action)
+		default:
+			h.CloseConnection(c, closeDropped)
+		}
+	}
+}
+
+func (h *websocketHub) runCommands(db *database.Database) {
+	cmds, err := db.FetchCommands()
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	for _, cmd := range cmds {
+		// Remove the command first so it's never delivered twice
+		if err := db.DeleteCommand(cmd.Id); err != nil {
+			log.Print(err)
+			return
+		}
+
+		if cmd.Age > commandExpiry {
+			log.Printf("Dropping '%s' command queued %d seconds ago", cmd.Action, cmd.Age)
+			continue
+		}
+
+		var data interface{}
+		if cmd.Data != "" {
+			if err := json.Unmarshal([]byte(cmd.Data), &data); err != nil {
+				log.Print(err)
+				continue
 			}
 		}
+
+		log.Printf("Running queued '%s' command", cmd.Action)
+		h.dispatch(db, &hubMessage{
+			Client:  cmd.Client,
+			List:    cmd.List,
+			Message: &websocketMessage{cmd.Action, data},
+		})
+	}
+}
+
+// refreshList works out which list a client is showing, using the schedules
+// that were active at the last poll
+func (h *websocketHub) refreshList(db *database.Database, c *websocketClient) {
+	list, err := db.GetScheduledList(c.Id, h.active)
+	if err != nil && err != sql.ErrNoRows {
+		log.Fatal(err)
+	}
+
+	c.List = list
+
+	c.Approved = true
+	if h.requireApproval {
+		info, err := db.GetClient(c.Id)
+		if err != nil && err != sql.ErrNoRows {
+			log.Fatal(err)
+		}
+
+		c.Approved = info.Approval == database.ApprovalApproved
+	}
+}
+
+// sendUrlUpdate sends a client its URLs. Unless forced, nothing is sent if
+// they haven't changed since the client was last sent them.
+func (h *websocketHub) sendUrlUpdate(db *database.Database, c *websocketClient, force bool) {
+	// The list was worked out by refreshList; generic clients have none
+	var urls []database.ListUrl
+	var err error
+	if !c.Generic {
+		urls, err = db.FetchListUrlsById(c.List.Id)
+		if err != nil && err != sql.ErrNoRows {
+			log.Fatal(err)
+		}
+	}
+
+	// With nothing to show, displays fall back to the welcome page
+	if !c.Approved {
+		urls = nil
+	}
+
+	urlWm, err := urlUpdateMessage(urls, c.List.Mode, h.staggerOffset(c))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sent, err := json.Marshal(urlWm.Data)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if !force && string(sent) == c.sentUrls {
+		return
+	}
+	c.sentUrls = string(sent)
+
+	select {
+	case c.send <- urlWm:
+		log.Printf("Sent updated URL list to client '%s'", c.Id)
+	default:
+		h.CloseConnection(c, closeDropped)
+	}
+}
+
+// sendListUpdates sends URLs to every client showing a list
+func (h *websocketHub) sendListUpdates(db *database.Database, id int) {
+	for c := range h.connections {
+		if !c.Generic && c.List.Id == id {
+			h.sendUrlUpdate(db, c, false)
+		}
 	}
 }
 
-func (h *websocketHub) CloseConnection(c *websocketClient) {
-	log.Printf("Closing connection to client '%s'", c.Id)
+// staggerOffset spreads the clients showing a staggered list across its URLs
+// by giving each its rank among the connected clients on that list
+func (h *websocketHub) staggerOffset(c *websocketClient) int {
+	if c.List.Mode != database.ModeStagger {
+		return 0
+	}
+
+	seen := make(map[string]bool)
+	var ids []string
+	for other := range h.connections {
+		if other.Generic || !other.Approved || other.List.Id != c.List.Id || seen[other.Id] {
+			continue
+		}
+
+		seen[other.Id] = true
+		ids = append(ids, other.Id)
+	}
+
+	sort.Strings(ids)
+
+	return sort.SearchStrings(ids, c.Id)
+}
+
+func (h *websocketHub) CloseConnection(c *websocketClient, reason string) {
+	log.Printf("Closing connection to client '%s' (%s)", c.Id, reason)
+	connectionsClosed.WithLabelValues(reason).Inc()
+
 	close(c.send)
 	delete(h.connections, c)
 }
@@ -142,10 +668,28 @@ type websocketClient struct {
 	IpAddress  string
 	Controller bool
 	Generic    bool
-	Database   *database.Database
+
+	// Role looks up what the user who opened the connection may do, or
+	// empty for displays and anyone not logged in. It's asked again for
+	// every action, so removed users and revoked tokens lose access at once.
+	Role func() database.Role
+
+	// Enrollment is the token the display was opened with, if any, and
+	// Approved whether it may be shown its URLs
+	Enrollment string
+	Approved   bool
+
+	Database *database.Database
+	List     database.List
+
+	// What the display last reported it was doing
+	Status *database.ClientStatus
 
 	ws   *websocket.Conn
 	send chan *websocketMessage
+
+	// The last updateUrls payload sent, to avoid repeating it
+	sentUrls string
 }
 
 func NewWebsocketClient(db *database.Database, ws *websocket.Conn, id string, ipAddress string) (wc *websocketClient) {
@@ -170,13 +714,31 @@ func NewWebsocketClient(db *database.Database, ws *websocket.Conn, id string, ip
 		Generic:    generic,
 		Database:   db,
 
-		send: make(chan *websocketMessage),
+		send: make(chan *websocketMessage, sendBufferSize),
 		ws:   ws,
 	}
 
 	return
 }
 
+// refusal is the message turning a client away from something that needs
+// the given role, or nil if it has that role
+func (c *websocketClient) refusal(needed database.Role) *websocketMessage {
+	var role database.Role
+	if c.Role != nil {
+		role = c.Role()
+	}
+
+	switch {
+	case role.Allows(needed):
+		return nil
+	case role == "":
+		return unauthorizedMessage()
+	default:
+		return forbiddenMessage(needed)
+	}
+}
+
 func (c *websocketClient) Touch() error {
 	log.Printf("Updating last active timestamp for client '%s'", c.Id)
 	return c.Database.TouchClient(c.Id)
@@ -218,6 +780,7 @@ func (c *websocketClient) writePump() {
 				log.Print(err)
 				return
 			}
+			messagesSent.WithLabelValues(message.Action).Inc()
 
 		// Send ping on timer
 		case <-ticker.C:
@@ -258,65 +821,143 @@ func (c *websocketClient) readPump(db *database.Database) {
 			log.Print(err)
 			break
 		}
+		messagesReceived.WithLabelValues(receivedAction(wm.Action)).Inc()
 
 		// Respond to a requested action by the client
 		switch wm.Action {
 		case "flagController":
-			log.Printf("Client '%s' flagged as a controller", c.Id)
-			c.Controller = true
-
-			urls, err := db.FetchUrlsByClientId(c.Id)
-			if err != nil && err != sql.ErrNoRows {
-				log.Fatal(err)
+			if refusal := c.refusal(database.RoleViewer); refusal != nil {
+				log.Printf("Refusing to flag unauthenticated client '%s' as a controller", c.Id)
+				hub.reply(c, func() *websocketMessage { return refusal })
+				break
 			}
 
-			urlWm, err := urlUpdateMessage(urls)
-			if err != nil {
-				log.Fatal(err)
+			log.Printf("Client '%s' flagged as a controller", c.Id)
+			hub.inspect <- func() {
+				c.Controller = true
 			}
 
-			clientWm := hub.clientUpdateMessage()
-
-			c.send <- urlWm
-			c.send <- clientWm
+			hub.refresh <- c
+			hub.reply(c, hub.clientUpdateMessage)
 		case "sendUrls":
 			log.Printf("Client '%s' requested URLs", c.Id)
 
-			urls, err := db.FetchUrlsByClientId(c.Id)
-			if err != nil && err != sql.ErrNoRows {
-				log.Fatal(err)
+			hub.refresh <- c
+		case "status":
+			var status database.ClientStatus
+			if err := wm.decode(&status); err != nil {
+				log.Printf("Invalid status from client '%s'", c.Id)
+				break
+			}
+			status.Updated = time.Now().UTC().Format("2006-01-02 15:04:05")
+
+			hub.report <- &statusReport{c, status}
+		case "flashUrl":
+			if refusal := c.refusal(database.RoleOperator); refusal != nil {
+				log.Printf("Refusing to flash a URL for client '%s' without the operator role", c.Id)
+				hub.reply(c, func() *websocketMessage { return refusal })
+				break
+			}
+
+			var flash flashRequest
+			if err := wm.decode(&flash); err != nil || flash.Url == "" {
+				log.Printf("Invalid flash request from client '%s'", c.Id)
+				break
 			}
 
-			urlWm, err := urlUpdateMessage(urls)
-			if err != nil {
-				log.Fatal(err)
+			log.Printf("Client '%s' flashed %s", c.Id, flash.Url)
+			hub.broadcast <- flash.hubMessage()
+		case "next", "previous", "pause", "resume", "reload", "disconnect":
+			if refusal := c.refusal(database.RoleOperator); refusal != nil {
+				log.Printf("Refusing to send '%s' for client '%s' without the operator role", wm.Action, c.Id)
+				hub.reply(c, func() *websocketMessage { return refusal })
+				break
 			}
 
-			c.send <- urlWm
+			control := controlRequest{Action: wm.Action}
+			if wm.Data != nil {
+				if err := wm.decode(&control); err != nil {
+					log.Printf("Invalid '%s' request from client '%s'", wm.Action, c.Id)
+					break
+				}
+				control.Action = wm.Action
+			}
+
+			log.Printf("Client '%s' sent '%s'", c.Id, wm.Action)
+			hub.broadcast <- control.hubMessage()
 		case "sendClients":
-			log.Printf("Client '%s' requested clients", c.Id)
+			if refusal := c.refusal(database.RoleViewer); refusal != nil {
+				log.Printf("Refusing to send clients to unauthenticated client '%s'", c.Id)
+				hub.reply(c, func() *websocketMessage { return refusal })
+				break
+			}
 
-			clientWm := hub.clientUpdateMessage()
+			log.Printf("Client '%s' requested clients", c.Id)
 
-			c.send <- clientWm
+			hub.reply(c, hub.clientUpdateMessage)
 		default:
 			log.Printf("Unknown action %s from client '%s'", wm.Action, c.Id)
 		}
 	}
 }
 
-func urlUpdateMessage(urls []string) (wm *websocketMessage, err error) {
+func urlUpdateMessage(urls []database.ListUrl, mode string, offset int) (wm *websocketMessage, err error) {
 	// Prevent empty slices from being marshalled into null
 	if urls == nil {
-		urls = make([]string, 0)
+		urls = make([]database.ListUrl, 0)
 	}
 
 	wm = &websocketMessage{
 		Action: "updateUrls",
 		Data: struct {
-			URLs []string `json:"urls"`
+			URLs   []database.ListUrl `json:"urls"`
+			Mode   string             `json:"mode"`
+			Offset int                `json:"offset"`
 		}{
 			urls,
+			mode,
+			offset,
+		},
+	}
+
+	return
+}
+
+func clientStatusMessage(id string, status database.ClientStatus) (wm *websocketMessage) {
+	wm = &websocketMessage{
+		Action: "clientStatus",
+		Data: struct {
+			Client string                `json:"client"`
+			Status database.ClientStatus `json:"status"`
+		}{
+			id,
+			status,
+		},
+	}
+
+	return
+}
+
+func unauthorizedMessage() (wm *websocketMessage) {
+	wm = &websocketMessage{
+		Action: "unauthorized",
+		Data: struct {
+			Message string `json:"message"`
+		}{
+			"Login required",
+		},
+	}
+
+	return
+}
+
+func forbiddenMessage(needed database.Role) (wm *websocketMessage) {
+	wm = &websocketMessage{
+		Action: "forbidden",
+		Data: struct {
+			Message string `json:"message"`
+		}{
+			fmt.Sprintf("This needs the %s role", needed),
 		},
 	}
 
@@ -0,0 +1,156 @@
+package web
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/barracudanetworks/wbd/database"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// testApp is shared by the package's tests, since there's only one hub
+var testApp *App
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "wbd-web")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := database.Connect(filepath.Join(dir, "wbd.db"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = db.CreateTables(); err != nil {
+		log.Fatal(err)
+	}
+
+	testApp = &App{
+		Database:         db,
+		Sessions:         newSessionStore(time.Hour),
+		RotationDuration: time.Minute,
+		PollInterval:     50 * time.Millisecond,
+	}
+	if testApp.templates, err = testApp.parseTemplates(); err != nil {
+		log.Fatal(err)
+	}
+
+	go hub.run(testApp)
+
+	code := m.Run()
+
+	db.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// dial opens a websocket to server with the given query string and headers
+func dial(t *testing.T, server *httptest.Server, query string, header http.Header) *websocket.Conn {
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?"+query, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ws
+}
+
+// readAction waits for the next message with the given action, failing if
+// something is refused on the way
+func readAction(t *testing.T, ws *websocket.Conn, action string) (wm websocketMessage) {
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if err := ws.ReadJSON(&wm); err != nil {
+			t.Fatal(err)
+		}
+		if wm.Action == action {
+			return
+		}
+		if wm.Action == "unauthorized" || wm.Action == "forbidden" {
+			t.Fatalf("Refused while waiting for %s", action)
+		}
+	}
+}
+
+func TestLongStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(testApp.Route("websocket"))
+	defer server.Close()
+
+	ws := dial(t, server, "client=long-status", nil)
+	defer ws.Close()
+
+	// Dashboards often have very long links
+	url := "http://grafana.example.com/d/abc?" + strings.Repeat("var-host=x&", 200)
+	assert.True(len(url) > 2000)
+
+	status, _ := json.Marshal(websocketMessage{"status", database.ClientStatus{Url: url, Position: 1, Count: 1, Loaded: true}})
+	assert.Nil(ws.WriteMessage(websocket.TextMessage, status))
+
+	assert.Eventually(func() bool {
+		saved, err := testApp.Database.GetClientStatus("long-status")
+		return err == nil && saved.Url == url
+	}, 2*time.Second, 20*time.Millisecond)
+
+	// The connection is still open afterwards
+	assert.Nil(ws.WriteJSON(websocketMessage{"sendUrls", nil}))
+	readAction(t, ws, "updateUrls")
+}
+
+func TestControllerRole(t *testing.T) {
+	assert := assert.New(t)
+
+	db := testApp.Database
+	assert.Nil(db.InsertUser("ws-admin", database.RoleAdmin, "secret"))
+	assert.Nil(db.InsertUser("ws-operator", database.RoleOperator, "secret"))
+	defer db.DeleteUser("ws-admin")
+
+	token, err := testApp.Sessions.Create("ws-operator")
+	assert.Nil(err)
+
+	server := httptest.NewServer(testApp.Route("websocket"))
+	defer server.Close()
+
+	ws := dial(t, server, "", http.Header{"Cookie": {sessionCookie + "=" + token}})
+	defer ws.Close()
+
+	flash := websocketMessage{"flashUrl", flashRequest{Url: "http://example.com/", Duration: 5}}
+
+	assert.Nil(ws.WriteJSON(flash))
+	assert.Nil(ws.WriteJSON(websocketMessage{"sendClients", nil}))
+	readAction(t, ws, "updateClients")
+
+	// A change of role applies to the open connection, which is told it's
+	// lacking the role rather than asked to log in
+	assert.Nil(db.SetUserRole("ws-operator", database.RoleViewer))
+	assert.Nil(ws.WriteJSON(flash))
+	refused := readAction(t, ws, "forbidden")
+	assert.Equal(map[string]interface{}{"message": "This needs the operator role"}, refused.Data)
+
+	assert.Nil(ws.WriteJSON(websocketMessage{"sendClients", nil}))
+	readAction(t, ws, "updateClients")
+
+	assert.Nil(db.DeleteUser("ws-operator"))
+	assert.Nil(ws.WriteJSON(websocketMessage{"sendClients", nil}))
+	readAction(t, ws, "unauthorized")
+}
+
+func TestReplyAfterClose(t *testing.T) {
+	// The hub closes a client's channel when dropping it, which a late reply
+	// mustn't send on
+	c := &websocketClient{Id: "dropped", send: make(chan *websocketMessage, 1)}
+	close(c.send)
+
+	hub.reply(c, unauthorizedMessage)
+
+	// The hub has finished with the reply once it takes something else
+	hub.inspect <- func() {}
+}